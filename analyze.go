@@ -0,0 +1,557 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type FindingKind int
+
+const (
+	FindingAlwaysTrue  FindingKind = iota + 1 // the condition is true for all the inputs
+	FindingAlwaysFalse                        // the condition is false for all the inputs
+	FindingDeadBranch                         // the branch of x if c else y is never taken
+)
+
+var findingKindNameDict = map[FindingKind]string{
+	FindingAlwaysTrue:  "always true",
+	FindingAlwaysFalse: "always false",
+	FindingDeadBranch:  "dead branch",
+}
+
+func (k FindingKind) String() string {
+	return findingKindNameDict[k]
+}
+
+// Finding is a suspicious sub expression found by Analyze, Pos and End are the byte offset in the source
+type Finding struct {
+	Rule    string // the name of the rule, only set by AnalyzeRules
+	Kind    FindingKind
+	Pos     int
+	End     int
+	Text    string
+	Message string
+}
+
+func (f *Finding) String() string {
+	if f.Rule != "" {
+		return fmt.Sprintf("%v: %v, pos: %v", f.Rule, f.Message, f.Pos)
+	}
+	return fmt.Sprintf("%v, pos: %v", f.Message, f.Pos)
+}
+
+// Analyze find the conditions always true or always false, and the dead branches of x if c else y.
+// the var, or the sub expression with vars like len({{code}}), compared with the constants is reasoned about:
+// the numbers by the intervals, the strings and bools by the equality. the other conditions can be anything.
+// the conditions before in the and/or chain and the condition of x if c else y are taken into account,
+// like {{age}} > 10 is always true in {{age}} > 18 and {{age}} > 10.
+//
+// the numbers are treated as real numbers, {{a}} > 1 and {{a}} < 2 is not reported even if {{a}} is an integer.
+// the float equality is widened by the epsilon of the Praser, and a var may be NaN which fails all the comparisons
+// but !=, so {{a}} > 18 or {{a}} <= 18 is not always true.
+func (prog *Program) Analyze() []*Finding {
+	a := &analyzer{
+		prog:     prog,
+		constant: newEvaluator(context.Background(), prog.oper),
+		printer:  &astPrinter{decimalMode: prog.oper.decimalMode},
+		conds:    make(map[*astNode]*condition),
+	}
+	a.constant.budget = evalBudget{}
+	a.walk(prog.root, formulaAny, true)
+	sort.SliceStable(a.res, func(i, j int) bool { return a.res[i].Pos < a.res[j].Pos })
+	return a.res
+}
+
+// the Analyze of the str, see Program.Analyze
+func (p *Praser) Analyze(str string) ([]*Finding, error) {
+	prog, err := p.Compile(str)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Analyze(), nil
+}
+
+// AnalyzeRules analyze the rules by name, the findings are sorted by the rule name and the position
+func (p *Praser) AnalyzeRules(rules map[string]string) ([]*Finding, error) {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var res []*Finding
+	for _, name := range names {
+		prog, err := p.Compile(rules[name])
+		if err != nil {
+			engineErr := err.(*EngineErr)
+			return nil, GetError(engineErr.ErrCode, fmt.Sprintf("rule %v: %v", name, engineErr.ErrMsg))
+		}
+		for _, f := range prog.Analyze() {
+			f.Rule = name
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+// the kinds of the values a subject compared with
+type domainKind int
+
+const (
+	domainNumber domainKind = iota + 1
+	domainString
+	domainBool
+	domainMixed // compared with the different types, nothing is known
+)
+
+// domain is the possible values of a subject
+type domain struct {
+	kind domainKind
+	// the interval of the number, lo and hi are -Inf and +Inf if no bound
+	lo, hi         float64
+	loOpen, hiOpen bool
+	neRanges       []numRange // the values not equal, the ranges are widened by the float epsilon
+	nan            bool       // NaN is possible, it is not in the interval
+	// the equality of the string and bool, the bool is "true" or "false"
+	eq       *string
+	ne       map[string]bool
+	conflict bool // equal to two different values
+}
+
+func (d *domain) empty() bool {
+	switch d.kind {
+	case domainNumber:
+		if d.nan {
+			return false
+		}
+		if d.lo > d.hi || d.lo == d.hi && (d.loOpen || d.hiOpen) {
+			return true
+		}
+		for _, r := range d.neRanges {
+			if r.covers(d) {
+				return true
+			}
+		}
+	case domainString, domainBool:
+		if d.conflict || d.eq != nil && d.ne[*d.eq] {
+			return true
+		}
+		if d.kind == domainBool && d.ne["true"] && d.ne["false"] {
+			return true
+		}
+	}
+	return false
+}
+
+// the values in both domains
+func (d *domain) intersect(other *domain) *domain {
+	if d.kind != other.kind {
+		return &domain{kind: domainMixed}
+	}
+	res := &domain{kind: d.kind, lo: d.lo, hi: d.hi, loOpen: d.loOpen, hiOpen: d.hiOpen, nan: d.nan && other.nan,
+		eq: d.eq, conflict: d.conflict}
+	switch d.kind {
+	case domainNumber:
+		if other.lo > res.lo || other.lo == res.lo && other.loOpen {
+			res.lo, res.loOpen = other.lo, other.loOpen
+		}
+		if other.hi < res.hi || other.hi == res.hi && other.hiOpen {
+			res.hi, res.hiOpen = other.hi, other.hiOpen
+		}
+		res.neRanges = append(append([]numRange(nil), d.neRanges...), other.neRanges...)
+	case domainString, domainBool:
+		if other.eq != nil {
+			if res.eq != nil && *res.eq != *other.eq {
+				res.conflict = true
+			}
+			res.eq = other.eq
+		}
+		res.ne = make(map[string]bool, len(d.ne)+len(other.ne))
+		for v := range d.ne {
+			res.ne[v] = true
+		}
+		for v := range other.ne {
+			res.ne[v] = true
+		}
+	}
+	return res
+}
+
+// numRange is the values equal to a number
+type numRange struct {
+	lo, hi         float64
+	loOpen, hiOpen bool
+}
+
+// the range of the values equal to v by the float equality, false if it can not be reasoned about
+func newNumRange(v float64, equal floatEqual) (numRange, bool) {
+	r := numRange{lo: v, hi: v}
+	if math.IsInf(v, 0) {
+		// Inf only equal to itself
+		return r, true
+	}
+	switch equal.mode {
+	case FloatEqualExact:
+	case FloatEqualRelative:
+		if equal.epsilon >= 1 {
+			return r, false
+		}
+		// |x - v| <= epsilon * max(|x|, |v|)
+		near, far := v*(1-equal.epsilon), v/(1-equal.epsilon)
+		if v > 0 {
+			r.lo, r.hi = near, far
+		} else if v < 0 {
+			r.lo, r.hi = far, near
+		}
+	case FloatEqualULP:
+		maxBits, b := orderedFloatBits(math.MaxFloat64), orderedFloatBits(v)
+		r.lo, r.hi = -math.MaxFloat64, math.MaxFloat64
+		if equal.ulps <= uint64(b)+uint64(maxBits) {
+			r.lo = orderedFloatFromBits(b - int64(equal.ulps))
+		}
+		if equal.ulps <= uint64(maxBits)-uint64(b) {
+			r.hi = orderedFloatFromBits(b + int64(equal.ulps))
+		}
+	default:
+		// |x - v| < epsilon, v itself is kept if the epsilon is lost in the rounding
+		if lo := v - equal.epsilon; lo < v {
+			r.lo, r.loOpen = lo, true
+		}
+		if hi := v + equal.epsilon; hi > v {
+			r.hi, r.hiOpen = hi, true
+		}
+	}
+	return r, true
+}
+
+func (r numRange) contains(v float64) bool {
+	return (v > r.lo || v == r.lo && !r.loOpen) && (v < r.hi || v == r.hi && !r.hiOpen)
+}
+
+// the interval of the domain is in the range
+func (r numRange) covers(d *domain) bool {
+	return (r.lo < d.lo || r.lo == d.lo && (!r.loOpen || d.loOpen)) &&
+		(r.hi > d.hi || r.hi == d.hi && (!r.hiOpen || d.hiOpen))
+}
+
+// term is the conjunction of the domains of the subjects, the key is the printed subject
+type term map[string]*domain
+
+// formula is the disjunction of the terms, the empty formula is never satisfied
+type formula []term
+
+// the limit of the terms, the formula is taken as any input if exceeded
+const maxFormulaTerms = 64
+
+// the formula satisfied by any input
+var formulaAny = formula{term{}}
+
+// the terms of x and y, nil if the result is empty
+func conjoin(x term, y term) term {
+	res := make(term, len(x)+len(y))
+	for k, d := range x {
+		res[k] = d
+	}
+	for k, d := range y {
+		if xd, ok := res[k]; ok {
+			d = xd.intersect(d)
+			if d.empty() {
+				return nil
+			}
+		}
+		res[k] = d
+	}
+	return res
+}
+
+// the formula of x and y
+func formulaAnd(x formula, y formula) formula {
+	if len(x)*len(y) > maxFormulaTerms {
+		return formulaAny
+	}
+	res := formula{}
+	for _, tx := range x {
+		for _, ty := range y {
+			if t := conjoin(tx, ty); t != nil {
+				res = append(res, t)
+			}
+		}
+	}
+	return res
+}
+
+// the formula of x or y
+func formulaOr(x formula, y formula) formula {
+	if len(x)+len(y) > maxFormulaTerms {
+		return formulaAny
+	}
+	return append(append(formula{}, x...), y...)
+}
+
+// condition is the formula of a boolean node and its negation
+type condition struct {
+	pos formula
+	neg formula
+}
+
+var negOperDict = map[int]int{'<': GE, GE: '<', '>': LE, LE: '>', EQ: NE, NE: EQ}
+
+// the operator when the operands are swapped
+var swapOperDict = map[int]int{'<': '>', '>': '<', LE: GE, GE: LE, EQ: EQ, NE: NE}
+
+type analyzer struct {
+	prog     *Program
+	constant *evaluator
+	printer  *astPrinter
+	conds    map[*astNode]*condition
+	res      []*Finding
+}
+
+func (a *analyzer) report(n *astNode, kind FindingKind, msg string) {
+	a.res = append(a.res, &Finding{Kind: kind, Pos: n.pos, End: n.end, Text: span(a.prog.source, n, a.printer).Text,
+		Message: msg})
+}
+
+// find the conditions in the node, ctx is what known to be true when the node is evaluated,
+// boolean means the node is used as a condition, like the operand of and.
+func (a *analyzer) walk(n *astNode, ctx formula, boolean bool) {
+	if boolean && n.nodeType != astNodeValue && isCondition(n) {
+		c := a.condition(n)
+		given := ""
+		if len(c.pos) > 0 && len(c.neg) > 0 {
+			given = " given the conditions before it"
+		}
+		if len(formulaAnd(ctx, c.pos)) == 0 {
+			a.report(n, FindingAlwaysFalse, "condition is always false"+given)
+			return
+		}
+		if len(formulaAnd(ctx, c.neg)) == 0 {
+			a.report(n, FindingAlwaysTrue, "condition is always true"+given)
+			return
+		}
+	}
+
+	switch {
+	case n.nodeType == astNodeBinary && (n.oper == AND || n.oper == OR):
+		// the operand is evaluated only if the operands before are true for and, false for or
+		operandCtx := ctx
+		for _, operand := range flattenOperands(n.oper, n, nil) {
+			a.walk(operand, operandCtx, true)
+			c := a.condition(operand)
+			if n.oper == AND {
+				operandCtx = formulaAnd(operandCtx, c.pos)
+			} else {
+				operandCtx = formulaAnd(operandCtx, c.neg)
+			}
+			if len(operandCtx) == 0 {
+				return
+			}
+		}
+	case n.nodeType == astNodeUnary && n.oper == NOT:
+		a.walk(n.children[0], ctx, true)
+	case n.nodeType == astNodeThirdOper:
+		x, cond, y := n.children[0], n.children[1], n.children[2]
+		c := a.condition(cond)
+		xCtx, yCtx := formulaAnd(ctx, c.pos), formulaAnd(ctx, c.neg)
+		// the condition itself is not reported if a branch is dead
+		switch {
+		case len(xCtx) == 0:
+			a.report(x, FindingDeadBranch, fmt.Sprintf("branch is never taken, %v is always false", a.printer.print(cond)))
+		case len(yCtx) == 0:
+			a.report(y, FindingDeadBranch, fmt.Sprintf("branch is never taken, %v is always true", a.printer.print(cond)))
+		default:
+			a.walk(cond, ctx, true)
+		}
+		if len(xCtx) > 0 {
+			a.walk(x, xCtx, boolean)
+		}
+		if len(yCtx) > 0 {
+			a.walk(y, yCtx, boolean)
+		}
+	default:
+		for _, child := range n.children {
+			a.walk(child, ctx, false)
+		}
+	}
+}
+
+// the operands of the chain like a and b and c
+func flattenOperands(oper int, n *astNode, operands []*astNode) []*astNode {
+	if n.nodeType == astNodeBinary && n.oper == oper {
+		for _, child := range n.children {
+			operands = flattenOperands(oper, child, operands)
+		}
+		return operands
+	}
+	return append(operands, n)
+}
+
+// the condition depends on the vars, the comparison or the node used as a condition, boolean means the latter
+func isVarCondition(n *astNode, boolean bool) bool {
+	return n.nodeType != astNodeValue && hasVar(n) &&
+		(boolean && isCondition(n) || n.nodeType == astNodeBinary && compareOperSet[n.oper])
+}
+
+// the node is worth reporting, the ternary is not, its branches are reported
+func isCondition(n *astNode) bool {
+	switch n.nodeType {
+	case astNodeVar:
+		return true
+	case astNodeUnary:
+		return n.oper == NOT
+	case astNodeBinary:
+		return compareOperSet[n.oper] || n.oper == AND || n.oper == OR
+	case astNodeFunc:
+		return funcTypeDict[n.funcName()].result == "bool"
+	}
+	return false
+}
+
+func (a *analyzer) condition(n *astNode) *condition {
+	if c, ok := a.conds[n]; ok {
+		return c
+	}
+	c := a.buildCondition(n)
+	a.conds[n] = c
+	return c
+}
+
+func (a *analyzer) buildCondition(n *astNode) *condition {
+	if !hasVar(n) {
+		value, err := a.constant.eval(n)
+		if err != nil || value.ValueType != ValueTypeBool {
+			return &condition{pos: formulaAny, neg: formulaAny}
+		}
+		if value.GetBool() {
+			return &condition{pos: formulaAny, neg: formula{}}
+		}
+		return &condition{pos: formula{}, neg: formulaAny}
+	}
+
+	switch n.nodeType {
+	case astNodeUnary:
+		if n.oper == NOT {
+			c := a.condition(n.children[0])
+			return &condition{pos: c.neg, neg: c.pos}
+		}
+	case astNodeBinary:
+		switch n.oper {
+		case AND:
+			x, y := a.condition(n.children[0]), a.condition(n.children[1])
+			return &condition{pos: formulaAnd(x.pos, y.pos), neg: formulaOr(x.neg, y.neg)}
+		case OR:
+			x, y := a.condition(n.children[0]), a.condition(n.children[1])
+			return &condition{pos: formulaOr(x.pos, y.pos), neg: formulaAnd(x.neg, y.neg)}
+		}
+		if compareOperSet[n.oper] {
+			return a.compareCondition(n)
+		}
+	case astNodeThirdOper:
+		x, c, y := a.condition(n.children[0]), a.condition(n.children[1]), a.condition(n.children[2])
+		return &condition{
+			pos: formulaOr(formulaAnd(c.pos, x.pos), formulaAnd(c.neg, y.pos)),
+			neg: formulaOr(formulaAnd(c.pos, x.neg), formulaAnd(c.neg, y.neg)),
+		}
+	case astNodeVar, astNodeFunc:
+		// the bool subject, like {{active}} or startWith({{code}}, "A")
+		return a.atom(n, EQ, GetTokenNode(ValueTypeBool, true))
+	}
+	return &condition{pos: formulaAny, neg: formulaAny}
+}
+
+// the comparison of a subject and a constant, like {{age}} > 18 or 6 == len({{code}})
+func (a *analyzer) compareCondition(n *astNode) *condition {
+	subject, other, oper := n.children[0], n.children[1], n.oper
+	if !hasVar(subject) {
+		subject, other, oper = other, subject, swapOperDict[oper]
+	}
+	if hasVar(other) {
+		return &condition{pos: formulaAny, neg: formulaAny}
+	}
+	value, err := a.constant.eval(other)
+	if err != nil {
+		return &condition{pos: formulaAny, neg: formulaAny}
+	}
+	return a.atom(subject, oper, value)
+}
+
+// the condition of subject oper value
+func (a *analyzer) atom(subject *astNode, oper int, value *TokenNode) *condition {
+	// the decimal, and the integers like len({{code}}) == 6, are compared exactly
+	integerSubject := subject.nodeType == astNodeFunc && funcTypeDict[subject.funcName()].result == "integer"
+	equal := a.prog.oper.floatEqual
+	if a.prog.oper.decimalMode || value.ValueType == ValueTypeDecimal || value.ValueType == ValueTypeBigInt ||
+		integerSubject && value.ValueType == ValueTypeInteger {
+		equal = floatEqual{mode: FloatEqualExact}
+	}
+	pos, ok := newDomain(oper, value, equal)
+	if !ok {
+		return &condition{pos: formulaAny, neg: formulaAny}
+	}
+	neg, _ := newDomain(negOperDict[oper], value, equal)
+	if pos.kind == domainNumber {
+		// NaN fails the comparison, so it is in the negation, the integers are never NaN
+		pos.nan, neg.nan = oper == NE && !integerSubject, oper != NE && !integerSubject
+	}
+	key := a.printer.print(subject)
+	return &condition{pos: formula{term{key: pos}}, neg: formula{term{key: neg}}}
+}
+
+// the values satisfy x oper value, the numbers are equal by the equal
+func newDomain(oper int, value *TokenNode, equal floatEqual) (*domain, bool) {
+	switch value.ValueType {
+	case ValueTypeInteger, ValueTypeBigInt, ValueTypeFloat, ValueTypeDecimal:
+		v := value.GetFloat()
+		if math.IsNaN(v) {
+			return nil, false
+		}
+		// the integer beyond 2^53 can not be kept exactly in float64, like the int64 id
+		if value.ValueType == ValueTypeBigInt ||
+			value.ValueType == ValueTypeInteger && (value.GetInt() > 1<<53 || value.GetInt() < -(1<<53)) {
+			return nil, false
+		}
+		d := &domain{kind: domainNumber, lo: math.Inf(-1), hi: math.Inf(1)}
+		switch oper {
+		case '<':
+			d.hi, d.hiOpen = v, true
+		case LE:
+			d.hi = v
+		case '>':
+			d.lo, d.loOpen = v, true
+		case GE:
+			d.lo = v
+		case EQ, NE:
+			r, ok := newNumRange(v, equal)
+			if !ok {
+				return nil, false
+			}
+			if oper == EQ {
+				d.lo, d.hi, d.loOpen, d.hiOpen = r.lo, r.hi, r.loOpen, r.hiOpen
+			} else {
+				d.neRanges = []numRange{r}
+			}
+		}
+		return d, true
+	case ValueTypeString, ValueTypeBool:
+		var d *domain
+		var s string
+		if value.ValueType == ValueTypeBool {
+			d, s = &domain{kind: domainBool}, fmt.Sprint(value.GetBool())
+		} else {
+			d, s = &domain{kind: domainString}, value.GetString()
+		}
+		switch oper {
+		case EQ:
+			d.eq = &s
+		case NE:
+			d.ne = map[string]bool{s: true}
+		default:
+			// the order of the strings is not reasoned about
+			return nil, false
+		}
+		return d, true
+	}
+	return nil, false
+}
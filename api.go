@@ -1,7 +1,9 @@
 package rule_engine
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 
 	"github.com/shopspring/decimal"
 )
@@ -14,17 +16,18 @@ type Praser struct {
 // if don't require decimal, can set useDeicimal: false, will use float in calculate.
 // if not spefic the type in params, Praser will try to prase the type from value.
 // if spefic the type in params, Praser will use this type and analyze the value.
-func GetNewPraser(params []*Param, useDecimal bool) (*Praser, error) {
-	oper := &TokenOperator{
-		decimalMode: useDecimal,
-		varMap:      make(map[string]*TokenNode),
+// opts can change the default behavior, like WithDivisionPrecision, WithRoundingMode.
+func GetNewPraser(params []*Param, useDecimal bool, opts ...Option) (*Praser, error) {
+	oper, err := newTokenOperator(useDecimal, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	for _, param := range params {
 		if param == nil {
 			continue
 		}
-		node, err := parseParam(useDecimal, param)
+		node, err := oper.parseParam(param)
 		if err != nil {
 			return nil, err
 		}
@@ -33,22 +36,94 @@ func GetNewPraser(params []*Param, useDecimal bool) (*Praser, error) {
 	return &Praser{operator: oper}, nil
 }
 
+// use the struct or pointer to struct as the variables, the var path will be resolved when used.
+// the field can be got by the field name or the name in tag `rule:"name"`,
+// the nested struct, map with string key, slice and array can be got by path like {{order.items.0.price}}.
+// if meet nil pointer in the path, will return ErrRuleEngineUnknownVarName.
+func GetNewPraserFromStruct(env interface{}, useDecimal bool, opts ...Option) (*Praser, error) {
+	envValue, err := checkStructEnv(env)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]Option{WithVariableResolver(&structResolver{env: envValue})}, opts...)
+	return GetNewPraser(nil, useDecimal, opts...)
+}
+
+func newTokenOperator(useDecimal bool, opts []Option) (*TokenOperator, error) {
+	oper := &TokenOperator{
+		decimalMode:       useDecimal,
+		varMap:            make(map[string]*TokenNode),
+		divisionPrecision: defaultDivisionPrecision,
+		roundingMode:      RoundHalfUp,
+		floatEqual:        defaultFloatEqual,
+		regex:             regexConfig{cacheSize: defaultRegexCacheSize},
+	}
+
+	for _, opt := range opts {
+		opt(oper)
+	}
+	oper.regexCache = newRegexCache(oper.regex.cacheSize)
+	if _, ok := roundingModeNameDict[oper.roundingMode]; !ok {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("unknown rounding mode: %v", oper.roundingMode))
+	}
+	if !(oper.floatEqual.epsilon >= 0) {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("invalid float epsilon: %v", oper.floatEqual.epsilon))
+	}
+	return oper, nil
+}
+
+// parse the str and calculate the result
 func (p *Praser) Parse(str string) (*TokenNode, error) {
+	return p.ParseContext(context.Background(), str)
+}
+
+// the same as Parse, but stop when the ctx is done, see Program.EvalContext
+func (p *Praser) ParseContext(ctx context.Context, str string) (*TokenNode, error) {
+	prog, err := p.Compile(str)
+	if err != nil {
+		return nil, err
+	}
+	return prog.EvalContext(ctx)
+}
+
+// compile the str to a Program, which can be evaluated many times.
+func (p *Praser) Compile(str string) (*Program, error) {
+	prog, _, err := p.compile(str)
+	return prog, err
+}
+
+// compile the source, all the errors found are returned with the range in the source,
+// and err is the combination of them.
+func (p *Praser) compile(str string) (prog *Program, errs []*sourceErr, err error) {
+	limit := p.operator.limit
+	if limit.maxSourceLength > 0 && len(str) > limit.maxSourceLength {
+		err := GetError(ErrRuleEngineSourceTooLong,
+			fmt.Sprintf("source length %v exceed the limit %v", len(str), limit.maxSourceLength))
+		return nil, []*sourceErr{{err: err, pos: 0, end: len(str)}}, err
+	}
+
 	lex := NewRuleEngineLex(str, p.operator)
 
-	if res := ruleEngineParse(lex); res == Success {
-		return lex.resNode, nil
+	// the parser may recover from the syntax errors and succeed
+	if res := ruleEngineParse(lex); res != Success || len(lex.errs) > 0 {
+		return nil, lex.errs, lex.error()
+	}
+	if limit.maxDepth > 0 && lex.root.depth > limit.maxDepth {
+		err := GetError(ErrRuleEngineTooDeep,
+			fmt.Sprintf("expression depth %v exceed the limit %v", lex.root.depth, limit.maxDepth))
+		return nil, []*sourceErr{{err: err, pos: 0, end: len(str)}}, err
 	}
-	return nil, lex.err
+	return &Program{source: str, root: lex.root, oper: p.operator}, nil, nil
 }
 
 func (p *Praser) CheckValue(node *TokenNode, v interface{}) bool {
 	param := &Param{Value: v}
-	vnode, err := parseParam(p.operator.decimalMode, param)
+	vnode, err := p.operator.parseParam(param)
 	if err != nil {
 		return false
 	}
-	return node.Compare(vnode)
+	return node.compare(vnode, p.operator.floatEqual)
 }
 
 type Param struct {
@@ -86,6 +161,10 @@ func (t *TokenNode) GetInt() int64 {
 		return int64(t.Value.(float64))
 	case ValueTypeDecimal:
 		return t.GetDecimal().IntPart()
+	case ValueTypeBigInt:
+		if v := t.Value.(*big.Int); v.IsInt64() {
+			return v.Int64()
+		}
 	}
 	panic(fmt.Sprintf("invalid type change, from %v to int, value: %v",
 		valueTypeNameDict[t.ValueType], t.Value))
@@ -108,8 +187,11 @@ func (t *TokenNode) GetFloat() float64 {
 		return t.Value.(float64)
 	case ValueTypeDecimal:
 		return t.GetDecimal().InexactFloat64()
+	case ValueTypeBigInt:
+		f, _ := new(big.Float).SetInt(t.Value.(*big.Int)).Float64()
+		return f
 	}
-	panic(fmt.Sprintf("invalid type change, from %v to bool, value: %v",
+	panic(fmt.Sprintf("invalid type change, from %v to float, value: %v",
 		valueTypeNameDict[t.ValueType], t.Value))
 }
 
@@ -121,8 +203,21 @@ func (t *TokenNode) GetDecimal() decimal.Decimal {
 		return decimal.NewFromFloat(t.Value.(float64))
 	case ValueTypeDecimal:
 		return t.Value.(decimal.Decimal)
+	case ValueTypeBigInt:
+		return decimal.NewFromBigInt(t.Value.(*big.Int), 0)
 	}
-	panic(fmt.Sprintf("invalid type change, from %v to bool, value: %v",
+	panic(fmt.Sprintf("invalid type change, from %v to decimal, value: %v",
+		valueTypeNameDict[t.ValueType], t.Value))
+}
+
+func (t *TokenNode) GetBigInt() *big.Int {
+	switch t.ValueType {
+	case ValueTypeInteger:
+		return big.NewInt(t.Value.(int64))
+	case ValueTypeBigInt:
+		return t.Value.(*big.Int)
+	}
+	panic(fmt.Sprintf("invalid type change, from %v to bigint, value: %v",
 		valueTypeNameDict[t.ValueType], t.Value))
 }
 
@@ -135,7 +230,130 @@ func (t *TokenNode) GetString() string {
 	}
 }
 
+var accessorOperator = &TokenOperator{}
+var bigIntAccessorOperator = &TokenOperator{integerOverflow: IntegerOverflowBigInt}
+
+// the As* functions are the same as the GetX functions but return error instead of panic.
+// the conversion rules are the same as the built-in functions:
+//
+//	AsInt:     the same as int(), int, float, decimal, bigint and string can be changed to int
+//	AsFloat:   the same as float(), int, float, decimal, bigint and string can be changed to float
+//	AsDecimal: the same as decimal(), int, float, decimal, bigint and string can be changed to decimal
+//	AsString:  the same as string(), int, float, decimal, bigint and string can be changed to string
+//	AsBool:    only bool
+//	AsBigInt:  the same as int(), but the result can be larger than int64
+func (t *TokenNode) AsInt() (int64, error) {
+	res, err := t.asType(accessorOperator.funcInt)
+	if err != nil {
+		return 0, err
+	}
+	if res.ValueType == ValueTypeBigInt && !res.GetBigInt().IsInt64() {
+		return 0, GetError(ErrRuleEngineIntegerOverflow, fmt.Sprintf("value: %v", res.Value))
+	}
+	return res.GetInt(), nil
+}
+
+func (t *TokenNode) AsBigInt() (*big.Int, error) {
+	res, err := t.asType(bigIntAccessorOperator.funcInt)
+	if err != nil {
+		return nil, err
+	}
+	return res.GetBigInt(), nil
+}
+
+func (t *TokenNode) AsFloat() (float64, error) {
+	res, err := t.asType(accessorOperator.funcFloat)
+	if err != nil {
+		return 0, err
+	}
+	return res.GetFloat(), nil
+}
+
+func (t *TokenNode) AsDecimal() (decimal.Decimal, error) {
+	res, err := t.asType(accessorOperator.funcDecimal)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return res.GetDecimal(), nil
+}
+
+func (t *TokenNode) AsString() (string, error) {
+	res, err := t.asType(accessorOperator.funcString)
+	if err != nil {
+		return "", err
+	}
+	return res.GetString(), nil
+}
+
+func (t *TokenNode) AsBool() (bool, error) {
+	if t == nil || t.ValueType != ValueTypeBool {
+		return false, t.invalidTypeChange("bool")
+	}
+	return t.GetBool(), nil
+}
+
+func (t *TokenNode) asType(changeFunc func(argList []*TokenNode) (*TokenNode, error)) (*TokenNode, error) {
+	if t == nil {
+		return nil, t.invalidTypeChange("value")
+	}
+	return changeFunc([]*TokenNode{t})
+}
+
+func (t *TokenNode) invalidTypeChange(to string) error {
+	if t == nil {
+		return GetError(ErrRuleEngineInvalidVarType, fmt.Sprintf("invalid type change, from nil to %v", to))
+	}
+	return GetError(ErrRuleEngineInvalidVarType, fmt.Sprintf("invalid type change, from %v to %v, value: %v",
+		valueTypeNameDict[t.ValueType], to, t.Value))
+}
+
+// the result types can be got by ValueAs and EvalAs
+type ResultType interface {
+	int64 | float64 | bool | string | decimal.Decimal | *big.Int
+}
+
+// change the node value to T, by the As* functions of TokenNode.
+func ValueAs[T ResultType](t *TokenNode) (T, error) {
+	var res T
+	var value interface{}
+	var err error
+
+	switch any(res).(type) {
+	case int64:
+		value, err = t.AsInt()
+	case float64:
+		value, err = t.AsFloat()
+	case bool:
+		value, err = t.AsBool()
+	case string:
+		value, err = t.AsString()
+	case decimal.Decimal:
+		value, err = t.AsDecimal()
+	case *big.Int:
+		value, err = t.AsBigInt()
+	}
+	if err != nil {
+		return res, err
+	}
+	return value.(T), nil
+}
+
+// parse str by the praser and change the result to T.
+func EvalAs[T ResultType](p *Praser, str string) (T, error) {
+	node, err := p.Parse(str)
+	if err != nil {
+		var res T
+		return res, err
+	}
+	return ValueAs[T](node)
+}
+
+// compare the value of two node, float are compared with an absolute epsilon 1e-11.
 func (x *TokenNode) Compare(y *TokenNode) bool {
+	return x.compare(y, defaultFloatEqual)
+}
+
+func (x *TokenNode) compare(y *TokenNode, floatEqual floatEqual) bool {
 	if x.ValueType == ValueTypeNone || y.ValueType == ValueTypeNone {
 		return false
 	}
@@ -154,12 +372,17 @@ func (x *TokenNode) Compare(y *TokenNode) bool {
 		return x.GetString() == y.GetString()
 	}
 
-	if x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	if isNonFiniteFloat(x) || isNonFiniteFloat(y) {
+		return floatEqual.equal(x.GetFloat(), y.GetFloat())
+	}
+
+	if x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal ||
+		x.ValueType == ValueTypeBigInt || y.ValueType == ValueTypeBigInt {
 		return x.GetDecimal().Equal(y.GetDecimal())
 	}
 
 	if x.ValueType == ValueTypeFloat || y.ValueType == ValueTypeFloat {
-		return isFloatEqual(x.GetFloat(), y.GetFloat())
+		return floatEqual.equal(x.GetFloat(), y.GetFloat())
 	}
 
 	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
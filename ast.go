@@ -0,0 +1,108 @@
+package rule_engine
+
+import "fmt"
+
+type astNodeType int
+
+const (
+	astNodeValue     astNodeType = iota // literal value, like 1, "str", true
+	astNodeVar                          // variable, like {{a.b}}
+	astNodeUnary                        // -x, not x
+	astNodeBinary                       // x + y, x and y ...
+	astNodeThirdOper                    // x if c else y
+	astNodeFunc                         // len(x) ...
+	astNodeArgs                         // the argument list of func, only used when parsing
+)
+
+// astNode is the node of the syntax tree built by the parser.
+// pos and end are the byte offset of the node in the source string.
+type astNode struct {
+	nodeType astNodeType
+	oper     int        // the operator token, like '+', AND, for unary and binary node
+	value    *TokenNode // the literal value, or the string var name or func name
+	children []*astNode // the operands, for third oper node is x, c, y
+	depth    int        // the depth of the sub tree, leaf is 1
+	pos      int
+	end      int
+}
+
+var operNameDict = map[int]string{
+	'+': "+",
+	'-': "-",
+	'*': "*",
+	'/': "/",
+	'%': "%",
+	'>': ">",
+	'<': "<",
+	GE:  ">=",
+	LE:  "<=",
+	EQ:  "==",
+	NE:  "!=",
+	AND: "and",
+	OR:  "or",
+	NOT: "not",
+}
+
+func newTokenAstNode(value *TokenNode, pos int, end int) *astNode {
+	return &astNode{nodeType: astNodeValue, value: value, depth: 1, pos: pos, end: end}
+}
+
+func newVarAstNode(name *astNode, pos int, end int) *astNode {
+	return &astNode{nodeType: astNodeVar, value: name.value, depth: 1, pos: pos, end: end}
+}
+
+func newUnaryAstNode(oper int, x *astNode, pos int) *astNode {
+	return &astNode{nodeType: astNodeUnary, oper: oper, children: []*astNode{x}, depth: x.depth + 1, pos: pos, end: x.end}
+}
+
+func newBinaryAstNode(oper int, x *astNode, y *astNode) *astNode {
+	return &astNode{nodeType: astNodeBinary, oper: oper, children: []*astNode{x, y},
+		depth: maxDepth(x, y) + 1, pos: x.pos, end: y.end}
+}
+
+func newThirdOperAstNode(x *astNode, c *astNode, y *astNode) *astNode {
+	return &astNode{nodeType: astNodeThirdOper, children: []*astNode{x, c, y},
+		depth: maxDepth(x, c, y) + 1, pos: x.pos, end: y.end}
+}
+
+func newFuncAstNode(name *astNode, args *astNode, end int) (*astNode, error) {
+	funcName := name.value.GetString()
+	if _, ok := funcMap[funcName]; !ok {
+		return nil, GetError(ErrRuleEngineUnkonwnFunc, fmt.Sprintf("unknown func name: %v", funcName))
+	}
+
+	node := &astNode{nodeType: astNodeFunc, value: name.value, depth: 1, pos: name.pos, end: end}
+	if args != nil {
+		node.children = args.children
+		node.depth = args.depth + 1
+	}
+	return node, nil
+}
+
+func newArgsAstNode(args *astNode, arg *astNode) *astNode {
+	if args == nil {
+		return &astNode{nodeType: astNodeArgs, children: []*astNode{arg}, depth: arg.depth, pos: arg.pos, end: arg.end}
+	}
+	args.children = append(args.children, arg)
+	args.depth = maxDepth(args, arg)
+	args.end = arg.end
+	return args
+}
+
+func maxDepth(nodes ...*astNode) int {
+	depth := 0
+	for _, n := range nodes {
+		if n.depth > depth {
+			depth = n.depth
+		}
+	}
+	return depth
+}
+
+func (n *astNode) funcName() string {
+	return n.value.GetString()
+}
+
+func (n *astNode) varName() string {
+	return n.value.GetString()
+}
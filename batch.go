@@ -0,0 +1,175 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BatchResult is the result of a record in EvalBatch
+type BatchResult struct {
+	Value *TokenNode
+	Err   error
+}
+
+// RecordIterator give the records of EvalBatch one by one, return false when there is no more record.
+// the record is a VariableResolver, its variables are used before the variables of the Praser.
+type RecordIterator interface {
+	Next() (VariableResolver, bool)
+}
+
+// RecordIteratorFunc is an adapter to use a function as RecordIterator.
+type RecordIteratorFunc func() (VariableResolver, bool)
+
+func (f RecordIteratorFunc) Next() (VariableResolver, bool) {
+	return f()
+}
+
+type BatchOption func(c *batchConfig)
+
+type batchConfig struct {
+	workers int
+}
+
+// evaluate the records in n goroutines, default is 1, evaluate in the caller goroutine.
+func WithBatchWorkers(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.workers = n
+	}
+}
+
+// EvalBatch evaluate the program for each record, the results are in the order of the records.
+// the error of a record is in its result, and does not stop the others.
+// the program is lowered to bytecode once, and the buffers are reused between the records.
+// with more than one worker, the records are read in the caller goroutine while the workers evaluating.
+func (prog *Program) EvalBatch(ctx context.Context, records RecordIterator, opts ...BatchOption) []BatchResult {
+	config := batchConfig{workers: 1}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	vp := prog.Bytecode()
+	if config.workers <= 1 {
+		w := vp.newBatchWorker(ctx)
+		results := []BatchResult{}
+		for record, ok := records.Next(); ok; record, ok = records.Next() {
+			results = append(results, w.eval(record))
+		}
+		return results
+	}
+
+	// the records are read while the workers evaluating, at most n records are waiting
+	type batchJob struct {
+		index  int
+		record VariableResolver
+	}
+	jobCh := make(chan batchJob, config.workers)
+	results := []BatchResult{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < config.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := vp.newBatchWorker(ctx)
+			for job := range jobCh {
+				res := w.eval(job.record)
+				mu.Lock()
+				for len(results) <= job.index {
+					results = append(results, BatchResult{})
+				}
+				results[job.index] = res
+				mu.Unlock()
+			}
+		}()
+	}
+	index := 0
+	for record, ok := records.Next(); ok; record, ok = records.Next() {
+		jobCh <- batchJob{index: index, record: record}
+		index++
+	}
+	close(jobCh)
+	wg.Wait()
+	return results
+}
+
+// batchWorker keep the buffers reused between the records
+type batchWorker struct {
+	vp    *VMProgram
+	e     *evaluator
+	stack []vmValue
+}
+
+func (vp *VMProgram) newBatchWorker(ctx context.Context) *batchWorker {
+	return &batchWorker{vp: vp, e: newEvaluator(ctx, vp.oper), stack: make([]vmValue, 0, vp.maxStack)}
+}
+
+func (w *batchWorker) eval(record VariableResolver) BatchResult {
+	w.e.reset()
+	w.e.record = record
+	value, err := w.vp.run(w.e, w.stack[:0])
+	return BatchResult{Value: value, Err: err}
+}
+
+// SliceRecords iterate a slice of records, the record can be a map with string key, a struct, or a pointer to them.
+// the var path is resolved like GetNewPraserFromStruct.
+func SliceRecords(records interface{}) (RecordIterator, error) {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("records must be a slice, records: %T", records))
+	}
+
+	index := 0
+	return RecordIteratorFunc(func() (VariableResolver, bool) {
+		if index >= v.Len() {
+			return nil, false
+		}
+		index++
+		return &structResolver{env: v.Index(index - 1)}, true
+	}), nil
+}
+
+// ColumnRecords iterate the column oriented records, each column is a slice with the same length.
+// the column name is the var path, like "user.level".
+func ColumnRecords(columns map[string]interface{}) (RecordIterator, error) {
+	columnValues := make(map[string]reflect.Value, len(columns))
+	length := -1
+	for name, column := range columns {
+		v := reflect.ValueOf(column)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("column %v must be a slice", name))
+		}
+		if length >= 0 && v.Len() != length {
+			return nil, GetError(ErrRuleEngineInvalidParam,
+				fmt.Sprintf("column %v length %v is different from the others %v", name, v.Len(), length))
+		}
+		columnValues[name], length = v, v.Len()
+	}
+
+	index := 0
+	return RecordIteratorFunc(func() (VariableResolver, bool) {
+		if index >= length {
+			return nil, false
+		}
+		index++
+		return &columnRecord{columns: columnValues, index: index - 1}, true
+	}), nil
+}
+
+type columnRecord struct {
+	columns map[string]reflect.Value
+	index   int
+}
+
+func (r *columnRecord) Resolve(name string) (interface{}, error) {
+	column, ok := r.columns[name]
+	if !ok {
+		return nil, nil
+	}
+	v, ok := indirectValue(column.Index(r.index))
+	if !ok {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,49 @@
+// rule_engine_golden run the golden test specs of the rules, see rule_engine.GoldenSpec.
+//
+//	rule_engine_golden -v testdata/*.json
+//
+// the failures are printed with the diff and the trace, exit with 1 if any case failed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/uyouii/rule_engine"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "print the passed cases too")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: rule_engine_golden [-v] spec.json ...")
+		os.Exit(2)
+	}
+
+	passed, failed := 0, 0
+	for _, path := range flag.Args() {
+		spec, err := rule_engine.LoadGoldenSpec(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		for _, res := range spec.Run() {
+			if res.Passed {
+				passed++
+				if *verbose {
+					fmt.Printf("%v: %v\n", path, res)
+				}
+				continue
+			}
+			failed++
+			fmt.Printf("%v: %v\n", path, res)
+		}
+	}
+
+	fmt.Printf("passed: %v, failed: %v\n", passed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+// rule_engine_lsp is the language server of the rule engine expressions over stdio.
+//
+//	rule_engine_lsp -schema schema.json -decimal
+//
+// the schema is a JSON object of the var names and types, like {"user.name": "string", "amount": "decimal"}.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/uyouii/rule_engine"
+	"github.com/uyouii/rule_engine/lsp"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "the JSON file of the var names and types")
+	useDecimal := flag.Bool("decimal", false, "parse the float literal as decimal")
+	flag.Parse()
+
+	// stdout is used by the protocol
+	log.SetOutput(os.Stderr)
+
+	var schema map[string]rule_engine.ValueType
+	if *schemaPath != "" {
+		data, err := os.ReadFile(*schemaPath)
+		if err != nil {
+			log.Fatalf("read schema failed: %v", err)
+		}
+		if schema, err = lsp.ParseSchema(data); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	praser, err := rule_engine.GetNewPraser(nil, *useDecimal)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := lsp.NewServer(praser, schema).ServeStdio(context.Background()); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -77,7 +77,7 @@ const (
 	ValueTypeBool
 	ValueTypeString
 	ValueTypeDecimal
-	valueTypeArgs
+	ValueTypeBigInt
 )
 
 var valueTypeNameDict = map[ValueType]string{
@@ -86,8 +86,15 @@ var valueTypeNameDict = map[ValueType]string{
 	ValueTypeFloat:   "float",
 	ValueTypeString:  "string",
 	ValueTypeInteger: "integer",
-	valueTypeArgs:    "args",
 	ValueTypeDecimal: "decimal",
+	ValueTypeBigInt:  "bigint",
+}
+
+func (t ValueType) String() string {
+	if name, ok := valueTypeNameDict[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
 }
 
 var valueTokenToValueType = map[int]ValueType{
@@ -100,6 +107,47 @@ var valueTokenToValueType = map[int]ValueType{
 	IDENTIFIER: ValueTypeString,
 }
 
+type RoundingMode int
+
+const (
+	RoundHalfUp   RoundingMode = iota // round half away from zero, the default behavior of decimal.Div
+	RoundHalfEven                     // round half to the nearest even digit, known as banker's rounding
+	RoundDown                         // round towards zero
+	RoundUp                           // round away from zero
+	RoundCeiling                      // round towards +infinity
+	RoundFloor                        // round towards -infinity
+)
+
+var roundingModeNameDict = map[RoundingMode]string{
+	RoundHalfUp:   "half_up",
+	RoundHalfEven: "half_even",
+	RoundDown:     "down",
+	RoundUp:       "up",
+	RoundCeiling:  "ceiling",
+	RoundFloor:    "floor",
+}
+
+const defaultDivisionPrecision = 16
+
+type IntegerOverflowMode int
+
+const (
+	IntegerOverflowError   IntegerOverflowMode = iota // return ErrRuleEngineIntegerOverflow, the default mode
+	IntegerOverflowBigInt                             // promote the result to ValueTypeBigInt
+	IntegerOverflowDecimal                            // promote the result to ValueTypeDecimal
+)
+
+type FloatEqualMode int
+
+const (
+	FloatEqualAbsolute FloatEqualMode = iota // |x - y| < epsilon, the default mode with epsilon 1e-11
+	FloatEqualExact                          // x == y
+	FloatEqualRelative                       // |x - y| <= epsilon * max(|x|, |y|)
+	FloatEqualULP                            // x and y differ by at most n units in the last place
+)
+
+const defaultFloatEpsilon = 0.00000000001
+
 type operType int
 
 const (
@@ -110,20 +158,18 @@ const (
 	operTypeEqual
 	operTypeLogic
 	operTypeString
-	operTypeArgument
 	operTypeRegex
 	operTypeChangeTo
 )
 
 var operValidType = map[operType][]ValueType{
-	operTypeMath:     {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal},
-	operTypeMod:      {ValueTypeInteger},
-	operTypeMinus:    {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal},
-	operTypeRelation: {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal},
-	operTypeEqual:    {ValueTypeInteger, ValueTypeFloat, ValueTypeBool, ValueTypeString, ValueTypeDecimal},
+	operTypeMath:     {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal, ValueTypeBigInt},
+	operTypeMod:      {ValueTypeInteger, ValueTypeBigInt},
+	operTypeMinus:    {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal, ValueTypeBigInt},
+	operTypeRelation: {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal, ValueTypeBigInt},
+	operTypeEqual:    {ValueTypeInteger, ValueTypeFloat, ValueTypeBool, ValueTypeString, ValueTypeDecimal, ValueTypeBigInt},
 	operTypeLogic:    {ValueTypeBool},
 	operTypeString:   {ValueTypeString},
-	operTypeArgument: {valueTypeArgs},
 	operTypeRegex:    {ValueTypeString},
-	operTypeChangeTo: {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal, ValueTypeString},
+	operTypeChangeTo: {ValueTypeInteger, ValueTypeFloat, ValueTypeDecimal, ValueTypeString, ValueTypeBigInt},
 }
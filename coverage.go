@@ -0,0 +1,362 @@
+package rule_engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type CoverageKind int
+
+const (
+	CoverageCondition    CoverageKind = iota + 1 // the condition is true or false
+	CoverageBranch                               // the branch of x if c else y taken
+	CoverageShortCircuit                         // the right side of and/or is skipped or needed
+)
+
+var coverageKindNameDict = map[CoverageKind]string{
+	CoverageCondition:    "condition",
+	CoverageBranch:       "branch",
+	CoverageShortCircuit: "short circuit",
+}
+
+// the names of the two outcomes of the kinds
+var coverageOutcomeDict = map[CoverageKind][2]string{
+	CoverageCondition:    {"true", "false"},
+	CoverageBranch:       {"if", "else"},
+	CoverageShortCircuit: {"skipped", "needed"},
+}
+
+func (k CoverageKind) String() string {
+	return coverageKindNameDict[k]
+}
+
+// the kind is written as its name in the json report
+func (k CoverageKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *CoverageKind) UnmarshalText(text []byte) error {
+	for kind, name := range coverageKindNameDict {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("unknown coverage kind: %v", string(text)))
+}
+
+// CoverageItem count the two outcomes of a sub expression, Pos and End are the byte offset in the source
+type CoverageItem struct {
+	Kind     CoverageKind `json:"kind"`
+	Pos      int          `json:"pos"`
+	End      int          `json:"end"`
+	Text     string       `json:"text"`
+	Outcomes [2]string    `json:"outcomes"` // like "true" and "false"
+	Counts   [2]int       `json:"counts"`
+}
+
+// both the outcomes are seen
+func (item *CoverageItem) Covered() bool {
+	return item.Counts[0] > 0 && item.Counts[1] > 0
+}
+
+func (item *CoverageItem) String() string {
+	res := fmt.Sprintf("%v %v, %v: %v, %v: %v, pos: %v", item.Kind, item.Text,
+		item.Outcomes[0], item.Counts[0], item.Outcomes[1], item.Counts[1], item.Pos)
+	for i, count := range item.Counts {
+		if count == 0 {
+			res += fmt.Sprintf(", never %v", item.Outcomes[i])
+		}
+	}
+	return res
+}
+
+// Coverage collect the outcomes of the conditions, the branches of x if c else y and the short circuit of and/or
+// across many evaluations of a program. it is safe to evaluate in many goroutines.
+//
+// the program always evaluates all the sub expressions, the coverage follows the short circuit semantics:
+// the right side of `false and x` and the branch not taken are not counted.
+type Coverage struct {
+	prog  *Program
+	items []*CoverageItem
+	conds map[*astNode]*CoverageItem // the condition of the node
+	other map[*astNode]*CoverageItem // the branch of the ternary, or the short circuit of and/or
+
+	mu    sync.Mutex
+	evals int
+}
+
+// NewCoverage create an empty coverage of the program, the conditions and branches depend on no var are not counted.
+func (prog *Program) NewCoverage() *Coverage {
+	c := &Coverage{
+		prog:  prog,
+		conds: make(map[*astNode]*CoverageItem),
+		other: make(map[*astNode]*CoverageItem),
+	}
+	c.collect(prog.root, true, &astPrinter{decimalMode: prog.oper.decimalMode})
+	sort.SliceStable(c.items, func(i, j int) bool {
+		x, y := c.items[i], c.items[j]
+		if x.Pos != y.Pos {
+			return x.Pos < y.Pos
+		}
+		return x.End > y.End
+	})
+	return c
+}
+
+func (c *Coverage) collect(n *astNode, boolean bool, printer *astPrinter) {
+	if isVarCondition(n, boolean) {
+		c.conds[n] = c.addItem(CoverageCondition, n, printer)
+	}
+
+	switch {
+	case n.nodeType == astNodeUnary && n.oper == NOT:
+		c.collect(n.children[0], true, printer)
+	case n.nodeType == astNodeBinary && (n.oper == AND || n.oper == OR):
+		if hasVar(n.children[0]) {
+			c.other[n] = c.addItem(CoverageShortCircuit, n, printer)
+		}
+		c.collect(n.children[0], true, printer)
+		c.collect(n.children[1], true, printer)
+	case n.nodeType == astNodeThirdOper:
+		if hasVar(n.children[1]) {
+			c.other[n] = c.addItem(CoverageBranch, n, printer)
+		}
+		c.collect(n.children[0], boolean, printer)
+		c.collect(n.children[1], true, printer)
+		c.collect(n.children[2], boolean, printer)
+	default:
+		for _, child := range n.children {
+			c.collect(child, false, printer)
+		}
+	}
+}
+
+func (c *Coverage) addItem(kind CoverageKind, n *astNode, printer *astPrinter) *CoverageItem {
+	s := span(c.prog.source, n, printer)
+	item := &CoverageItem{Kind: kind, Pos: s.Pos, End: s.End, Text: s.Text, Outcomes: coverageOutcomeDict[kind]}
+	c.items = append(c.items, item)
+	return item
+}
+
+// Eval evaluate the program and count the outcomes, the variables of the record are used first like EvalBatch,
+// the record can be nil.
+func (c *Coverage) Eval(record VariableResolver) (*TokenNode, error) {
+	return c.EvalContext(context.Background(), record)
+}
+
+func (c *Coverage) EvalContext(ctx context.Context, record VariableResolver) (*TokenNode, error) {
+	values := make(map[*astNode]*TokenNode)
+	e := newEvaluator(ctx, c.prog.oper)
+	e.record = record
+	e.observe = func(n *astNode, res *TokenNode) {
+		values[n] = res
+	}
+	res, err := e.eval(c.prog.root)
+
+	// the outcomes before the error are counted too
+	c.mu.Lock()
+	c.evals++
+	c.count(c.prog.root, values)
+	c.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return c.prog.oper.roundResult(res), nil
+}
+
+// EvalBatch evaluate the records one by one, the results are in the order of the records
+func (c *Coverage) EvalBatch(ctx context.Context, records RecordIterator) []BatchResult {
+	results := []BatchResult{}
+	for record, ok := records.Next(); ok; record, ok = records.Next() {
+		value, err := c.EvalContext(ctx, record)
+		results = append(results, BatchResult{Value: value, Err: err})
+	}
+	return results
+}
+
+// count the outcomes of the node and go down the sub expressions evaluated by the short circuit semantics
+func (c *Coverage) count(n *astNode, values map[*astNode]*TokenNode) {
+	if item, ok := c.conds[n]; ok {
+		if res, ok := values[n]; ok && res.ValueType == ValueTypeBool {
+			c.countOutcome(item, res.GetBool())
+		}
+	}
+
+	switch {
+	case n.nodeType == astNodeBinary && (n.oper == AND || n.oper == OR):
+		c.count(n.children[0], values)
+		left, ok := values[n.children[0]]
+		if !ok || left.ValueType != ValueTypeBool {
+			return
+		}
+		skipped := left.GetBool() == (n.oper == OR)
+		if item, ok := c.other[n]; ok {
+			c.countOutcome(item, skipped)
+		}
+		if !skipped {
+			c.count(n.children[1], values)
+		}
+	case n.nodeType == astNodeThirdOper:
+		c.count(n.children[1], values)
+		cond, ok := values[n.children[1]]
+		if !ok || cond.ValueType != ValueTypeBool {
+			return
+		}
+		if item, ok := c.other[n]; ok {
+			c.countOutcome(item, cond.GetBool())
+		}
+		if cond.GetBool() {
+			c.count(n.children[0], values)
+		} else {
+			c.count(n.children[2], values)
+		}
+	default:
+		for _, child := range n.children {
+			c.count(child, values)
+		}
+	}
+}
+
+// the first outcome if first is true, otherwise the second
+func (c *Coverage) countOutcome(item *CoverageItem, first bool) {
+	if first {
+		item.Counts[0]++
+	} else {
+		item.Counts[1]++
+	}
+}
+
+// Reset clear the counts
+func (c *Coverage) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evals = 0
+	for _, item := range c.items {
+		item.Counts = [2]int{}
+	}
+}
+
+// CoverageReport is the snapshot of a Coverage, Covered and Total count the outcomes
+type CoverageReport struct {
+	Source  string          `json:"source"`
+	Evals   int             `json:"evals"`
+	Covered int             `json:"covered"`
+	Total   int             `json:"total"`
+	Items   []*CoverageItem `json:"items"` // in the order of the source, the outer one first
+}
+
+// Report take a snapshot of the counts
+func (c *Coverage) Report() *CoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &CoverageReport{Source: c.prog.source, Evals: c.evals, Items: make([]*CoverageItem, 0, len(c.items))}
+	for _, item := range c.items {
+		copied := *item
+		r.Items = append(r.Items, &copied)
+		r.Total += 2
+		for _, count := range item.Counts {
+			if count > 0 {
+				r.Covered++
+			}
+		}
+	}
+	return r
+}
+
+// the percent of the outcomes seen, 100 if there is nothing to cover
+func (r *CoverageReport) Percent() float64 {
+	if r.Total == 0 {
+		return 100
+	}
+	return float64(r.Covered) * 100 / float64(r.Total)
+}
+
+// the text report, a line for each item
+func (r *CoverageReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v\nevaluations: %v, outcomes covered: %v/%v (%.1f%%)\n",
+		r.Source, r.Evals, r.Covered, r.Total, r.Percent())
+	for _, item := range r.Items {
+		b.WriteString(item.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// the indented json report, the operators like >= are not escaped
+func (r *CoverageReport) JSON() ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// HTML annotate the source, each item is wrapped in <span class="rule-covered">, "rule-partial" if only one outcome
+// is seen, or "rule-uncovered", the counts are in the title. the items with the same span share a <span>.
+func (r *CoverageReport) HTML() string {
+	type mark struct {
+		pos, end int
+		class    string
+		titles   []string
+	}
+	marks := []*mark{}
+	for _, item := range r.Items {
+		if item.Pos < 0 || item.Pos > item.End || item.End > len(r.Source) {
+			continue
+		}
+		class := "rule-covered"
+		if item.Counts[0] == 0 && item.Counts[1] == 0 {
+			class = "rule-uncovered"
+		} else if !item.Covered() {
+			class = "rule-partial"
+		}
+		title := fmt.Sprintf("%v %v: %v, %v: %v", item.Kind, item.Outcomes[0], item.Counts[0],
+			item.Outcomes[1], item.Counts[1])
+
+		if last := len(marks) - 1; last >= 0 && marks[last].pos == item.Pos && marks[last].end == item.End {
+			// the worse class is used
+			if class == "rule-uncovered" || class == "rule-partial" && marks[last].class == "rule-covered" {
+				marks[last].class = class
+			}
+			marks[last].titles = append(marks[last].titles, title)
+			continue
+		}
+		marks = append(marks, &mark{pos: item.Pos, end: item.End, class: class, titles: []string{title}})
+	}
+
+	// the marks are nested like the syntax tree, the outer one is opened first
+	var b strings.Builder
+	b.WriteString(`<pre class="rule-coverage">`)
+	pos := 0
+	opened := []*mark{}
+	closeTo := func(end int) {
+		for len(opened) > 0 && opened[len(opened)-1].end <= end {
+			m := opened[len(opened)-1]
+			b.WriteString(html.EscapeString(r.Source[pos:m.end]))
+			b.WriteString(`</span>`)
+			pos, opened = m.end, opened[:len(opened)-1]
+		}
+	}
+	for _, m := range marks {
+		closeTo(m.pos)
+		b.WriteString(html.EscapeString(r.Source[pos:m.pos]))
+		fmt.Fprintf(&b, `<span class="%v" title="%v">`, m.class, html.EscapeString(strings.Join(m.titles, "; ")))
+		pos, opened = m.pos, append(opened, m)
+	}
+	closeTo(len(r.Source))
+	b.WriteString(html.EscapeString(r.Source[pos:]))
+	b.WriteString(`</pre>`)
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package rule_engine
+
+import (
+	"context"
+	"strings"
+)
+
+// VarDependency is a variable used in the expression
+type VarDependency struct {
+	Name     string       // the full path of the var, like "user.address.city"
+	Path     []string     // the segments of the path, like ["user", "address", "city"]
+	Compared []*TokenNode // the literal values the var is compared with, like 100 in {{amount}} >= 100
+}
+
+// Dependencies is what an expression reads, in the order of the first appearance
+type Dependencies struct {
+	Vars  []*VarDependency
+	Funcs []string
+}
+
+// get the variables and functions used by the program, no variable value is needed.
+func (prog *Program) Dependencies() *Dependencies {
+	c := &depsCollector{
+		deps:     &Dependencies{},
+		vars:     make(map[string]*VarDependency),
+		funcs:    make(map[string]bool),
+		constant: newEvaluator(context.Background(), prog.oper),
+	}
+	c.constant.budget = evalBudget{}
+	c.collect(prog.root)
+	return c.deps
+}
+
+// the Dependencies of the str, see Program.Dependencies
+func (p *Praser) Dependencies(str string) (*Dependencies, error) {
+	prog, err := p.Compile(str)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Dependencies(), nil
+}
+
+// get the var by name, nil if not used
+func (d *Dependencies) Var(name string) *VarDependency {
+	for _, v := range d.Vars {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+type depsCollector struct {
+	deps     *Dependencies
+	vars     map[string]*VarDependency
+	funcs    map[string]bool
+	constant *evaluator // calculate the constant compared with the var, like -5
+}
+
+var compareOperSet = map[int]bool{'<': true, '>': true, LE: true, GE: true, EQ: true, NE: true}
+
+func (c *depsCollector) collect(n *astNode) {
+	switch n.nodeType {
+	case astNodeVar:
+		c.addVar(n.varName())
+	case astNodeFunc:
+		if !c.funcs[n.funcName()] {
+			c.funcs[n.funcName()] = true
+			c.deps.Funcs = append(c.deps.Funcs, n.funcName())
+		}
+	case astNodeBinary:
+		if compareOperSet[n.oper] {
+			c.addCompared(n.children[0], n.children[1])
+			c.addCompared(n.children[1], n.children[0])
+		}
+	}
+
+	for _, child := range n.children {
+		c.collect(child)
+	}
+}
+
+func (c *depsCollector) addVar(name string) *VarDependency {
+	if v, ok := c.vars[name]; ok {
+		return v
+	}
+	v := &VarDependency{Name: name, Path: strings.Split(name, ".")}
+	c.vars[name] = v
+	c.deps.Vars = append(c.deps.Vars, v)
+	return v
+}
+
+// if x is a var and y is a constant, y is compared with x
+func (c *depsCollector) addCompared(x *astNode, y *astNode) {
+	if x.nodeType != astNodeVar || hasVar(y) {
+		return
+	}
+	value, err := c.constant.eval(y)
+	if err != nil {
+		return
+	}
+	v := c.addVar(x.varName())
+	for _, compared := range v.Compared {
+		if compared.ValueType == value.ValueType && compared.Compare(value) {
+			return
+		}
+	}
+	v.Compared = append(v.Compared, value)
+}
+
+func hasVar(n *astNode) bool {
+	if n.nodeType == astNodeVar {
+		return true
+	}
+	for _, child := range n.children {
+		if hasVar(child) {
+			return true
+		}
+	}
+	return false
+}
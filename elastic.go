@@ -0,0 +1,135 @@
+package rule_engine
+
+import (
+	"strings"
+)
+
+var elasticRangeOperDict = map[int]string{
+	'<': "lt",
+	'>': "gt",
+	LE:  "lte",
+	GE:  "gte",
+}
+
+// the escapes of go regex not supported by the Elasticsearch regexp query, the escaped letter is literal in Lucene
+const elasticUnsupportedEscapes = "dDwWsSbBApPzaftnrvxQE01234567"
+
+// the characters literal in go regex but operators in Lucene regex, like @ for any string, they are escaped
+const luceneReservedChars = `@&~<>#"`
+
+// ToElasticQuery translate the bool expression to an Elasticsearch bool query.
+// fields map the var path to the field name, nil means use the var path, like "user.level".
+// the regex pattern is changed to match anywhere like regexMatch, as the regexp query of Elasticsearch is anchored,
+// the pattern using the syntax Elasticsearch does not support, like \d, get ErrRuleEngineTranslate,
+// the characters literal in go but operators in Lucene, like @ and #, are escaped.
+func (prog *Program) ToElasticQuery(fields map[string]string) (map[string]interface{}, error) {
+	filter, err := prog.buildFilter(fields)
+	if err != nil {
+		return nil, err
+	}
+	return elasticQuery(filter)
+}
+
+func elasticBool(occur string, children ...interface{}) map[string]interface{} {
+	query := map[string]interface{}{occur: children}
+	if occur == "should" {
+		query["minimum_should_match"] = 1
+	}
+	return map[string]interface{}{"bool": query}
+}
+
+func elasticQuery(f *filterNode) (map[string]interface{}, error) {
+	switch f.kind {
+	case filterConst:
+		if f.match {
+			return map[string]interface{}{"match_all": map[string]interface{}{}}, nil
+		}
+		return map[string]interface{}{"match_none": map[string]interface{}{}}, nil
+	case filterAnd, filterOr, filterNot:
+		children := make([]interface{}, len(f.children))
+		for i, child := range f.children {
+			query, err := elasticQuery(child)
+			if err != nil {
+				return nil, err
+			}
+			children[i] = query
+		}
+		occur := map[filterKind]string{filterAnd: "filter", filterOr: "should", filterNot: "must_not"}[f.kind]
+		return elasticBool(occur, children...), nil
+	case filterCompare:
+		switch f.oper {
+		case EQ:
+			return map[string]interface{}{"term": map[string]interface{}{f.field: f.value}}, nil
+		case NE:
+			return elasticBool("must_not", map[string]interface{}{"term": map[string]interface{}{f.field: f.value}}), nil
+		}
+		return map[string]interface{}{"range": map[string]interface{}{
+			f.field: map[string]interface{}{elasticRangeOperDict[f.oper]: f.value},
+		}}, nil
+	case filterPrefix:
+		return map[string]interface{}{"prefix": map[string]interface{}{f.field: f.value}}, nil
+	case filterSuffix:
+		pattern := "*" + escapeWildcard(f.value.(string))
+		return map[string]interface{}{"wildcard": map[string]interface{}{f.field: pattern}}, nil
+	}
+
+	pattern, err := elasticRegex(f.value.(string))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"regexp": map[string]interface{}{f.field: pattern}}, nil
+}
+
+// the regexp query is anchored and has no ^ and $, change the pattern to match anywhere
+func elasticRegex(pattern string) (string, error) {
+	var b strings.Builder
+	alternate := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			if strings.IndexByte(elasticUnsupportedEscapes, pattern[i+1]) >= 0 {
+				return "", GetError(ErrRuleEngineTranslate, "the regex syntax is not supported by elasticsearch: "+pattern)
+			}
+			b.WriteString(pattern[i : i+2])
+			i++
+			continue
+		case c == '(' && strings.HasPrefix(pattern[i+1:], "?"):
+			return "", GetError(ErrRuleEngineTranslate, "the regex syntax is not supported by elasticsearch: "+pattern)
+		case c == '|':
+			alternate = true
+		case strings.IndexByte(luceneReservedChars, c) >= 0:
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	res := b.String()
+
+	// the $ escaped by odd backslashes is literal
+	backslashes := 0
+	for i := len(pattern) - 2; i >= 0 && pattern[i] == '\\'; i-- {
+		backslashes++
+	}
+	startAnchored := strings.HasPrefix(pattern, "^")
+	endAnchored := strings.HasSuffix(pattern, "$") && backslashes%2 == 0
+	if (startAnchored || endAnchored) && alternate {
+		// ^a|b$ can not be changed to one anchored pattern
+		return "", GetError(ErrRuleEngineTranslate, "the regex with anchor and | is not supported by elasticsearch: "+pattern)
+	}
+
+	prefix, suffix := ".*", ".*"
+	if startAnchored {
+		res, prefix = res[1:], ""
+	}
+	if endAnchored {
+		res, suffix = res[:len(res)-1], ""
+	}
+	if prefix == "" && suffix == "" {
+		return res, nil
+	}
+	return prefix + "(" + res + ")" + suffix, nil
+}
+
+func escapeWildcard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(s)
+}
@@ -0,0 +1,136 @@
+package rule_engine
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// the struct tag to rename the field in the variable path, `rule:"-"` ignore the field
+const structTagName = "rule"
+
+var (
+	decimalType   = reflect.TypeOf(decimal.Decimal{})
+	bigIntPtrType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// reflect.Type -> map[string][]int, the field name to the field index
+var structFieldCache sync.Map
+
+func getStructFields(t reflect.Type) map[string][]int {
+	if fields, ok := structFieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || (field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup(structTagName); ok {
+			if tag = strings.Split(tag, ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+		}
+		fields[name] = field.Index
+	}
+
+	res, _ := structFieldCache.LoadOrStore(t, fields)
+	return res.(map[string][]int)
+}
+
+// follow the pointer and interface, return false if meet nil
+func indirectValue(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		if v.Type() == bigIntPtrType {
+			break
+		}
+		v = v.Elem()
+	}
+	return v, v.IsValid()
+}
+
+// structResolver resolve the variables from a struct, see GetNewPraserFromStruct
+type structResolver struct {
+	env reflect.Value
+}
+
+func (r *structResolver) Resolve(name string) (interface{}, error) {
+	return resolveStructPath(r.env, name)
+}
+
+// check the env is a struct or a pointer to struct
+func checkStructEnv(env interface{}) (reflect.Value, error) {
+	v, ok := indirectValue(reflect.ValueOf(env))
+	if !ok || v.Kind() != reflect.Struct {
+		return reflect.Value{}, GetError(ErrRuleEngineInvalidParam,
+			fmt.Sprintf("env must be a struct or a pointer to struct, env: %v", env))
+	}
+	return v, nil
+}
+
+// get the value in env by the var path, like {{order.address.city}}.
+// the path can go through struct field, map with string key, slice and array.
+func resolveStructPath(env reflect.Value, path string) (interface{}, error) {
+	v := env
+	for _, name := range strings.Split(path, ".") {
+		var ok bool
+		if v, ok = indirectValue(v); !ok {
+			return nil, GetError(ErrRuleEngineUnknownVarName, fmt.Sprintf("nil value in var path: %v", path))
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			if v.Type() == decimalType {
+				v = reflect.Value{}
+				break
+			}
+			index, ok := getStructFields(v.Type())[name]
+			if !ok {
+				v = reflect.Value{}
+				break
+			}
+			field, err := v.FieldByIndexErr(index)
+			if err != nil {
+				return nil, GetError(ErrRuleEngineUnknownVarName, fmt.Sprintf("nil value in var path: %v", path))
+			}
+			v = field
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				v = reflect.Value{}
+				break
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		case reflect.Slice, reflect.Array:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= v.Len() {
+				v = reflect.Value{}
+				break
+			}
+			v = v.Index(index)
+		default:
+			v = reflect.Value{}
+		}
+
+		if !v.IsValid() {
+			return nil, GetError(ErrRuleEngineUnknownVarName, fmt.Sprintf("unknown var name: %v", path))
+		}
+	}
+
+	v, ok := indirectValue(v)
+	if !ok {
+		return nil, GetError(ErrRuleEngineUnknownVarName, fmt.Sprintf("nil value in var path: %v", path))
+	}
+	return v.Interface(), nil
+}
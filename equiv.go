@@ -0,0 +1,271 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+type DiffKind int
+
+const (
+	DiffChanged DiffKind = iota + 1 // the sub expression is replaced
+	DiffAdded                       // the operand of and/or is added
+	DiffRemoved                     // the operand of and/or is removed
+)
+
+var diffKindNameDict = map[DiffKind]string{
+	DiffChanged: "changed",
+	DiffAdded:   "added",
+	DiffRemoved: "removed",
+}
+
+func (k DiffKind) String() string {
+	return diffKindNameDict[k]
+}
+
+// ExprSpan is a sub expression in the source, Pos and End are the byte offset
+type ExprSpan struct {
+	Pos  int
+	End  int
+	Text string
+}
+
+// ExprDiff is a changed sub expression, Old is nil if added, New is nil if removed
+type ExprDiff struct {
+	Kind DiffKind
+	Old  *ExprSpan
+	New  *ExprSpan
+}
+
+func (d *ExprDiff) String() string {
+	switch d.Kind {
+	case DiffAdded:
+		return fmt.Sprintf("added: %v", d.New.Text)
+	case DiffRemoved:
+		return fmt.Sprintf("removed: %v", d.Old.Text)
+	}
+	return fmt.Sprintf("changed: %v -> %v", d.Old.Text, d.New.Text)
+}
+
+// NormalForm print the program in the normal form, two programs are equivalent if they have the same normal form.
+//   - the constant sub expressions are folded, like 3 * 1000
+//   - a > b is b < a, a >= b is b <= a
+//   - the operands of and/or are flattened and sorted, the operands of ==, !=, * and + are sorted.
+//
+// the keywords like and/&& and the parentheses are not in the syntax tree, so they never make a difference.
+// the short circuit is ignored, so `{{a}} and 1 / 0 > 1` is equivalent to `1 / 0 > 1 and {{a}}` though the error differs.
+func (prog *Program) NormalForm() string {
+	m := newNormalizer(prog.oper)
+	return m.printer.print(m.normalize(prog.root))
+}
+
+// Equivalent report whether the two programs have the same normal form, see NormalForm
+func (prog *Program) Equivalent(other *Program) bool {
+	return prog.NormalForm() == other.NormalForm()
+}
+
+// Diff compare the normal forms of the programs, return the changed sub expressions with the span in the sources,
+// in the order of the old source. an empty result means the programs are equivalent.
+func (prog *Program) Diff(other *Program) []*ExprDiff {
+	d := &differ{
+		old: prog, new: other,
+		oldNorm: newNormalizer(prog.oper), newNorm: newNormalizer(other.oper),
+	}
+	d.diff(d.oldNorm.normalize(prog.root), d.newNorm.normalize(other.root))
+
+	// in the order of the old source, the added ones are the last
+	sort.SliceStable(d.res, func(i, j int) bool {
+		x, y := d.res[i], d.res[j]
+		if x.Old == nil || y.Old == nil {
+			return y.Old == nil && x.Old != nil
+		}
+		return x.Old.Pos < y.Old.Pos
+	})
+	return d.res
+}
+
+// the Diff of the two sources, see Program.Diff
+func (p *Praser) Diff(old string, new string) ([]*ExprDiff, error) {
+	oldProg, err := p.Compile(old)
+	if err != nil {
+		return nil, err
+	}
+	newProg, err := p.Compile(new)
+	if err != nil {
+		return nil, err
+	}
+	return oldProg.Diff(newProg), nil
+}
+
+// the operators whose operands can be swapped
+var commutativeOperSet = map[int]bool{AND: true, OR: true, EQ: true, NE: true, '*': true, '+': true}
+
+type normalizer struct {
+	oper    *TokenOperator
+	folder  *evaluator
+	printer *astPrinter
+}
+
+func newNormalizer(oper *TokenOperator) *normalizer {
+	folder := newEvaluator(context.Background(), oper)
+	folder.budget = evalBudget{}
+	return &normalizer{oper: oper, folder: folder, printer: &astPrinter{decimalMode: oper.decimalMode}}
+}
+
+// the normal form of the node, the span of the node is kept. the origin syntax tree is not changed.
+func (m *normalizer) normalize(n *astNode) *astNode {
+	if n.nodeType == astNodeValue || n.nodeType == astNodeVar {
+		return n
+	}
+
+	res := *n
+	res.children = make([]*astNode, len(n.children))
+	allValue := true
+	for i, child := range n.children {
+		res.children[i] = m.normalize(child)
+		allValue = allValue && res.children[i].nodeType == astNodeValue
+	}
+	if allValue {
+		if value, err := m.folder.eval(&res); err == nil {
+			return newTokenAstNode(value, n.pos, n.end)
+		}
+		// keep the sub expression failed to calculate, like 1 / 0
+	}
+	if n.nodeType != astNodeBinary {
+		return &res
+	}
+
+	switch res.oper {
+	case '>':
+		res.oper, res.children[0], res.children[1] = '<', res.children[1], res.children[0]
+	case GE:
+		res.oper, res.children[0], res.children[1] = LE, res.children[1], res.children[0]
+	case AND, OR:
+		operands := m.sortOperands(flattenOperands(res.oper, &res, nil))
+		return m.chain(res.oper, operands, n.pos, n.end)
+	}
+	if m.commutative(&res) {
+		res.children = m.sortOperands(res.children)
+	}
+	return &res
+}
+
+// the operands of the binary node can be swapped
+func (m *normalizer) commutative(n *astNode) bool {
+	return n.nodeType == astNodeBinary && commutativeOperSet[n.oper]
+}
+
+// build the chain of the operands, the span of the chain is [pos, end)
+func (m *normalizer) chain(oper int, operands []*astNode, pos int, end int) *astNode {
+	res := operands[0]
+	for _, operand := range operands[1:] {
+		res = newBinaryAstNode(oper, res, operand)
+		res.pos, res.end = pos, end
+	}
+	return res
+}
+
+// sort the operands by the printed form
+func (m *normalizer) sortOperands(operands []*astNode) []*astNode {
+	keys := make(map[*astNode]string, len(operands))
+	for _, operand := range operands {
+		keys[operand] = m.printer.print(operand)
+	}
+	sorted := append([]*astNode(nil), operands...)
+	sort.SliceStable(sorted, func(i, j int) bool { return keys[sorted[i]] < keys[sorted[j]] })
+	return sorted
+}
+
+type differ struct {
+	old, new         *Program
+	oldNorm, newNorm *normalizer
+	res              []*ExprDiff
+}
+
+func (d *differ) oldSpan(n *astNode) *ExprSpan {
+	return span(d.old.source, n, d.oldNorm.printer)
+}
+
+func (d *differ) newSpan(n *astNode) *ExprSpan {
+	return span(d.new.source, n, d.newNorm.printer)
+}
+
+// the text is got from the source, the program built by PartialEval has no span in its printed source
+func span(source string, n *astNode, printer *astPrinter) *ExprSpan {
+	if n.pos <= n.end && n.end <= len(source) {
+		return &ExprSpan{Pos: n.pos, End: n.end, Text: source[n.pos:n.end]}
+	}
+	return &ExprSpan{Pos: n.pos, End: n.end, Text: printer.print(n)}
+}
+
+// diff the normalized nodes, go down while the nodes have the same operator
+func (d *differ) diff(x *astNode, y *astNode) {
+	if d.oldNorm.printer.print(x) == d.newNorm.printer.print(y) {
+		return
+	}
+	if x.nodeType != y.nodeType || x.oper != y.oper || len(x.children) != len(y.children) || len(x.children) == 0 ||
+		x.nodeType == astNodeFunc && x.funcName() != y.funcName() {
+		d.res = append(d.res, &ExprDiff{Kind: DiffChanged, Old: d.oldSpan(x), New: d.newSpan(y)})
+		return
+	}
+	if x.nodeType == astNodeBinary && (x.oper == AND || x.oper == OR) {
+		d.diffOperands(x, y)
+		return
+	}
+	if d.oldNorm.commutative(x) && d.newNorm.commutative(y) {
+		// the sorted operands may be crossed when one of them changed, like a == 1 and b == 1
+		key := d.oldNorm.printer.print
+		if key(x.children[0]) == key(y.children[1]) || key(x.children[1]) == key(y.children[0]) {
+			d.diff(x.children[0], y.children[1])
+			d.diff(x.children[1], y.children[0])
+			return
+		}
+	}
+	for i := range x.children {
+		d.diff(x.children[i], y.children[i])
+	}
+}
+
+// diff the operands of and/or chains, the same operands are matched first,
+// then the rest are paired in order, the unpaired are added or removed.
+func (d *differ) diffOperands(x *astNode, y *astNode) {
+	xs, ys := flattenOperands(x.oper, x, nil), flattenOperands(y.oper, y, nil)
+
+	matched := make(map[string]int)
+	for _, operand := range ys {
+		matched[d.newNorm.printer.print(operand)]++
+	}
+	var removed, added []*astNode
+	for _, operand := range xs {
+		key := d.oldNorm.printer.print(operand)
+		if matched[key] > 0 {
+			matched[key]--
+			continue
+		}
+		removed = append(removed, operand)
+	}
+	matched = make(map[string]int)
+	for _, operand := range xs {
+		matched[d.oldNorm.printer.print(operand)]++
+	}
+	for _, operand := range ys {
+		key := d.newNorm.printer.print(operand)
+		if matched[key] > 0 {
+			matched[key]--
+			continue
+		}
+		added = append(added, operand)
+	}
+
+	for len(removed) > 0 && len(added) > 0 {
+		d.diff(removed[0], added[0])
+		removed, added = removed[1:], added[1:]
+	}
+	for _, operand := range removed {
+		d.res = append(d.res, &ExprDiff{Kind: DiffRemoved, Old: d.oldSpan(operand)})
+	}
+	for _, operand := range added {
+		d.res = append(d.res, &ExprDiff{Kind: DiffAdded, New: d.newSpan(operand)})
+	}
+}
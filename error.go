@@ -18,6 +18,16 @@ const (
 	ErrRuleEngineInvalidParam
 	ErrRuleEngineParamValueTypeNotMatch
 	ErrRuleEngineDecimalError
+	ErrRuleEngineIntegerOverflow
+	ErrRuleEngineResolveVar
+	ErrRuleEngineCanceled
+	ErrRuleEngineBudgetExceeded
+	ErrRuleEngineSourceTooLong
+	ErrRuleEngineTooDeep
+	ErrRuleEngineTooManyArgs
+	ErrRuleEngineStringTooLong
+	ErrRuleEngineRegexLimit
+	ErrRuleEngineTranslate
 )
 
 var ERROR_MSG_MAP = map[int]string{
@@ -36,6 +46,16 @@ var ERROR_MSG_MAP = map[int]string{
 	ErrRuleEngineInvalidParam:           "invalid parameter",
 	ErrRuleEngineParamValueTypeNotMatch: "parameter value type not match",
 	ErrRuleEngineDecimalError:           "error handle decimal",
+	ErrRuleEngineIntegerOverflow:        "integer overflow",
+	ErrRuleEngineResolveVar:             "resolve variable failed",
+	ErrRuleEngineCanceled:               "evaluation canceled",
+	ErrRuleEngineBudgetExceeded:         "evaluation budget exceeded",
+	ErrRuleEngineSourceTooLong:          "source too long",
+	ErrRuleEngineTooDeep:                "expression too deep",
+	ErrRuleEngineTooManyArgs:            "too many arguments",
+	ErrRuleEngineStringTooLong:          "string literal too long",
+	ErrRuleEngineRegexLimit:             "regex limit exceeded",
+	ErrRuleEngineTranslate:              "translate failed",
 }
 
 type EngineErr struct {
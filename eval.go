@@ -0,0 +1,214 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// Program is the compiled expression, can be evaluated many times.
+// it uses the variables and options of the Praser which compiled it.
+type Program struct {
+	source string
+	root   *astNode
+	oper   *TokenOperator
+}
+
+// the limit of an evaluation, 0 means no limit
+type evalBudget struct {
+	maxSteps       int // operators, functions and variables
+	maxFuncCalls   int
+	maxStringBytes int // the bytes of the string created by functions
+}
+
+var binaryOperFuncs = map[int]func(o *TokenOperator, x, y *TokenNode) (*TokenNode, error){
+	'+': (*TokenOperator).tokenNodeAdd,
+	'-': (*TokenOperator).tokenNodeSub,
+	'*': (*TokenOperator).tokenNodeMul,
+	'/': (*TokenOperator).tokenNodeDiv,
+	'%': (*TokenOperator).tokenNodeMod,
+	'>': (*TokenOperator).tokenNodeGreater,
+	'<': (*TokenOperator).tokenNodeLess,
+	GE:  (*TokenOperator).tokenNodeGreaterEqual,
+	LE:  (*TokenOperator).tokenNodeLessEqual,
+	EQ:  (*TokenOperator).tokenNodeEqual,
+	NE:  (*TokenOperator).tokenNodeNotEqual,
+	AND: (*TokenOperator).tokenNodeAnd,
+	OR:  (*TokenOperator).tokenNodeOr,
+}
+
+var unaryOperFuncs = map[int]func(o *TokenOperator, t *TokenNode) (*TokenNode, error){
+	'-': (*TokenOperator).tokenNodeMinus,
+	NOT: (*TokenOperator).tokenNodeNot,
+}
+
+// evaluator keep the state of one evaluation
+type evaluator struct {
+	oper       *TokenOperator
+	budget     evalBudget
+	ctx        context.Context
+	done       <-chan struct{}
+	varCache   map[string]*TokenNode            // the variables resolved by the resolver
+	record     VariableResolver                 // the record in EvalBatch, its variables are used first
+	recordVars map[string]*TokenNode            // the variables got from the record, nil if not in it
+	observe    func(n *astNode, res *TokenNode) // called with the result of each var and operator, if set
+
+	steps       int
+	funcCalls   int
+	stringBytes int
+}
+
+func newEvaluator(ctx context.Context, oper *TokenOperator) *evaluator {
+	e := &evaluator{
+		oper:   oper,
+		budget: oper.budget,
+		ctx:    ctx,
+		done:   ctx.Done(),
+	}
+	if oper.resolver != nil {
+		e.varCache = make(map[string]*TokenNode)
+	}
+	return e
+}
+
+// the source string of the program
+func (prog *Program) Source() string {
+	return prog.source
+}
+
+// the printable form of the program, it can be compiled again
+func (prog *Program) String() string {
+	return (&astPrinter{decimalMode: prog.oper.decimalMode}).print(prog.root)
+}
+
+func (prog *Program) Eval() (*TokenNode, error) {
+	return prog.EvalContext(context.Background())
+}
+
+// evaluate the program, stop with ErrRuleEngineCanceled when the ctx is done,
+// or with ErrRuleEngineBudgetExceeded when exceed the limit set by WithMaxSteps, WithMaxFuncCalls, WithMaxStringBytes.
+func (prog *Program) EvalContext(ctx context.Context) (*TokenNode, error) {
+	res, err := newEvaluator(ctx, prog.oper).eval(prog.root)
+	if err != nil {
+		return nil, err
+	}
+	return prog.oper.roundResult(res), nil
+}
+
+func (e *evaluator) eval(n *astNode) (*TokenNode, error) {
+	res, err := e.evalNode(n)
+	if err == nil && e.observe != nil && n.nodeType != astNodeValue {
+		e.observe(n, res)
+	}
+	return res, err
+}
+
+func (e *evaluator) evalNode(n *astNode) (*TokenNode, error) {
+	if n.nodeType == astNodeValue {
+		return GetTokenNode(n.value.ValueType, n.value.Value), nil
+	}
+
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+
+	if n.nodeType == astNodeVar {
+		return e.loadVar(n.value)
+	}
+
+	args := make([]*TokenNode, len(n.children))
+	for i, child := range n.children {
+		arg, err := e.eval(child)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+
+	switch n.nodeType {
+	case astNodeUnary:
+		return unaryOperFuncs[n.oper](e.oper, args[0])
+	case astNodeBinary:
+		return binaryOperFuncs[n.oper](e.oper, args[0], args[1])
+	case astNodeThirdOper:
+		return e.oper.tokenNodeThirdOper(args[0], args[1], args[2])
+	case astNodeFunc:
+		return e.callFunc(n.funcName(), args)
+	}
+	return nil, GetError(ErrRuleEngineUnknownOperator, fmt.Sprintf("unknown node type: %v", n.nodeType))
+}
+
+func (e *evaluator) loadVar(name *TokenNode) (*TokenNode, error) {
+	if e.record != nil {
+		if e.recordVars == nil {
+			e.recordVars = make(map[string]*TokenNode)
+		}
+		variable, ok := e.recordVars[name.GetString()]
+		if !ok {
+			var err error
+			variable, err = e.oper.resolveVarBy(e.ctx, e.record, name.GetString(), nil)
+			// not in the record, try the variables of the Praser
+			if err != nil && err.(*EngineErr).ErrCode != ErrRuleEngineUnknownVarName {
+				return nil, err
+			}
+			e.recordVars[name.GetString()] = variable
+		}
+		if variable != nil {
+			return GetTokenNode(variable.ValueType, variable.Value), nil
+		}
+	}
+	return e.oper.tokenNodeVar(e.ctx, name, e.varCache)
+}
+
+// clear the state of the last evaluation, so the evaluator can be reused
+func (e *evaluator) reset() {
+	e.steps, e.funcCalls, e.stringBytes = 0, 0, 0
+	for name := range e.varCache {
+		delete(e.varCache, name)
+	}
+	for name := range e.recordVars {
+		delete(e.recordVars, name)
+	}
+}
+
+func (e *evaluator) callFunc(funcName string, args []*TokenNode) (*TokenNode, error) {
+	e.funcCalls++
+	if limit := e.budget.maxFuncCalls; limit > 0 && e.funcCalls > limit {
+		return nil, GetError(ErrRuleEngineBudgetExceeded, fmt.Sprintf("func calls exceed the limit %v", limit))
+	}
+
+	res, err := e.oper.tokenHandleFunc(funcName, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, e.allocString(res)
+}
+
+// count the bytes of the string created in the evaluation
+func (e *evaluator) allocString(res *TokenNode) error {
+	if res.ValueType != ValueTypeString {
+		return nil
+	}
+	e.stringBytes += len(res.GetString())
+	if limit := e.budget.maxStringBytes; limit > 0 && e.stringBytes > limit {
+		return GetError(ErrRuleEngineBudgetExceeded, fmt.Sprintf("string bytes exceed the limit %v", limit))
+	}
+	return nil
+}
+
+// check the context and the steps limit
+func (e *evaluator) step() error {
+	if e.done != nil {
+		select {
+		case <-e.done:
+			return GetError(ErrRuleEngineCanceled, fmt.Sprintf("%v", e.ctx.Err()))
+		default:
+		}
+	}
+
+	e.steps++
+	if limit := e.budget.maxSteps; limit > 0 && e.steps > limit {
+		return GetError(ErrRuleEngineBudgetExceeded, fmt.Sprintf("steps exceed the limit %v", limit))
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package rule_engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type filterKind int
+
+const (
+	filterConst   filterKind = iota // match all or nothing
+	filterAnd                       // all the children match
+	filterOr                        // any child matches
+	filterNot                       // the child does not match
+	filterCompare                   // field oper value
+	filterRegex                     // field contains a match of the regex
+	filterPrefix                    // field starts with the string
+	filterSuffix                    // field ends with the string
+)
+
+// filterNode is the intermediate form of the filter documents, like MongoDB filter and Elasticsearch query.
+// it is a boolean expression of the conditions on the fields.
+type filterNode struct {
+	kind     filterKind
+	children []*filterNode
+	field    string
+	oper     int         // the comparison operator, like '>', EQ
+	value    interface{} // the value compared, or the regex pattern, prefix, suffix
+	match    bool        // for filterConst
+}
+
+// the comparison operator after swap the operands, like 1 < x -> x > 1
+var swapCompareOperDict = map[int]int{'<': '>', '>': '<', LE: GE, GE: LE, EQ: EQ, NE: NE}
+
+type filterBuilder struct {
+	fields   map[string]string
+	constant *evaluator
+}
+
+// build the filter from the program, fields map the var path to the field name, nil means use the var path.
+func (prog *Program) buildFilter(fields map[string]string) (*filterNode, error) {
+	b := &filterBuilder{fields: fields, constant: newEvaluator(context.Background(), prog.oper)}
+	b.constant.budget = evalBudget{}
+	return b.build(prog.root)
+}
+
+func translateError(n *astNode, format string, args ...interface{}) error {
+	return GetError(ErrRuleEngineTranslate, fmt.Sprintf(format, args...)+fmt.Sprintf(", pos: %v", n.pos))
+}
+
+func (b *filterBuilder) field(n *astNode) string {
+	if field, ok := b.fields[n.varName()]; ok {
+		return field
+	}
+	return n.varName()
+}
+
+// the value of the sub expression without var, like 3 * 1000
+func (b *filterBuilder) constValue(n *astNode) (*TokenNode, bool) {
+	if hasVar(n) {
+		return nil, false
+	}
+	value, err := b.constant.eval(n)
+	return value, err == nil
+}
+
+func (b *filterBuilder) build(n *astNode) (*filterNode, error) {
+	if value, ok := b.constValue(n); ok {
+		if value.ValueType != ValueTypeBool {
+			return nil, translateError(n, "the filter must be bool, but get %v", valueTypeNameDict[value.ValueType])
+		}
+		return &filterNode{kind: filterConst, match: value.GetBool()}, nil
+	}
+
+	switch n.nodeType {
+	case astNodeVar:
+		// a bool var, like {{active}}
+		return &filterNode{kind: filterCompare, field: b.field(n), oper: EQ, value: true}, nil
+	case astNodeUnary:
+		if n.oper == NOT {
+			child, err := b.build(n.children[0])
+			if err != nil {
+				return nil, err
+			}
+			return &filterNode{kind: filterNot, children: []*filterNode{child}}, nil
+		}
+	case astNodeBinary:
+		switch n.oper {
+		case AND, OR:
+			return b.buildLogic(n)
+		case '<', '>', LE, GE, EQ, NE:
+			return b.buildCompare(n)
+		}
+	case astNodeFunc:
+		return b.buildFunc(n)
+	}
+	return nil, translateError(n, "can not translate the expression to filter")
+}
+
+func (b *filterBuilder) buildLogic(n *astNode) (*filterNode, error) {
+	kind := filterAnd
+	if n.oper == OR {
+		kind = filterOr
+	}
+	res := &filterNode{kind: kind}
+	for _, child := range n.children {
+		node, err := b.build(child)
+		if err != nil {
+			return nil, err
+		}
+		// flatten a and b and c
+		if node.kind == kind {
+			res.children = append(res.children, node.children...)
+		} else {
+			res.children = append(res.children, node)
+		}
+	}
+	return res, nil
+}
+
+func (b *filterBuilder) buildCompare(n *astNode) (*filterNode, error) {
+	x, y, oper := n.children[0], n.children[1], n.oper
+	if x.nodeType != astNodeVar {
+		x, y, oper = y, x, swapCompareOperDict[oper]
+	}
+	if x.nodeType != astNodeVar {
+		return nil, translateError(n, "the comparison must be between a var and a constant")
+	}
+	value, ok := b.constValue(y)
+	if !ok {
+		return nil, translateError(n, "the comparison must be between a var and a constant, but both sides have var")
+	}
+	return &filterNode{kind: filterCompare, field: b.field(x), oper: oper, value: filterValue(value)}, nil
+}
+
+func (b *filterBuilder) buildFunc(n *astNode) (*filterNode, error) {
+	kindDict := map[string]filterKind{"regexMatch": filterRegex, "startWith": filterPrefix, "endWith": filterSuffix}
+	kind, ok := kindDict[n.funcName()]
+	if !ok || len(n.children) != 2 {
+		return nil, translateError(n, "func %v can not be translated to filter", n.funcName())
+	}
+
+	// regexMatch(pattern, s), startWith(s, prefix), endWith(s, suffix)
+	fieldNode, valueNode := n.children[0], n.children[1]
+	if kind == filterRegex {
+		fieldNode, valueNode = valueNode, fieldNode
+	}
+	if fieldNode.nodeType != astNodeVar {
+		return nil, translateError(n, "the string of %v must be a var", n.funcName())
+	}
+	value, ok := b.constValue(valueNode)
+	if !ok || value.ValueType != ValueTypeString {
+		return nil, translateError(n, "the pattern of %v must be a constant string", n.funcName())
+	}
+	return &filterNode{kind: kind, field: b.field(fieldNode), value: value.GetString()}, nil
+}
+
+// the value in the filter document, decimal and bigint are kept as json number
+func filterValue(t *TokenNode) interface{} {
+	switch t.ValueType {
+	case ValueTypeDecimal, ValueTypeBigInt:
+		return json.Number(t.GetString())
+	}
+	return t.Value
+}
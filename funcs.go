@@ -1,80 +1,48 @@
 package rule_engine
 
 import (
+	"errors"
 	"fmt"
 	"math"
-	"regexp"
+	"math/big"
 	"strconv"
 	"strings"
 
 	"github.com/shopspring/decimal"
 )
 
-func (o *TokenOperator) tokenNodeArg(arg *TokenNode) (*TokenNode, error) {
-	res := &TokenNode{
-		ValueType: valueTypeArgs,
-	}
-	tokenList := []*TokenNode{arg}
-
-	res.Value = tokenList
-	return res, nil
+type builtinFunc func(o *TokenOperator, argList []*TokenNode) (*TokenNode, error)
+
+// funcMap is the built-in functions can be used in the calculation
+var funcMap = map[string]builtinFunc{
+	"len":          (*TokenOperator).funcLen,
+	"min":          (*TokenOperator).funcMin,
+	"max":          (*TokenOperator).funcMax,
+	"abs":          (*TokenOperator).funcAbs,
+	"regexMatch":   (*TokenOperator).funcRegexMatch,
+	"regexFind":    (*TokenOperator).funcRegexFind,
+	"regexFindAll": (*TokenOperator).funcRegexFindAll,
+	"regexReplace": (*TokenOperator).funcRegexReplace,
+	"regexGroups":  (*TokenOperator).funcRegexGroups,
+	"upper":        (*TokenOperator).funcUpper,
+	"lower":        (*TokenOperator).funcLower,
+	"startWith":    (*TokenOperator).funcStartWith,
+	"endWith":      (*TokenOperator).funcEndWith,
+	"concat":       (*TokenOperator).funcConcat,
+	"int":          (*TokenOperator).funcInt,
+	"float":        (*TokenOperator).funcFloat,
+	"decimal":      (*TokenOperator).funcDecimal,
+	"string":       (*TokenOperator).funcString,
+	"isNaN":        (*TokenOperator).funcIsNaN,
+	"isInf":        (*TokenOperator).funcIsInf,
 }
 
-func (o *TokenOperator) tokenNodeArgList(argList *TokenNode, arg *TokenNode) (*TokenNode, error) {
-	if err := checkOperType(argList, operTypeArgument, "args"); err != nil {
-		return nil, err
-	}
-
-	res := *argList
-
-	res.Value = append(res.Value.([]*TokenNode), arg)
-	return &res, nil
-}
-
-func (o *TokenOperator) tokenNodeFunc(funcNode *TokenNode, argNode *TokenNode) (*TokenNode, error) {
-	argList := []*TokenNode{}
-	if argNode != nil {
-		if err := checkOperType(argNode, operTypeArgument, "args"); err != nil {
-			return nil, err
-		}
-		argList = argNode.Value.([]*TokenNode)
-	}
-	return o.tokenHandleFunc(funcNode, argList)
-}
-
-func (o *TokenOperator) tokenHandleFunc(funcNode *TokenNode, argList []*TokenNode) (*TokenNode, error) {
-	funcName := funcNode.Value.(string)
-
-	switch funcName {
-	case "len":
-		return o.funcLen(argList)
-	case "min":
-		return o.funcMin(argList)
-	case "max":
-		return o.funcMax(argList)
-	case "abs":
-		return o.funcAbs(argList)
-	case "regexMatch":
-		return o.funcRegexMatch(argList)
-	case "upper":
-		return o.funcUpper(argList)
-	case "lower":
-		return o.funcLower(argList)
-	case "startWith":
-		return o.funcStartWith(argList)
-	case "endWith":
-		return o.funcEndWith(argList)
-	case "int":
-		return o.funcInt(argList)
-	case "float":
-		return o.funcFloat(argList)
-	case "decimal":
-		return o.funcDecimal(argList)
-	case "string":
-		return o.funcString(argList)
-	default:
+func (o *TokenOperator) tokenHandleFunc(funcName string, argList []*TokenNode) (*TokenNode, error) {
+	f, ok := funcMap[funcName]
+	if !ok {
 		return nil, GetError(ErrRuleEngineUnkonwnFunc, fmt.Sprintf("unknown func name: %v", funcName))
 	}
+	return f(o, argList)
 }
 
 func (o *TokenOperator) funcString(argList []*TokenNode) (*TokenNode, error) {
@@ -94,6 +62,8 @@ func (o *TokenOperator) funcString(argList []*TokenNode) (*TokenNode, error) {
 		return GetTokenNode(ValueTypeString, fmt.Sprintf("%v", arg.GetFloat())), nil
 	case ValueTypeDecimal:
 		return GetTokenNode(ValueTypeString, fmt.Sprintf("%v", arg.GetDecimal())), nil
+	case ValueTypeBigInt:
+		return GetTokenNode(ValueTypeString, arg.GetBigInt().String()), nil
 	case ValueTypeString:
 		return GetTokenNode(ValueTypeString, arg.GetString()), nil
 	}
@@ -114,8 +84,11 @@ func (o *TokenOperator) funcDecimal(argList []*TokenNode) (*TokenNode, error) {
 	case ValueTypeInteger:
 		return GetTokenNode(ValueTypeDecimal, decimal.NewFromInt(arg.GetInt())), nil
 	case ValueTypeFloat:
+		if isNonFiniteFloat(arg) {
+			return nil, GetError(ErrRuleEngineFuncArgument, fmt.Sprintf("invalid float arg in func decimal, arg: %v", arg.Value))
+		}
 		return GetTokenNode(ValueTypeDecimal, decimal.NewFromFloat(arg.GetFloat())), nil
-	case ValueTypeDecimal:
+	case ValueTypeDecimal, ValueTypeBigInt:
 		return GetTokenNode(ValueTypeDecimal, arg.GetDecimal()), nil
 	case ValueTypeString:
 		value, err := decimal.NewFromString(arg.GetString())
@@ -144,6 +117,8 @@ func (o *TokenOperator) funcFloat(argList []*TokenNode) (*TokenNode, error) {
 		return GetTokenNode(ValueTypeFloat, arg.GetFloat()), nil
 	case ValueTypeDecimal:
 		return GetTokenNode(ValueTypeFloat, arg.GetDecimal().InexactFloat64()), nil
+	case ValueTypeBigInt:
+		return GetTokenNode(ValueTypeFloat, arg.GetFloat()), nil
 	case ValueTypeString:
 		value, err := strconv.ParseFloat(arg.GetString(), 64)
 		if err != nil {
@@ -165,14 +140,27 @@ func (o *TokenOperator) funcInt(argList []*TokenNode) (*TokenNode, error) {
 	}
 
 	switch arg.ValueType {
-	case ValueTypeInteger:
-		return GetTokenNode(ValueTypeInteger, arg.GetInt()), nil
+	case ValueTypeInteger, ValueTypeBigInt:
+		return GetTokenNode(arg.ValueType, arg.Value), nil
 	case ValueTypeFloat:
-		return GetTokenNode(ValueTypeInteger, int64(arg.GetFloat())), nil
+		f := arg.GetFloat()
+		if f >= math.MinInt64 && f < math.MaxInt64 {
+			return GetTokenNode(ValueTypeInteger, int64(f)), nil
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, GetError(ErrRuleEngineFuncArgument, fmt.Sprintf("invalid float arg in func int, arg: %v", arg.Value))
+		}
+		value, _ := big.NewFloat(f).Int(nil)
+		return o.bigIntResult(value, "int")
 	case ValueTypeDecimal:
-		return GetTokenNode(ValueTypeInteger, arg.GetDecimal().IntPart()), nil
+		return o.bigIntResult(arg.GetDecimal().BigInt(), "int")
 	case ValueTypeString:
 		value, err := strconv.ParseInt(arg.GetString(), 0, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			if bigValue, ok := new(big.Int).SetString(arg.GetString(), 0); ok {
+				return o.bigIntResult(bigValue, "int")
+			}
+		}
 		if err != nil {
 			return nil, GetError(ErrRuleEngineFuncArgument, fmt.Sprintf("invalid string arg in func int, arg: %v", arg.Value))
 		}
@@ -208,22 +196,80 @@ func (o *TokenOperator) funcUpper(argList []*TokenNode) (*TokenNode, error) {
 }
 
 func (o *TokenOperator) funcRegexMatch(argList []*TokenNode) (*TokenNode, error) {
-	if len(argList) != 2 {
-		return nil, getArgNumberError(2, len(argList))
+	re, s, err := o.regexArgs(argList, 2, "regexMatch")
+	if err != nil {
+		return nil, err
+	}
+	return GetTokenNode(ValueTypeBool, re.MatchString(s)), nil
+}
+
+// the leftmost match of the pattern, empty string if not match
+func (o *TokenOperator) funcRegexFind(argList []*TokenNode) (*TokenNode, error) {
+	re, s, err := o.regexArgs(argList, 2, "regexFind")
+	if err != nil {
+		return nil, err
 	}
+	return GetTokenNode(ValueTypeString, re.FindString(s)), nil
+}
 
-	if err := batchCheckOperType(argList, operTypeRegex, "regexMatch"); err != nil {
+// the n-th (from 0) non-overlapping match of the pattern, empty string if not exist
+func (o *TokenOperator) funcRegexFindAll(argList []*TokenNode) (*TokenNode, error) {
+	re, s, err := o.regexArgs(argList, 3, "regexFindAll")
+	if err != nil {
 		return nil, err
 	}
+	if argList[2].ValueType != ValueTypeInteger || argList[2].GetInt() < 0 {
+		return nil, GetError(ErrRuleEngineFuncArgument, "regexFindAll index should be a non-negative integer")
+	}
+
+	index := int(argList[2].GetInt())
+	matches := re.FindAllString(s, index+1)
+	if index >= len(matches) {
+		return GetTokenNode(ValueTypeString, ""), nil
+	}
+	return GetTokenNode(ValueTypeString, matches[index]), nil
+}
 
-	pattern := argList[0].GetString()
-	s := argList[1].GetString()
+// replace all the matches with repl, $1 or ${name} in repl is the capture group
+func (o *TokenOperator) funcRegexReplace(argList []*TokenNode) (*TokenNode, error) {
+	re, s, err := o.regexArgs(argList, 3, "regexReplace")
+	if err != nil {
+		return nil, err
+	}
+	if argList[2].ValueType != ValueTypeString {
+		return nil, GetError(ErrRuleEngineFuncArgument, "regexReplace repl should be string")
+	}
+	return GetTokenNode(ValueTypeString, re.ReplaceAllString(s, argList[2].GetString())), nil
+}
 
-	matched, err := regexp.MatchString(pattern, s)
+// the capture group of the leftmost match, group can be the index or the name of the group.
+// empty string if not match.
+func (o *TokenOperator) funcRegexGroups(argList []*TokenNode) (*TokenNode, error) {
+	re, s, err := o.regexArgs(argList, 3, "regexGroups")
 	if err != nil {
-		return nil, GetError(ErrRuleEngineRegexMatch, fmt.Sprintf("regexMatch failed, %v", err))
+		return nil, err
 	}
-	return GetTokenNode(ValueTypeBool, matched), nil
+
+	group := -1
+	switch arg := argList[2]; arg.ValueType {
+	case ValueTypeInteger:
+		if arg.GetInt() >= 0 && arg.GetInt() <= int64(re.NumSubexp()) {
+			group = int(arg.GetInt())
+		}
+	case ValueTypeString:
+		group = re.SubexpIndex(arg.GetString())
+	default:
+		return nil, GetError(ErrRuleEngineFuncArgument, "regexGroups group should be integer or string")
+	}
+	if group < 0 {
+		return nil, GetError(ErrRuleEngineFuncArgument, fmt.Sprintf("regexGroups unknown group: %v", argList[2].Value))
+	}
+
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return GetTokenNode(ValueTypeString, ""), nil
+	}
+	return GetTokenNode(ValueTypeString, match[group]), nil
 }
 
 func (o *TokenOperator) funcLen(argList []*TokenNode) (*TokenNode, error) {
@@ -254,7 +300,11 @@ func (o *TokenOperator) funcMin(argList []*TokenNode) (*TokenNode, error) {
 	for _, arg := range argList {
 		if res.ValueType == ValueTypeInteger && arg.ValueType == ValueTypeInteger {
 			res.Value = intMin(res.GetInt(), arg.GetInt())
-		} else if o.decimalMode || res.ValueType == ValueTypeDecimal || arg.ValueType == ValueTypeDecimal {
+		} else if isIntegerType(res) && isIntegerType(arg) {
+			if arg.GetBigInt().Cmp(res.GetBigInt()) < 0 {
+				res.ValueType, res.Value = arg.ValueType, arg.Value
+			}
+		} else if o.needDecimal(res, arg) {
 			res.Value = decimal.Min(res.GetDecimal(), arg.GetDecimal())
 			res.ValueType = ValueTypeDecimal
 		} else {
@@ -281,7 +331,11 @@ func (o *TokenOperator) funcMax(argList []*TokenNode) (*TokenNode, error) {
 	for _, arg := range argList {
 		if res.ValueType == ValueTypeInteger && arg.ValueType == ValueTypeInteger {
 			res.Value = intMax(res.GetInt(), arg.GetInt())
-		} else if o.decimalMode || res.ValueType == ValueTypeDecimal || arg.ValueType == ValueTypeDecimal {
+		} else if isIntegerType(res) && isIntegerType(arg) {
+			if arg.GetBigInt().Cmp(res.GetBigInt()) > 0 {
+				res.ValueType, res.Value = arg.ValueType, arg.Value
+			}
+		} else if o.needDecimal(res, arg) {
 			res.Value = decimal.Max(res.GetDecimal(), arg.GetDecimal())
 			res.ValueType = ValueTypeDecimal
 		} else {
@@ -305,7 +359,12 @@ func (o *TokenOperator) funcAbs(argList []*TokenNode) (*TokenNode, error) {
 
 	switch arg.ValueType {
 	case ValueTypeInteger:
+		if arg.GetInt() == math.MinInt64 {
+			return o.bigIntResult(new(big.Int).Abs(arg.GetBigInt()), "abs")
+		}
 		return GetTokenNode(ValueTypeInteger, intAbs(arg.GetInt())), nil
+	case ValueTypeBigInt:
+		return o.bigIntResult(new(big.Int).Abs(arg.GetBigInt()), "abs")
 	case ValueTypeFloat:
 		return GetTokenNode(ValueTypeFloat, math.Abs(arg.GetFloat())), nil
 	case ValueTypeDecimal:
@@ -341,3 +400,44 @@ func (o *TokenOperator) funcEndWith(argList []*TokenNode) (*TokenNode, error) {
 
 	return GetTokenNode(ValueTypeBool, res), nil
 }
+
+func (o *TokenOperator) funcConcat(argList []*TokenNode) (*TokenNode, error) {
+	if err := batchCheckOperType(argList, operTypeString, "concat"); err != nil {
+		return nil, err
+	}
+
+	var builder strings.Builder
+	for _, arg := range argList {
+		builder.WriteString(arg.GetString())
+	}
+
+	return GetTokenNode(ValueTypeString, builder.String()), nil
+}
+
+func (o *TokenOperator) funcIsNaN(argList []*TokenNode) (*TokenNode, error) {
+	if len(argList) != 1 {
+		return nil, getArgNumberError(1, len(argList))
+	}
+
+	arg := argList[0]
+	if err := checkOperType(arg, operTypeMath, "isNaN"); err != nil {
+		return nil, err
+	}
+
+	res := arg.ValueType == ValueTypeFloat && math.IsNaN(arg.GetFloat())
+	return GetTokenNode(ValueTypeBool, res), nil
+}
+
+func (o *TokenOperator) funcIsInf(argList []*TokenNode) (*TokenNode, error) {
+	if len(argList) != 1 {
+		return nil, getArgNumberError(1, len(argList))
+	}
+
+	arg := argList[0]
+	if err := checkOperType(arg, operTypeMath, "isInf"); err != nil {
+		return nil, err
+	}
+
+	res := arg.ValueType == ValueTypeFloat && math.IsInf(arg.GetFloat(), 0)
+	return GetTokenNode(ValueTypeBool, res), nil
+}
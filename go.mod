@@ -1,5 +1,5 @@
 module github.com/uyouii/rule_engine
 
-go 1.17
+go 1.18
 
 require github.com/shopspring/decimal v1.3.1
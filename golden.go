@@ -0,0 +1,335 @@
+package rule_engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// GoldenSpec is a file of the test cases of the rules, like
+//
+//	{
+//	  "decimal": false,
+//	  "rules": {"adult": "{{age}} >= 18"},
+//	  "rule_files": {"discount": "rules/discount.rule"},
+//	  "cases": [
+//	    {"name": "adult", "rule": "adult", "params": [{"name": "age", "value": 18}], "expect": {"value": true}},
+//	    {"expr": "{{price}} * 2", "params": [{"name": "price", "type": "decimal", "value": "1.5"}],
+//	     "expect": {"type": "decimal", "value": "3"}},
+//	    {"expr": "{{missing}} > 1", "error_code": 7}
+//	  ]
+//	}
+//
+// the path of the rule file is relative to the spec file.
+type GoldenSpec struct {
+	Decimal   bool              `json:"decimal"`    // parse the float as decimal, like the useDecimal of GetNewPraser
+	Rules     map[string]string `json:"rules"`      // the rules by name
+	RuleFiles map[string]string `json:"rule_files"` // the files of the rules by name
+	Cases     []*GoldenCase     `json:"cases"`
+
+	Path string `json:"-"` // the spec file, set by LoadGoldenSpec
+}
+
+// GoldenCase is a test case, the expression is Expr, or the rule named Rule in the spec.
+// the result is checked by Expect, or the EngineErr code is checked by ErrorCode if not 0.
+type GoldenCase struct {
+	Name      string         `json:"name"`
+	Expr      string         `json:"expr"`
+	Rule      string         `json:"rule"`
+	Params    []*GoldenParam `json:"params"`
+	Expect    *GoldenValue   `json:"expect"`
+	ErrorCode int            `json:"error_code"`
+}
+
+// GoldenParam is a param of the case, the type is optional, one of integer, float, bool, string, decimal, bigint.
+// the number in string is allowed for decimal and bigint, like "0.1".
+type GoldenParam struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// GoldenValue is the expected result, only the type is checked if the value is null
+type GoldenValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// GoldenResult is the result of a case, Diff and Trace are set if the case failed
+type GoldenResult struct {
+	Name   string
+	Passed bool
+	Diff   string   // the expected and the actual in the unified diff style
+	Trace  []string // the value of each var and operator in the evaluation order, like "{{age}} >= 18: false (bool)"
+	Actual *TokenNode
+	Err    error
+}
+
+// the failure message, the diff and the trace
+func (r *GoldenResult) String() string {
+	if r.Passed {
+		return fmt.Sprintf("PASS %v", r.Name)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "FAIL %v\n%v", r.Name, r.Diff)
+	if len(r.Trace) > 0 {
+		b.WriteString("trace:\n")
+		for _, line := range r.Trace {
+			fmt.Fprintf(&b, "  %v\n", line)
+		}
+	}
+	return b.String()
+}
+
+// LoadGoldenSpec read the spec from the JSON file
+func LoadGoldenSpec(path string) (*GoldenSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("read spec %v failed, %v", path, err))
+	}
+	spec, err := ParseGoldenSpec(data)
+	if err != nil {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("spec %v, %v", path, err.(*EngineErr).ErrMsg))
+	}
+	spec.Path = path
+	return spec, nil
+}
+
+// ParseGoldenSpec parse the spec from JSON, the numbers are kept exactly, so the big integers and decimals are not lost.
+// the rule files are relative to the working directory, use LoadGoldenSpec for the spec file.
+func ParseGoldenSpec(data []byte) (*GoldenSpec, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	spec := &GoldenSpec{}
+	if err := decoder.Decode(spec); err != nil {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("invalid spec: %v", err))
+	}
+	for i, c := range spec.Cases {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case %v", i+1)
+		}
+	}
+	return spec, nil
+}
+
+// Run run all the cases in order
+func (s *GoldenSpec) Run(opts ...Option) []*GoldenResult {
+	results := make([]*GoldenResult, 0, len(s.Cases))
+	for _, c := range s.Cases {
+		results = append(results, s.RunCase(c, opts...))
+	}
+	return results
+}
+
+// RunCase run a case, the opts are passed to GetNewPraser
+func (s *GoldenSpec) RunCase(c *GoldenCase, opts ...Option) *GoldenResult {
+	res := &GoldenResult{Name: c.Name}
+	fail := func(format string, args ...interface{}) *GoldenResult {
+		res.Diff = fmt.Sprintf(format, args...)
+		return res
+	}
+
+	source, err := s.source(c)
+	if err != nil {
+		return fail("%v\n", err)
+	}
+	params := make([]*Param, 0, len(c.Params))
+	for _, param := range c.Params {
+		p, err := param.param()
+		if err != nil {
+			return fail("%v\n", err)
+		}
+		params = append(params, p)
+	}
+	praser, err := GetNewPraser(params, s.Decimal, opts...)
+	if err != nil {
+		return fail("%v\n", err)
+	}
+
+	prog, err := praser.Compile(source)
+	if err == nil {
+		res.Actual, res.Trace, res.Err = prog.trace()
+	} else {
+		res.Err = err
+	}
+
+	expected, err := c.expected(praser.operator)
+	if err != nil {
+		return fail("%v\n", err)
+	}
+	actual := describeGoldenResult(res.Actual, res.Err)
+	if res.Passed = c.match(praser.operator, res.Actual, res.Err); res.Passed {
+		res.Trace = nil
+		return res
+	}
+	return fail("--- expected\n+++ actual\n%v%v", prefixLines("-", expected), prefixLines("+", actual))
+}
+
+// prefix each line of the text, the multi-line error message like the syntax error is kept in the diff
+func prefixLines(prefix string, text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (s *GoldenSpec) source(c *GoldenCase) (string, error) {
+	switch {
+	case c.Expr != "" && c.Rule != "":
+		return "", GetError(ErrRuleEngineInvalidParam, "only one of expr and rule can be set")
+	case c.Expr != "":
+		return c.Expr, nil
+	case c.Rule == "":
+		return "", GetError(ErrRuleEngineInvalidParam, "expr or rule must be set")
+	}
+	if rule, ok := s.Rules[c.Rule]; ok {
+		return rule, nil
+	}
+	path, ok := s.RuleFiles[c.Rule]
+	if !ok {
+		return "", GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("unknown rule: %v", c.Rule))
+	}
+	if !filepath.IsAbs(path) && s.Path != "" {
+		path = filepath.Join(filepath.Dir(s.Path), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("read rule %v failed, %v", c.Rule, err))
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// the result matches the case
+func (c *GoldenCase) match(o *TokenOperator, actual *TokenNode, err error) bool {
+	if c.ErrorCode != Success {
+		engineErr, ok := err.(*EngineErr)
+		return ok && engineErr.ErrCode == c.ErrorCode
+	}
+	if err != nil || c.Expect == nil {
+		return err == nil
+	}
+	t, err := parseValueTypeName(c.Expect.Type)
+	if err != nil {
+		return false
+	}
+	if t != ValueTypeNone && actual.ValueType != t {
+		return false
+	}
+	if c.Expect.Value == nil {
+		return true
+	}
+	expected, err := o.parseParam(&Param{Name: "expect", Type: t, Value: goldenValue(c.Expect.Value, t)})
+	return err == nil && actual.compare(expected, o.floatEqual)
+}
+
+// the description of the expected result
+func (c *GoldenCase) expected(o *TokenOperator) (string, error) {
+	switch {
+	case c.ErrorCode != Success:
+		return fmt.Sprintf("error: code %v, %v", c.ErrorCode, ERROR_MSG_MAP[c.ErrorCode]), nil
+	case c.Expect == nil:
+		return "no error", nil
+	}
+	t, err := parseValueTypeName(c.Expect.Type)
+	if err != nil {
+		return "", err
+	}
+	if c.Expect.Value == nil {
+		return fmt.Sprintf("type: %v", t), nil
+	}
+	expected, err := o.parseParam(&Param{Name: "expect", Type: t, Value: goldenValue(c.Expect.Value, t)})
+	if err != nil {
+		return "", GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("invalid expect: %v", err))
+	}
+	return describeGoldenResult(expected, nil), nil
+}
+
+func describeGoldenResult(value *TokenNode, err error) string {
+	if err != nil {
+		if engineErr, ok := err.(*EngineErr); ok {
+			return fmt.Sprintf("error: code %v, %v, %v", engineErr.ErrCode, ERROR_MSG_MAP[engineErr.ErrCode], engineErr.ErrMsg)
+		}
+		return fmt.Sprintf("error: %v", err)
+	}
+	return fmt.Sprintf("value: %v", describeTokenNode(value))
+}
+
+// like "abc" (string) or 1.5 (decimal)
+func describeTokenNode(t *TokenNode) string {
+	if t.ValueType == ValueTypeString {
+		return fmt.Sprintf("%q (%v)", t.GetString(), t.ValueType)
+	}
+	return fmt.Sprintf("%v (%v)", t.GetString(), t.ValueType)
+}
+
+func (p *GoldenParam) param() (*Param, error) {
+	t, err := parseValueTypeName(p.Type)
+	if err != nil {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("param %v, %v", p.Name, err.(*EngineErr).ErrMsg))
+	}
+	return &Param{Name: p.Name, Type: t, Value: goldenValue(p.Value, t)}, nil
+}
+
+// change the json number to the go value by the type
+func goldenValue(value interface{}, t ValueType) interface{} {
+	n, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	switch t {
+	case ValueTypeDecimal:
+		if d, err := decimal.NewFromString(n.String()); err == nil {
+			return d
+		}
+	case ValueTypeBigInt, ValueTypeNone, ValueTypeInteger:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if i, ok := new(big.Int).SetString(n.String(), 10); ok {
+			return i
+		}
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
+
+// the empty name is ValueTypeNone
+func parseValueTypeName(name string) (ValueType, error) {
+	if name == "" {
+		return ValueTypeNone, nil
+	}
+	for t, typeName := range valueTypeNameDict {
+		if t != ValueTypeNone && typeName == name {
+			return t, nil
+		}
+	}
+	return ValueTypeNone, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("unknown type: %v", name))
+}
+
+// evaluate the program and record the value of each var and operator
+func (prog *Program) trace() (*TokenNode, []string, error) {
+	printer := &astPrinter{decimalMode: prog.oper.decimalMode}
+	var lines []string
+	e := newEvaluator(context.Background(), prog.oper)
+	e.observe = func(n *astNode, res *TokenNode) {
+		lines = append(lines, fmt.Sprintf("%v: %v", span(prog.source, n, printer).Text, describeTokenNode(res)))
+	}
+	res, err := e.eval(prog.root)
+	if err != nil {
+		return nil, lines, err
+	}
+	return prog.oper.roundResult(res), lines, nil
+}
@@ -0,0 +1,53 @@
+package rule_engine
+
+import (
+	"html"
+	"strings"
+)
+
+// the ANSI color of the token kinds
+var ansiColorDict = map[TokenKind]string{
+	TokenKindKeyword:  "\x1b[35m", // magenta
+	TokenKindOperator: "\x1b[37m", // white
+	TokenKindNumber:   "\x1b[36m", // cyan
+	TokenKindString:   "\x1b[32m", // green
+	TokenKindVariable: "\x1b[33m", // yellow
+	TokenKindFunction: "\x1b[34m", // blue
+	TokenKindError:    "\x1b[31;4m",
+}
+
+const ansiReset = "\x1b[0m"
+
+// HighlightHTML wrap the tokens in <span class="rule-kind">, like <span class="rule-keyword">and</span>,
+// the white spaces are kept and all the text is escaped.
+func HighlightHTML(str string) string {
+	return highlight(str, func(b *strings.Builder, t Token) {
+		b.WriteString(`<span class="rule-`)
+		b.WriteString(t.Kind.String())
+		b.WriteString(`">`)
+		b.WriteString(html.EscapeString(t.Text))
+		b.WriteString(`</span>`)
+	}, html.EscapeString)
+}
+
+// HighlightANSI color the tokens by the ANSI escape codes for the terminal
+func HighlightANSI(str string) string {
+	return highlight(str, func(b *strings.Builder, t Token) {
+		b.WriteString(ansiColorDict[t.Kind])
+		b.WriteString(t.Text)
+		b.WriteString(ansiReset)
+	}, func(s string) string { return s })
+}
+
+// write the tokens and the text between them
+func highlight(str string, writeToken func(b *strings.Builder, t Token), escape func(s string) string) string {
+	var b strings.Builder
+	pos := 0
+	for _, t := range Tokenize(str) {
+		b.WriteString(escape(str[pos:t.Pos]))
+		writeToken(&b, t)
+		pos = t.End
+	}
+	b.WriteString(escape(str[pos:]))
+	return b.String()
+}
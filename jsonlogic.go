@@ -0,0 +1,538 @@
+package rule_engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// the JsonLogic operators map to the binary operators directly
+var jsonLogicBinaryDict = map[string]int{
+	"==":  EQ,
+	"===": EQ,
+	"!=":  NE,
+	"!==": NE,
+	"<":   '<',
+	"<=":  LE,
+	">":   '>',
+	">=":  GE,
+	"%":   '%',
+}
+
+// the binary operators map to the JsonLogic operators when export
+var jsonLogicOperDict = map[int]string{
+	EQ:  "==",
+	NE:  "!=",
+	'<': "<",
+	LE:  "<=",
+	'>': ">",
+	GE:  ">=",
+	'+': "+",
+	'-': "-",
+	'*': "*",
+	'/': "/",
+	'%': "%",
+	AND: "and",
+	OR:  "or",
+}
+
+// the built-in functions map to the JsonLogic operators
+var jsonLogicFuncDict = map[string]string{
+	"min":    "min",
+	"max":    "max",
+	"concat": "cat",
+}
+
+// CompileJsonLogic compile the JsonLogic rule to the program, the source of the program is the expression
+// converted from the rule. the operators can not be converted get ErrRuleEngineTranslate with the path in the rule.
+func (p *Praser) CompileJsonLogic(logic []byte) (*Program, error) {
+	decoder := json.NewDecoder(bytes.NewReader(logic))
+	decoder.UseNumber()
+	var rule interface{}
+	if err := decoder.Decode(&rule); err != nil {
+		return nil, GetError(ErrRuleEngineTranslate, fmt.Sprintf("invalid JsonLogic: %v", err))
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, GetError(ErrRuleEngineTranslate, "invalid JsonLogic: extra data after the rule")
+	}
+
+	c := &jsonLogicImporter{decimalMode: p.operator.decimalMode}
+	root, err := c.build(rule, "")
+	if err != nil {
+		return nil, err
+	}
+	return p.Compile((&astPrinter{decimalMode: p.operator.decimalMode}).print(root))
+}
+
+type jsonLogicImporter struct {
+	decimalMode bool // the float number is converted to decimal
+}
+
+// path is the location of the rule in the JsonLogic document, like /and/1/==/0
+func jsonLogicError(path string, format string, args ...interface{}) error {
+	if path == "" {
+		path = "/"
+	}
+	return GetError(ErrRuleEngineTranslate, fmt.Sprintf(format, args...)+fmt.Sprintf(", path: %v", path))
+}
+
+func (c *jsonLogicImporter) build(rule interface{}, path string) (*astNode, error) {
+	switch v := rule.(type) {
+	case nil:
+		return nil, jsonLogicError(path, "null is not supported")
+	case bool:
+		return newTokenAstNode(GetTokenNode(ValueTypeBool, v), 0, 0), nil
+	case string:
+		return c.stringValue(v, path)
+	case json.Number:
+		return c.numberValue(v, path)
+	case []interface{}:
+		return nil, jsonLogicError(path, "array can only be used as the data of in")
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return nil, jsonLogicError(path, "the operation must have exactly one operator, but get %v", len(v))
+		}
+		for op, args := range v {
+			return c.buildOper(op, args, path+"/"+op)
+		}
+	}
+	return nil, jsonLogicError(path, "unknown value %v", rule)
+}
+
+func (c *jsonLogicImporter) stringValue(s string, path string) (*astNode, error) {
+	// the lexer does not unescape the string, so some strings have no literal
+	if _, ok := printStringLiteral(s); !ok {
+		return nil, jsonLogicError(path, "string %q can not be written as a literal", s)
+	}
+	return newTokenAstNode(GetTokenNode(ValueTypeString, s), 0, 0), nil
+}
+
+func (c *jsonLogicImporter) numberValue(n json.Number, path string) (*astNode, error) {
+	if i, err := n.Int64(); err == nil {
+		return newTokenAstNode(GetTokenNode(ValueTypeInteger, i), 0, 0), nil
+	}
+	if !strings.ContainsAny(n.String(), ".eE") {
+		return nil, jsonLogicError(path, "integer %v overflow int64", n)
+	}
+	if c.decimalMode {
+		value, err := decimal.NewFromString(n.String())
+		if err != nil {
+			return nil, jsonLogicError(path, "invalid number %v", n)
+		}
+		return newTokenAstNode(GetTokenNode(ValueTypeDecimal, value), 0, 0), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, jsonLogicError(path, "invalid number %v", n)
+	}
+	return newTokenAstNode(GetTokenNode(ValueTypeFloat, f), 0, 0), nil
+}
+
+// the arguments of the operator, a single argument can be written without the array
+func jsonLogicArgs(args interface{}) []interface{} {
+	if list, ok := args.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{args}
+}
+
+func (c *jsonLogicImporter) buildArgs(args []interface{}, path string) ([]*astNode, error) {
+	res := make([]*astNode, len(args))
+	for i, arg := range args {
+		node, err := c.build(arg, fmt.Sprintf("%v/%v", path, i))
+		if err != nil {
+			return nil, err
+		}
+		res[i] = node
+	}
+	return res, nil
+}
+
+func (c *jsonLogicImporter) buildOper(op string, rawArgs interface{}, path string) (*astNode, error) {
+	if op == "var" {
+		return c.buildVar(rawArgs, path)
+	}
+	rawArgList := jsonLogicArgs(rawArgs)
+	if op == "in" {
+		return c.buildIn(rawArgList, path)
+	}
+	args, err := c.buildArgs(rawArgList, path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "==", "===", "!=", "!==", ">", ">=", "%":
+		if len(args) != 2 {
+			return nil, jsonLogicError(path, "%v takes 2 args, but give %v", op, len(args))
+		}
+		return newBinaryAstNode(jsonLogicBinaryDict[op], args[0], args[1]), nil
+	case "<", "<=":
+		// between, like {"<": [1, {"var": "x"}, 10]}
+		if len(args) == 3 {
+			oper := jsonLogicBinaryDict[op]
+			return newBinaryAstNode(AND, newBinaryAstNode(oper, args[0], args[1]),
+				newBinaryAstNode(oper, args[1], args[2])), nil
+		}
+		if len(args) != 2 {
+			return nil, jsonLogicError(path, "%v takes 2 or 3 args, but give %v", op, len(args))
+		}
+		return newBinaryAstNode(jsonLogicBinaryDict[op], args[0], args[1]), nil
+	case "and", "or":
+		if len(args) == 0 {
+			return nil, jsonLogicError(path, "%v takes at least 1 arg", op)
+		}
+		oper := AND
+		if op == "or" {
+			oper = OR
+		}
+		return foldBinary(oper, args), nil
+	case "!":
+		if len(args) != 1 {
+			return nil, jsonLogicError(path, "! takes 1 arg, but give %v", len(args))
+		}
+		return newUnaryAstNode(NOT, args[0], 0), nil
+	case "if", "?:":
+		return c.buildIf(args, path)
+	case "+", "*":
+		// the single arg of + is casting to number, which has no equivalent
+		if len(args) < 2 {
+			return nil, jsonLogicError(path, "%v takes at least 2 args, but give %v", op, len(args))
+		}
+		return foldBinary(int(op[0]), args), nil
+	case "-":
+		if len(args) == 1 {
+			return newUnaryAstNode('-', args[0], 0), nil
+		}
+		if len(args) != 2 {
+			return nil, jsonLogicError(path, "- takes 1 or 2 args, but give %v", len(args))
+		}
+		return newBinaryAstNode('-', args[0], args[1]), nil
+	case "/":
+		if len(args) != 2 {
+			return nil, jsonLogicError(path, "/ takes 2 args, but give %v", len(args))
+		}
+		// the division of JsonLogic is not integer division
+		return newBinaryAstNode('/', c.numberCast(args[0]), args[1]), nil
+	case "min", "max":
+		if len(args) < 2 {
+			return nil, jsonLogicError(path, "%v takes at least 2 args, but give %v", op, len(args))
+		}
+		return newJsonLogicFuncNode(op, args), nil
+	case "cat":
+		return c.buildCat(args, path)
+	}
+	return nil, jsonLogicError(path, "unsupported JsonLogic operator %q", op)
+}
+
+// a op b op c -> (a op b) op c
+func foldBinary(oper int, args []*astNode) *astNode {
+	res := args[0]
+	for _, arg := range args[1:] {
+		res = newBinaryAstNode(oper, res, arg)
+	}
+	return res
+}
+
+func newJsonLogicFuncNode(name string, args []*astNode) *astNode {
+	var argsNode *astNode
+	for _, arg := range args {
+		argsNode = newArgsAstNode(argsNode, arg)
+	}
+	// the name is always a built-in function
+	node, _ := newFuncAstNode(newTokenAstNode(GetTokenNode(ValueTypeString, name), 0, 0), argsNode, 0)
+	return node
+}
+
+// cast the dividend to float or decimal, so the division is not integer division
+func (c *jsonLogicImporter) numberCast(n *astNode) *astNode {
+	name := "float"
+	if c.decimalMode {
+		name = "decimal"
+	}
+	if n.nodeType == astNodeValue {
+		switch n.value.ValueType {
+		case ValueTypeFloat, ValueTypeDecimal:
+			return n
+		case ValueTypeInteger:
+			if c.decimalMode {
+				return newTokenAstNode(GetTokenNode(ValueTypeDecimal, n.value.GetDecimal()), 0, 0)
+			}
+			return newTokenAstNode(GetTokenNode(ValueTypeFloat, float64(n.value.GetInt())), 0, 0)
+		}
+	}
+	return newJsonLogicFuncNode(name, []*astNode{n})
+}
+
+// {"if": [c1, x1, c2, x2, y]} -> x1 if c1 else (x2 if c2 else y)
+func (c *jsonLogicImporter) buildIf(args []*astNode, path string) (*astNode, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return nil, jsonLogicError(path, "if must have the else branch, but give %v args", len(args))
+	}
+	res := args[len(args)-1]
+	for i := len(args) - 3; i >= 0; i -= 2 {
+		res = newThirdOperAstNode(args[i+1], args[i], res)
+	}
+	return res, nil
+}
+
+// {"var": "a.b"} -> {{a.b}}, the default value and the whole data are not supported
+func (c *jsonLogicImporter) buildVar(rawArgs interface{}, path string) (*astNode, error) {
+	args := jsonLogicArgs(rawArgs)
+	if len(args) != 1 {
+		return nil, jsonLogicError(path, "var with default value is not supported")
+	}
+	var name string
+	switch v := args[0].(type) {
+	case string:
+		name = v
+	case json.Number:
+		name = v.String()
+	default:
+		return nil, jsonLogicError(path, "the var name must be string")
+	}
+
+	lex := &RuleEngineLex{inVar: true}
+	for i, part := range strings.Split(name, ".") {
+		token, matchStr := lex.matchRule(part)
+		if matchStr != part || (token != IDENTIFIER && (i == 0 || token != INTEGER)) {
+			return nil, jsonLogicError(path, "invalid var name %q", name)
+		}
+	}
+	return newVarAstNode(newTokenAstNode(GetTokenNode(ValueTypeString, name), 0, 0), 0, 0), nil
+}
+
+// {"in": [x, [a, b]]} -> x == a or x == b
+// {"in": ["sub", s]} -> regexMatch("sub", s), the sub string must be a constant
+func (c *jsonLogicImporter) buildIn(args []interface{}, path string) (*astNode, error) {
+	if len(args) != 2 {
+		return nil, jsonLogicError(path, "in takes 2 args, but give %v", len(args))
+	}
+	x, err := c.build(args[0], path+"/0")
+	if err != nil {
+		return nil, err
+	}
+
+	if list, ok := args[1].([]interface{}); ok {
+		if len(list) == 0 {
+			return newTokenAstNode(GetTokenNode(ValueTypeBool, false), 0, 0), nil
+		}
+		items, err := c.buildArgs(list, path+"/1")
+		if err != nil {
+			return nil, err
+		}
+		equals := make([]*astNode, len(items))
+		for i, item := range items {
+			equals[i] = newBinaryAstNode(EQ, x, item)
+		}
+		return foldBinary(OR, equals), nil
+	}
+
+	sub, ok := args[0].(string)
+	if !ok {
+		return nil, jsonLogicError(path, "the sub string of in must be a constant string")
+	}
+	s, err := c.build(args[1], path+"/1")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := c.stringValue(regexp.QuoteMeta(sub), path+"/0")
+	if err != nil {
+		return nil, err
+	}
+	return newJsonLogicFuncNode("regexMatch", []*astNode{pattern, s}), nil
+}
+
+// {"cat": [a, b]} -> concat(a, b), the integer literal is converted to string
+func (c *jsonLogicImporter) buildCat(args []*astNode, path string) (*astNode, error) {
+	for i, arg := range args {
+		if arg.nodeType != astNodeValue {
+			continue
+		}
+		switch arg.value.ValueType {
+		case ValueTypeString:
+		case ValueTypeInteger:
+			args[i] = newTokenAstNode(GetTokenNode(ValueTypeString, strconv.FormatInt(arg.value.GetInt(), 10)), 0, 0)
+		default:
+			return nil, jsonLogicError(fmt.Sprintf("%v/%v", path, i), "cat can only join string and integer literal")
+		}
+	}
+	return newJsonLogicFuncNode("concat", args), nil
+}
+
+// ToJsonLogic convert the program to the JsonLogic rule.
+// the constructs have no JsonLogic equivalent get ErrRuleEngineTranslate with the position.
+func (prog *Program) ToJsonLogic() ([]byte, error) {
+	rule, err := (&jsonLogicExporter{}).export(prog.root)
+	if err != nil {
+		return nil, err
+	}
+	// keep the operators like < and > readable
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(rule); err != nil {
+		return nil, GetError(ErrRuleEngineTranslate, err.Error())
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type jsonLogicExporter struct{}
+
+func jsonLogicOper(op string, args ...interface{}) map[string]interface{} {
+	return map[string]interface{}{op: args}
+}
+
+func (c *jsonLogicExporter) exportArgs(nodes []*astNode) ([]interface{}, error) {
+	res := make([]interface{}, len(nodes))
+	for i, n := range nodes {
+		arg, err := c.export(n)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = arg
+	}
+	return res, nil
+}
+
+func (c *jsonLogicExporter) export(n *astNode) (interface{}, error) {
+	switch n.nodeType {
+	case astNodeValue:
+		return jsonLogicValue(n)
+	case astNodeVar:
+		return map[string]interface{}{"var": n.varName()}, nil
+	case astNodeUnary:
+		x, err := c.export(n.children[0])
+		if err != nil {
+			return nil, err
+		}
+		if n.oper == NOT {
+			return jsonLogicOper("!", x), nil
+		}
+		return jsonLogicOper("-", x), nil
+	case astNodeBinary:
+		return c.exportBinary(n)
+	case astNodeThirdOper:
+		// x if c else y -> {"if": [c, x, y]}, the nested else is flatten
+		var args []*astNode
+		for ; n.nodeType == astNodeThirdOper; n = n.children[2] {
+			args = append(args, n.children[1], n.children[0])
+		}
+		list, err := c.exportArgs(append(args, n))
+		if err != nil {
+			return nil, err
+		}
+		return jsonLogicOper("if", list...), nil
+	case astNodeFunc:
+		return c.exportFunc(n)
+	}
+	return nil, translateError(n, "can not convert the expression to JsonLogic")
+}
+
+func (c *jsonLogicExporter) exportBinary(n *astNode) (interface{}, error) {
+	x, y := n.children[0], n.children[1]
+	if n.oper == '/' {
+		// "/" of JsonLogic is the float division, the integer division like 7 / 2 can not be converted
+		if !isFloatOperand(x) && !isFloatOperand(y) {
+			return nil, translateError(n, "the integer division can not be converted to JsonLogic, cast an operand by float()")
+		}
+		// the cast added when import
+		if isFloatCast(x) {
+			x = x.children[0]
+		}
+	}
+
+	var args []*astNode
+	if n.oper == AND || n.oper == OR || n.oper == '+' || n.oper == '*' {
+		// flatten a and b and c
+		args = flattenBinary(n.oper, x, nil)
+		args = flattenBinary(n.oper, y, args)
+	} else {
+		args = []*astNode{x, y}
+	}
+	list, err := c.exportArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return jsonLogicOper(jsonLogicOperDict[n.oper], list...), nil
+}
+
+// the operand makes the division a float division, the float literal or the cast
+func isFloatOperand(n *astNode) bool {
+	if n.nodeType == astNodeValue {
+		return n.value.ValueType == ValueTypeFloat || n.value.ValueType == ValueTypeDecimal
+	}
+	return isFloatCast(n)
+}
+
+func isFloatCast(n *astNode) bool {
+	return n.nodeType == astNodeFunc && len(n.children) == 1 && (n.funcName() == "float" || n.funcName() == "decimal")
+}
+
+func flattenBinary(oper int, n *astNode, res []*astNode) []*astNode {
+	if n.nodeType != astNodeBinary || n.oper != oper {
+		return append(res, n)
+	}
+	res = flattenBinary(oper, n.children[0], res)
+	return flattenBinary(oper, n.children[1], res)
+}
+
+func (c *jsonLogicExporter) exportFunc(n *astNode) (interface{}, error) {
+	if n.funcName() == "regexMatch" && len(n.children) == 2 {
+		// regexMatch("sub", s) with the quoted pattern -> {"in": ["sub", s]}
+		if sub, ok := unquoteMeta(n.children[0]); ok {
+			s, err := c.export(n.children[1])
+			if err != nil {
+				return nil, err
+			}
+			return jsonLogicOper("in", sub, s), nil
+		}
+	}
+
+	op, ok := jsonLogicFuncDict[n.funcName()]
+	if !ok {
+		return nil, translateError(n, "func %v can not be converted to JsonLogic", n.funcName())
+	}
+	list, err := c.exportArgs(n.children)
+	if err != nil {
+		return nil, err
+	}
+	return jsonLogicOper(op, list...), nil
+}
+
+// the literal string of the pattern, if the pattern is a quoted string literal
+func unquoteMeta(n *astNode) (string, bool) {
+	if n.nodeType != astNodeValue || n.value.ValueType != ValueTypeString {
+		return "", false
+	}
+	pattern := n.value.GetString()
+	var builder strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		builder.WriteByte(pattern[i])
+	}
+	sub := builder.String()
+	return sub, regexp.QuoteMeta(sub) == pattern
+}
+
+func jsonLogicValue(n *astNode) (interface{}, error) {
+	t := n.value
+	switch t.ValueType {
+	case ValueTypeFloat:
+		if f := t.GetFloat(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, translateError(n, "%v can not be converted to JsonLogic", f)
+		}
+	case ValueTypeDecimal, ValueTypeBigInt:
+		return json.Number(t.GetString()), nil
+	}
+	return t.Value, nil
+}
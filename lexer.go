@@ -1,20 +1,53 @@
 package rule_engine
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"regexp"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/shopspring/decimal"
 )
 
+// the limit of the input, 0 means no limit
+type parseLimit struct {
+	maxSourceLength int
+	maxDepth        int
+	maxArgs         int
+	maxStringLength int
+}
+
+// the parser keep going after the syntax error, stop when there are too many errors
+const maxSyntaxErrors = 20
+
+func init() {
+	// report the expected tokens in the syntax error
+	ruleEngineErrorVerbose = true
+}
+
+// sourceErr is an error of the range [pos, end) in the source
+type sourceErr struct {
+	err *EngineErr
+	pos int
+	end int
+}
+
 type RuleEngineLex struct {
-	str     string
-	pos     int
-	err     *EngineErr
-	resNode *TokenNode
-	oper    *TokenOperator
+	str           string
+	pos           int
+	errs          []*sourceErr
+	fatal         bool     // the error can not be recovered, like the limit exceeded, stop parsing
+	root          *astNode // the syntax tree of str
+	oper          *TokenOperator
+	inVar         bool // inside {{}}, the number is a part of the var path, like {{items.0.price}}
+	parenDepth    int  // the depth of the nested parentheses
+	tokenPos      int  // the start of the last token
+	tokenReported bool // the error of the last token is reported by the lexer
+	ended         bool // END is returned
 }
 
 func NewRuleEngineLex(str string, oper *TokenOperator) *RuleEngineLex {
@@ -24,19 +57,84 @@ func NewRuleEngineLex(str string, oper *TokenOperator) *RuleEngineLex {
 	}
 }
 
+// set the error of the last token, the parsing is stopped
 func (lex *RuleEngineLex) setErr(err error) int {
+	lex.fatal = true
+	return lex.setErrAt(err, lex.tokenPos, lex.pos)
+}
+
+// add the error of the node in [pos, end)
+func (lex *RuleEngineLex) setErrAt(err error, pos int, end int) int {
 	if err == nil {
 		return Success
 	}
-	lex.err = err.(*EngineErr)
-	return int(lex.err.ErrCode)
+	engineErr := err.(*EngineErr)
+	lex.errs = append(lex.errs, &sourceErr{err: engineErr, pos: pos, end: end})
+	if len(lex.errs) >= maxSyntaxErrors {
+		lex.fatal = true
+	}
+	return int(engineErr.ErrCode)
+}
+
+// report the syntax error of the last token, the parser recover from it
+func (lex *RuleEngineLex) syntaxErr(msg string) {
+	lex.tokenReported = true
+	lex.setErrAt(GetError(ErrRuleEngineSyntaxError, fmt.Sprintf("%v, pos: %v", msg, lex.tokenPos)), lex.tokenPos, lex.pos)
+}
+
+// the placeholder of the expression failed to parse, the range is the last error
+func (lex *RuleEngineLex) errorNode() *astNode {
+	last := lex.errs[len(lex.errs)-1]
+	return newTokenAstNode(GetTokenNode(ValueTypeNone, nil), last.pos, last.end)
+}
+
+// all the errors in one, the source is printed with the position of the syntax errors
+func (lex *RuleEngineLex) error() error {
+	if len(lex.errs) == 0 {
+		return nil
+	}
+	if len(lex.errs) == 1 && lex.errs[0].err.ErrCode != ErrRuleEngineSyntaxError {
+		return lex.errs[0].err
+	}
+
+	msgs := make([]string, 0, len(lex.errs))
+	var marks []byte
+	for _, e := range lex.errs {
+		msgs = append(msgs, e.err.ErrMsg)
+		if e.err.ErrCode != ErrRuleEngineSyntaxError {
+			continue
+		}
+		for len(marks) <= e.pos {
+			marks = append(marks, ' ')
+		}
+		marks[e.pos] = '^'
+	}
+	msg := strings.Join(msgs, "\n")
+	if marks != nil {
+		msg += fmt.Sprintf("\n%v\n%v\n", lex.str, string(marks))
+	}
+	return GetError(lex.errs[0].err.ErrCode, msg)
+}
+
+// compile the rules once, compile them for each token makes the long input very slow
+var tokenRuleRegexps = compileTokenRules(TOKEN_RULE_LIST[:])
+var keyWordRegexps = compileTokenRules(KEY_WORD_LIST[:])
+
+func compileTokenRules(rules []tokenRule) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		res[i] = regexp.MustCompile("^" + rule.reStr)
+	}
+	return res
 }
 
 func (lex *RuleEngineLex) matchRule(str string) (int, string) {
 	token, resStr := 0, ""
-	for _, tokenRule := range TOKEN_RULE_LIST {
-		r, _ := regexp.Compile("^" + tokenRule.reStr)
-		matchStr := r.FindString(str)
+	for i, tokenRule := range TOKEN_RULE_LIST {
+		if lex.inVar && tokenRule.token == FLOAT {
+			continue
+		}
+		matchStr := tokenRuleRegexps[i].FindString(str)
 		if len(matchStr) != 0 {
 			token, resStr = tokenRule.token, matchStr
 			break
@@ -45,9 +143,8 @@ func (lex *RuleEngineLex) matchRule(str string) (int, string) {
 
 	// there is some key words match the identifier
 	if token == IDENTIFIER {
-		for _, tokenRule := range KEY_WORD_LIST {
-			r, _ := regexp.Compile("^" + tokenRule.reStr)
-			matchStr := r.FindString(resStr)
+		for i, tokenRule := range KEY_WORD_LIST {
+			matchStr := keyWordRegexps[i].FindString(resStr)
 			if len(matchStr) != 0 && matchStr == resStr {
 				token, resStr = tokenRule.token, matchStr
 				break
@@ -66,7 +163,15 @@ func (lex *RuleEngineLex) Lex(lval *ruleEngineSymType) int {
 		}
 	}
 
+	start := lex.pos
+	lex.tokenPos, lex.tokenReported = start, false
+	lval.ast = newTokenAstNode(nil, start, start)
+	if lex.fatal || lex.ended {
+		// the parser discard the tokens when recovering, return EOF to stop it
+		return 0
+	}
 	if lex.pos >= len(lex.str) {
+		lex.ended = true
 		return END
 	}
 
@@ -76,59 +181,186 @@ func (lex *RuleEngineLex) Lex(lval *ruleEngineSymType) int {
 
 	if len(matchStr) > 0 {
 		lex.pos += len(matchStr)
-		lval.node = &TokenNode{
+		node := &TokenNode{
 			ValueType: valueTokenToValueType[token],
 		}
+		lval.ast = newTokenAstNode(node, start, lex.pos)
 
 		switch token {
 		case STRING:
-			lval.node.Value = matchStr[1 : len(matchStr)-1]
+			node.Value = matchStr[1 : len(matchStr)-1]
+			if limit := lex.oper.limit.maxStringLength; limit > 0 && len(node.GetString()) > limit {
+				lex.setErr(GetError(ErrRuleEngineStringTooLong,
+					fmt.Sprintf("string literal length %v exceed the limit %v, pos: %v", len(node.GetString()), limit, start)))
+				return ERROR
+			}
 		case INTEGER:
-			if lval.node.Value, err = strconv.ParseInt(matchStr, 0, 64); err != nil {
+			if node.Value, err = strconv.ParseInt(matchStr, 0, 64); errors.Is(err, strconv.ErrRange) {
+				return lex.bigIntLiteral(lval, matchStr)
+			} else if err != nil {
+				lex.syntaxErr(fmt.Sprintf("syntax error: invalid number %v", matchStr))
 				return ERROR
 			}
 		case FLOAT:
 			if lex.oper.decimalMode {
-				if lval.node.Value, err = decimal.NewFromString(matchStr); err != nil {
-					return ERROR
-				}
-				lval.node.ValueType = ValueTypeDecimal
+				node.Value, err = decimal.NewFromString(matchStr)
+				node.ValueType = ValueTypeDecimal
 			} else {
-				if lval.node.Value, err = strconv.ParseFloat(matchStr, 64); err != nil {
-					return ERROR
-				}
+				node.Value, err = strconv.ParseFloat(matchStr, 64)
+			}
+			if err != nil {
+				lex.syntaxErr(fmt.Sprintf("syntax error: invalid number %v", matchStr))
+				return ERROR
 			}
 		case TRUE:
-			lval.node.Value, token = true, BOOL
+			node.Value, token = true, BOOL
 		case FALSE:
-			lval.node.Value, token = false, BOOL
+			node.Value, token = false, BOOL
 		case IDENTIFIER:
-			lval.node.Value = matchStr
+			node.Value = matchStr
+		case IDLEFT:
+			lex.inVar = true
+		case IDRIGHT:
+			lex.inVar = false
 		}
 
 		return token
 	}
 
-	c := rune(lex.str[lex.pos])
+	c, size := utf8.DecodeRuneInString(lex.str[lex.pos:])
 	if _, ok := VALID_CHAR_SET[c]; ok {
 		lex.pos += 1
+		lval.ast.end = lex.pos
+		switch c {
+		case '(':
+			lex.parenDepth++
+			if limit := lex.oper.limit.maxDepth; limit > 0 && lex.parenDepth > limit {
+				lex.setErr(GetError(ErrRuleEngineTooDeep, fmt.Sprintf("nested parentheses exceed the limit %v, pos: %v", limit, start)))
+				return ERROR
+			}
+		case ')':
+			lex.parenDepth--
+		}
 		return int(c) // 直接使用这个char
 	}
 
-	return ERROR // some thing wrong
+	// skip the char, so the parser can go on after the error
+	lex.pos += size
+	lex.syntaxErr(fmt.Sprintf("syntax error: invalid character %q", c))
+	return ERROR
+}
+
+// the integer literal overflow int64, handle it according to the integer overflow mode
+func (lex *RuleEngineLex) bigIntLiteral(lval *ruleEngineSymType, matchStr string) int {
+	value, ok := new(big.Int).SetString(matchStr, 0)
+	if !ok {
+		lex.syntaxErr(fmt.Sprintf("syntax error: invalid number %v", matchStr))
+		return ERROR
+	}
+	node, err := lex.oper.bigIntResult(value, "integer literal")
+	if err != nil {
+		lex.setErr(err)
+		return ERROR
+	}
+	lval.ast.value = node
+	return INTEGER
+}
+
+func (lex *RuleEngineLex) checkArgs(args *astNode) error {
+	if limit := lex.oper.limit.maxArgs; limit > 0 && len(args.children) > limit {
+		return GetError(ErrRuleEngineTooManyArgs, fmt.Sprintf("arguments count exceed the limit %v, pos: %v", limit, args.pos))
+	}
+	return nil
+}
+
+// the friendly name of the tokens in the syntax error
+var tokenNameDict = map[string]string{
+	"$end":       "end of input",
+	"END":        "end of input",
+	"INTEGER":    "integer",
+	"FLOAT":      "float",
+	"STRING":     "string",
+	"BOOL":       "bool",
+	"IDENTIFIER": "identifier",
+	"IDLEFT":     "'{{'",
+	"IDRIGHT":    "'}}'",
+	"AND":        "'and'",
+	"OR":         "'or'",
+	"NOT":        "'not'",
+	"LE":         "'<='",
+	"GE":         "'>='",
+	"EQ":         "'=='",
+	"NE":         "'!='",
+	"IF":         "'if'",
+	"ELSE":       "'else'",
+	"ERROR":      "invalid token",
 }
 
+func tokenName(name string) string {
+	if friendly, ok := tokenNameDict[name]; ok {
+		return friendly
+	}
+	return name
+}
+
+// the parser call Error with the message like "syntax error: unexpected INTEGER, expecting ')' or ','",
+// it is rewritten to "syntax error: unexpected integer 2, expected ')' or ',' after argument".
 func (lex *RuleEngineLex) Error(s string) {
-	prefix := make([]byte, lex.pos)
-	for i := 0; i < lex.pos; i++ {
-		prefix[i] = ' '
+	if lex.fatal || lex.tokenReported {
+		// the lexer has reported the error of the token
+		return
+	}
+	lex.syntaxErr(lex.syntaxErrMessage(s))
+}
+
+func (lex *RuleEngineLex) syntaxErrMessage(s string) string {
+	const prefix = "syntax error: unexpected "
+	if !strings.HasPrefix(s, prefix) {
+		return s
+	}
+	unexpected, expecting, ok := strings.Cut(s[len(prefix):], ", expecting ")
+
+	var msg string
+	switch unexpected {
+	case "$end", "END":
+		msg = "syntax error: unexpected end of input"
+	case "INTEGER", "FLOAT", "STRING", "BOOL", "IDENTIFIER":
+		msg = fmt.Sprintf("syntax error: unexpected %v %v", tokenName(unexpected), lex.str[lex.tokenPos:lex.pos])
+	default:
+		msg = fmt.Sprintf("syntax error: unexpected '%v'", lex.str[lex.tokenPos:lex.pos])
+	}
+
+	if !ok {
+		// the parser give up listing the tokens, there are too many tokens can start an expression
+		return msg + ", expected expression"
+	}
+	expected := strings.Split(expecting, " or ")
+	hint := ""
+	switch {
+	case len(expected) == 1 && expected[0] == "END":
+		return msg + ", expected operator or end of input"
+	case len(expected) == 1 && expected[0] == "IDENTIFIER":
+		return msg + ", expected var name after '{{'"
+	case len(expected) == 2 && expected[0] == "INTEGER" && expected[1] == "IDENTIFIER":
+		return msg + ", expected var name after '.'"
+	case strings.Contains(expecting, "','"):
+		hint = " after argument"
+	case strings.Contains(expecting, "IDRIGHT"):
+		hint = " after var name"
+	case len(expected) == 1 && expected[0] == "'('":
+		hint = " after func name"
+	case len(expected) == 1 && expected[0] == "ELSE":
+		hint = " after condition"
+	}
+	for i, name := range expected {
+		expected[i] = tokenName(name)
 	}
-	lex.err = GetError(ErrRuleEngineSyntaxError, fmt.Sprintf("%v, pos: %v\n%v\n%v\n", s, lex.pos, lex.str, string(prefix)+"^"))
+	return msg + ", expected " + strings.Join(expected, " or ") + hint
 }
 
 func (lex *RuleEngineLex) getErrCode() int {
-	if lex.err != nil {
-		return int(lex.err.ErrCode)
+	if len(lex.errs) > 0 {
+		return int(lex.errs[0].err.ErrCode)
 	}
 	return 0
 }
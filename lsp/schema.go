@@ -0,0 +1,32 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/uyouii/rule_engine"
+)
+
+// ParseSchema parse the schema from JSON object like {"user.name": "string", "amount": "decimal"},
+// the type is one of null, bool, integer, float, string, decimal, bigint, and any for unknown type.
+func ParseSchema(data []byte) (map[string]rule_engine.ValueType, error) {
+	names := map[string]string{}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	typeDict := map[string]rule_engine.ValueType{"any": rule_engine.ValueTypeNone}
+	for t := rule_engine.ValueTypeNone; t <= rule_engine.ValueTypeBigInt; t++ {
+		typeDict[t.String()] = t
+	}
+
+	schema := make(map[string]rule_engine.ValueType, len(names))
+	for name, typeName := range names {
+		t, ok := typeDict[typeName]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q of var %v", typeName, name)
+		}
+		schema[name] = t
+	}
+	return schema, nil
+}
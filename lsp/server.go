@@ -0,0 +1,302 @@
+// Package lsp is a Language Server Protocol server for the rule engine expressions.
+// each document is one expression, the server publish the diagnostics of the parser and the type checker,
+// and support completion, signature help and hover.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/uyouii/rule_engine"
+)
+
+var keywordList = []string{"and", "or", "not", "if", "else", "true", "false"}
+
+// Server serve one client, the documents are kept in memory
+type Server struct {
+	praser   *rule_engine.Praser
+	schema   map[string]rule_engine.ValueType
+	conn     *Conn
+	docs     map[string]string
+	shutdown bool
+	writeErr error // the error of writing the notification, which stops the server
+}
+
+// NewServer create the server, the praser decide the options like decimal mode and limits,
+// schema is the var names and types for completion and type check, ValueTypeNone means any type.
+func NewServer(praser *rule_engine.Praser, schema map[string]rule_engine.ValueType) *Server {
+	return &Server{praser: praser, schema: schema, docs: make(map[string]string)}
+}
+
+// ServeStdio serve the client over stdin and stdout
+func (s *Server) ServeStdio(ctx context.Context) error {
+	return s.Serve(ctx, os.Stdin, os.Stdout)
+}
+
+// Serve handle the messages until the client send exit, the stream is closed or the ctx is done.
+// the ctx is checked between the messages.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.conn = NewConn(r, w)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := s.conn.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var respErr *ResponseError
+		if errors.As(err, &respErr) {
+			// the body is not JSON, the id is unknown
+			if err := s.conn.reply(nil, nil, respErr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle the message, only the error of writing is returned
+func (s *Server) handle(msg *Message) error {
+	result, respErr := s.dispatch(msg)
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	if msg.ID == nil {
+		// notification has no response
+		return nil
+	}
+	return s.conn.reply(msg.ID, result, respErr)
+}
+
+func (s *Server) dispatch(msg *Message) (interface{}, *ResponseError) {
+	if s.shutdown && msg.ID != nil {
+		return nil, &ResponseError{Code: CodeInvalidRequest, Message: "server is shut down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		params := &DidOpenTextDocumentParams{}
+		if respErr := unmarshalParams(msg, params); respErr != nil {
+			return nil, respErr
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		params := &DidChangeTextDocumentParams{}
+		if respErr := unmarshalParams(msg, params); respErr != nil {
+			return nil, respErr
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		return nil, nil
+	case "textDocument/didClose":
+		params := &DidCloseTextDocumentParams{}
+		if respErr := unmarshalParams(msg, params); respErr != nil {
+			return nil, respErr
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.publish(params.TextDocument.URI, []Diagnostic{})
+		return nil, nil
+	case "textDocument/completion":
+		return s.withDocument(msg, s.completion)
+	case "textDocument/signatureHelp":
+		return s.withDocument(msg, s.signatureHelp)
+	case "textDocument/hover":
+		return s.withDocument(msg, s.hover)
+	}
+	if msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &ResponseError{Code: CodeMethodNotFound, Message: fmt.Sprintf("method not found: %v", msg.Method)}
+}
+
+func unmarshalParams(msg *Message, params interface{}) *ResponseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// call the handler with the text and the byte offset of the position
+func (s *Server) withDocument(msg *Message, handler func(text string, offset int) interface{}) (interface{}, *ResponseError) {
+	params := &TextDocumentPositionParams{}
+	if respErr := unmarshalParams(msg, params); respErr != nil {
+		return nil, respErr
+	}
+	text, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, &ResponseError{Code: CodeInvalidParams, Message: fmt.Sprintf("document not open: %v", params.TextDocument.URI)}
+	}
+	return handler(text, offsetOf(text, params.Position)), nil
+}
+
+func (s *Server) initialize() *InitializeResult {
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:      TextDocumentSyncFull,
+			CompletionProvider:    &CompletionOptions{TriggerCharacters: []string{"{", "."}},
+			SignatureHelpProvider: &SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
+			HoverProvider:         true,
+		},
+		ServerInfo: ServerInfo{Name: "rule_engine"},
+	}
+}
+
+func (s *Server) update(uri string, text string) {
+	s.docs[uri] = text
+	s.publish(uri, s.diagnostics(text))
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) {
+	err := s.conn.Notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	if err != nil && s.writeErr == nil {
+		s.writeErr = err
+	}
+}
+
+func (s *Server) diagnostics(text string) []Diagnostic {
+	res := []Diagnostic{}
+	if strings.TrimSpace(text) == "" {
+		return res
+	}
+	for _, d := range s.praser.TypeCheck(text, s.schema).Diagnostics {
+		res = append(res, Diagnostic{
+			Range:    rangeOf(text, d.Pos, d.End),
+			Severity: SeverityError,
+			Code:     d.Code,
+			Source:   "rule_engine",
+			Message:  d.Message,
+		})
+	}
+	return res
+}
+
+func (s *Server) completion(text string, offset int) interface{} {
+	ctx := scanContext(text, offset)
+	list := &CompletionList{Items: []CompletionItem{}}
+	if ctx.inString {
+		return list
+	}
+	if ctx.varStart >= 0 {
+		list.Items = s.varCompletion(text, ctx.varStart, offset)
+		return list
+	}
+
+	prefix := identBefore(text, offset)
+	for _, sig := range rule_engine.BuiltinFuncs() {
+		if strings.HasPrefix(sig.Name, prefix) {
+			list.Items = append(list.Items, CompletionItem{Label: sig.Name, Kind: CompletionKindFunction,
+				Detail: sig.Label, Documentation: sig.Doc})
+		}
+	}
+	for _, keyword := range keywordList {
+		if strings.HasPrefix(keyword, prefix) {
+			list.Items = append(list.Items, CompletionItem{Label: keyword, Kind: CompletionKindKeyword})
+		}
+	}
+	return list
+}
+
+// complete the var path after "{{", the whole path is replaced so the dot in the path is not a problem
+func (s *Server) varCompletion(text string, varStart int, offset int) []CompletionItem {
+	start := varStart
+	for start < offset && text[start] == ' ' {
+		start++
+	}
+	prefix := text[start:offset]
+	suffix := "}}"
+	if strings.HasPrefix(strings.TrimLeft(text[offset:], " "), "}}") {
+		suffix = ""
+	}
+
+	names := make([]string, 0, len(s.schema))
+	for name := range s.schema {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	res := make([]CompletionItem, 0, len(names))
+	for _, name := range names {
+		res = append(res, CompletionItem{
+			Label:    name,
+			Kind:     CompletionKindVariable,
+			Detail:   typeName(s.schema[name]),
+			TextEdit: &TextEdit{Range: rangeOf(text, start, offset), NewText: name + suffix},
+		})
+	}
+	return res
+}
+
+func (s *Server) signatureHelp(text string, offset int) interface{} {
+	ctx := scanContext(text, offset)
+	call, ok := ctx.call()
+	if !ok || ctx.inString {
+		return nil
+	}
+	sig, _ := rule_engine.GetFuncSignature(call.funcName)
+
+	info := SignatureInformation{Label: sig.Label, Documentation: sig.Doc, Parameters: []ParameterInformation{}}
+	for _, param := range sig.Params {
+		info.Parameters = append(info.Parameters, ParameterInformation{Label: param})
+	}
+	active := call.argIndex
+	if active >= len(sig.Params) && strings.Contains(sig.Params[len(sig.Params)-1], "...") {
+		active = len(sig.Params) - 1
+	}
+	return &SignatureHelp{Signatures: []SignatureInformation{info}, ActiveParameter: active}
+}
+
+func (s *Server) hover(text string, offset int) interface{} {
+	info, ok := s.praser.TypeCheck(text, s.schema).TypeAt(offset)
+	if !ok {
+		return nil
+	}
+
+	var value string
+	switch {
+	case info.Var != "":
+		value = fmt.Sprintf("```\n{{%v}}: %v\n```", info.Var, typeName(info.Type))
+	case info.Func != "":
+		sig, _ := rule_engine.GetFuncSignature(info.Func)
+		value = fmt.Sprintf("```\n%v\n```\n%v\n\nresult: %v", sig.Label, sig.Doc, typeName(info.Type))
+	default:
+		value = fmt.Sprintf("```\n%v: %v\n```", text[info.Pos:info.End], typeName(info.Type))
+	}
+	r := rangeOf(text, info.Pos, info.End)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}, Range: &r}
+}
+
+// ValueTypeNone is the unknown type
+func typeName(t rule_engine.ValueType) string {
+	if t == rule_engine.ValueTypeNone {
+		return "any"
+	}
+	return t.String()
+}
@@ -0,0 +1,222 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/uyouii/rule_engine"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   *Conn
+	nextID int
+}
+
+// start the server in process, the client and the server are connected by pipes
+func newTestClient(t *testing.T) (*testClient, chan error) {
+	praser, err := rule_engine.GetNewPraser([]*rule_engine.Param{rule_engine.GetParam("count", 3)}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	schema, err := ParseSchema([]byte(`{"user.name": "string", "user.age": "integer", "amount": "decimal", "extra": "any"}`))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(praser, schema).Serve(context.Background(), serverReader, serverWriter)
+		serverWriter.Close()
+	}()
+	return &testClient{t: t, conn: NewConn(clientReader, clientWriter)}, done
+}
+
+func (c *testClient) read() *Message {
+	msg, err := c.conn.Read()
+	if err != nil {
+		c.t.Fatalf("read message failed: %v", err)
+	}
+	return msg
+}
+
+// send the request and unmarshal the result, return the error of the response
+func (c *testClient) call(method string, params interface{}, result interface{}) *ResponseError {
+	c.nextID++
+	if err := c.conn.Call(c.nextID, method, params); err != nil {
+		c.t.Fatalf("call %v failed: %v", method, err)
+	}
+	msg := c.read()
+	if msg.ID == nil || string(*msg.ID) != strings.TrimSpace(string(mustMarshal(c.nextID))) {
+		c.t.Fatalf("unexpected message of %v: %+v", method, msg)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if err := json.Unmarshal(msg.Result, result); err != nil {
+		c.t.Fatalf("unmarshal result of %v failed: %v", method, err)
+	}
+	return nil
+}
+
+// send the notification which changes the document, and read the diagnostics published
+func (c *testClient) change(method string, params interface{}) []Diagnostic {
+	if err := c.conn.Notify(method, params); err != nil {
+		c.t.Fatalf("notify %v failed: %v", method, err)
+	}
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expect diagnostics, get: %+v", msg)
+	}
+	params2 := &PublishDiagnosticsParams{}
+	if err := json.Unmarshal(msg.Params, params2); err != nil {
+		c.t.Fatalf("%v\n", err)
+	}
+	return params2.Diagnostics
+}
+
+func (c *testClient) edit(text string) []Diagnostic {
+	return c.change("textDocument/didChange", &DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: testURI},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+	})
+}
+
+func (c *testClient) at(line int, character int) *TextDocumentPositionParams {
+	return &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: testURI},
+		Position: Position{Line: line, Character: character}}
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+const testURI = "file:///rules/discount.rule"
+
+func TestServer(t *testing.T) {
+	client, done := newTestClient(t)
+
+	initResult := &InitializeResult{}
+	if err := client.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, initResult); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if !initResult.Capabilities.HoverProvider || initResult.Capabilities.CompletionProvider == nil ||
+		initResult.Capabilities.TextDocumentSync != TextDocumentSyncFull {
+		t.Fatalf("unexpected capabilities: %+v", initResult.Capabilities)
+	}
+	if err := client.conn.Notify("initialized", map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// diagnostics
+	diagnostics := client.change("textDocument/didOpen", &DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: testURI, LanguageID: "rule", Text: `{{amount}} > 1 and {{user.name}} > 2`},
+	})
+	if len(diagnostics) != 1 || diagnostics[0].Code != rule_engine.ErrRuleEngineInvalidOperation ||
+		diagnostics[0].Range != (Range{Start: Position{0, 19}, End: Position{0, 32}}) {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	diagnostics = client.edit("len({{user.name}}) >\n  and true")
+	if len(diagnostics) != 1 || diagnostics[0].Code != rule_engine.ErrRuleEngineSyntaxError ||
+		diagnostics[0].Range.Start != (Position{1, 2}) {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	diagnostics = client.edit(`len({{user.name}}) > {{count}} and {{unknown}}`)
+	if len(diagnostics) != 1 || diagnostics[0].Code != rule_engine.ErrRuleEngineUnknownVarName {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	if diagnostics = client.edit(`len({{user.name}}) > {{count}}`); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+
+	// completion
+	client.edit(`{{user.a`)
+	list := &CompletionList{}
+	if err := client.call("textDocument/completion", client.at(0, 8), list); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Label != "user.age" || list.Items[0].TextEdit.NewText != "user.age}}" ||
+		list.Items[0].TextEdit.Range != (Range{Start: Position{0, 2}, End: Position{0, 8}}) {
+		t.Fatalf("unexpected completion: %+v", list.Items)
+	}
+	client.edit(`{{ }} > 1 and reg`)
+	if err := client.call("textDocument/completion", client.at(0, 3), list); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(list.Items) != 4 || list.Items[0].Label != "amount" || list.Items[0].TextEdit.NewText != "amount" {
+		t.Fatalf("unexpected completion: %+v", list.Items)
+	}
+	if err := client.call("textDocument/completion", client.at(0, 17), list); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(list.Items) != 5 || list.Items[0].Label != "regexFind" || list.Items[0].Kind != CompletionKindFunction {
+		t.Fatalf("unexpected completion: %+v", list.Items)
+	}
+
+	// signature help
+	client.edit(`regexReplace("(a,b", lower({{user.name}}), `)
+	help := &SignatureHelp{}
+	if err := client.call("textDocument/signatureHelp", client.at(0, 43), help); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(help.Signatures) != 1 || help.Signatures[0].Label != "regexReplace(pattern string, s string, repl string) string" ||
+		help.ActiveParameter != 2 {
+		t.Fatalf("unexpected signature help: %+v", help)
+	}
+	if err := client.call("textDocument/signatureHelp", client.at(0, 28), help); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if help.Signatures[0].Label != "lower(s string) string" || help.ActiveParameter != 0 {
+		t.Fatalf("unexpected signature help: %+v", help)
+	}
+
+	// hover, the character is counted in UTF-16
+	client.edit(`"é😀" == {{user.name}} or len({{extra}}) > {{count}}`)
+	hover := &Hover{}
+	if err := client.call("textDocument/hover", client.at(0, 11), hover); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if !strings.Contains(hover.Contents.Value, "{{user.name}}: string") ||
+		*hover.Range != (Range{Start: Position{0, 9}, End: Position{0, 22}}) {
+		t.Fatalf("unexpected hover: %+v", hover)
+	}
+	if err := client.call("textDocument/hover", client.at(0, 27), hover); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if !strings.Contains(hover.Contents.Value, "len(s string) integer") {
+		t.Fatalf("unexpected hover: %+v", hover)
+	}
+	if err := client.call("textDocument/hover", client.at(0, 33), hover); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if !strings.Contains(hover.Contents.Value, "{{extra}}: any") {
+		t.Fatalf("unexpected hover: %+v", hover)
+	}
+
+	if err := client.call("textDocument/definition", client.at(0, 0), hover); err == nil || err.Code != CodeMethodNotFound {
+		t.Fatalf("expect method not found, get: %v", err)
+	}
+	if err := client.call("textDocument/hover", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: "file:///x"}}, hover); err == nil || err.Code != CodeInvalidParams {
+		t.Fatalf("expect invalid params, get: %v", err)
+	}
+
+	if diagnostics = client.change("textDocument/didClose", &DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: testURI}}); len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	var null interface{}
+	if err := client.call("shutdown", nil, &null); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := client.conn.Notify("exit", nil); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("serve failed: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/uyouii/rule_engine"
+)
+
+// the byte offset of the position in the text, the position out of the text is moved to the nearest offset
+func offsetOf(text string, pos Position) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+
+	for units := 0; offset < len(text) && text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		units += utf16.RuneLen(r)
+		if units > pos.Character {
+			break
+		}
+		offset += size
+	}
+	return offset
+}
+
+// the position of the byte offset in the text
+func positionOf(text string, offset int) Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	pos := Position{}
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	pos.Line = strings.Count(text[:lineStart], "\n")
+	for _, r := range text[lineStart:offset] {
+		pos.Character += utf16.RuneLen(r)
+	}
+	return pos
+}
+
+func rangeOf(text string, pos int, end int) Range {
+	return Range{Start: positionOf(text, pos), End: positionOf(text, end)}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// the identifier ends at the offset, like "reg" in "regexMatch" when the cursor is after "g"
+func identBefore(text string, offset int) string {
+	start := offset
+	for start > 0 && isIdentChar(text[start-1]) {
+		start--
+	}
+	return text[start:offset]
+}
+
+// callFrame is a parenthesis not closed before the cursor
+type callFrame struct {
+	funcName string // empty if the parenthesis is not a func call
+	argIndex int    // the count of comma in the parenthesis
+}
+
+// cursorContext is what before the cursor
+type cursorContext struct {
+	inString bool
+	varStart int // the offset after the "{{" not closed, -1 if not in var
+	calls    []callFrame
+}
+
+// scan the text before the offset, the string literal is skipped like the lexer
+func scanContext(text string, offset int) cursorContext {
+	ctx := cursorContext{varStart: -1}
+	var quote byte
+	for i := 0; i < offset; i++ {
+		c := text[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote || c == '\n' {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(text[i:offset], "{{"):
+			ctx.varStart = i + 2
+			i++
+		case strings.HasPrefix(text[i:offset], "}}"):
+			ctx.varStart = -1
+			i++
+		case c == '(':
+			name := identBefore(text, len(strings.TrimRight(text[:i], " \t\r\n")))
+			if _, ok := rule_engine.GetFuncSignature(name); !ok {
+				name = ""
+			}
+			ctx.calls = append(ctx.calls, callFrame{funcName: name})
+		case c == ')':
+			if len(ctx.calls) > 0 {
+				ctx.calls = ctx.calls[:len(ctx.calls)-1]
+			}
+		case c == ',':
+			if len(ctx.calls) > 0 {
+				ctx.calls[len(ctx.calls)-1].argIndex++
+			}
+		}
+	}
+	ctx.inString = quote != 0
+	return ctx
+}
+
+// the innermost func call contains the cursor
+func (ctx cursorContext) call() (callFrame, bool) {
+	for i := len(ctx.calls) - 1; i >= 0; i-- {
+		if ctx.calls[i].funcName != "" {
+			return ctx.calls[i], true
+		}
+	}
+	return callFrame{}, false
+}
@@ -0,0 +1,52 @@
+package rule_engine
+
+import "regexp"
+
+var mongoCompareOperDict = map[int]string{
+	'<': "$lt",
+	'>': "$gt",
+	LE:  "$lte",
+	GE:  "$gte",
+	EQ:  "$eq",
+	NE:  "$ne",
+}
+
+// ToMongoFilter translate the bool expression to a MongoDB filter document.
+// fields map the var path to the field name, nil means use the var path, like "user.level".
+// the comparison must be between a var and a constant, otherwise get ErrRuleEngineTranslate.
+func (prog *Program) ToMongoFilter(fields map[string]string) (map[string]interface{}, error) {
+	filter, err := prog.buildFilter(fields)
+	if err != nil {
+		return nil, err
+	}
+	return mongoFilter(filter), nil
+}
+
+func mongoFilter(f *filterNode) map[string]interface{} {
+	switch f.kind {
+	case filterConst:
+		if f.match {
+			return map[string]interface{}{}
+		}
+		// nothing matches
+		return map[string]interface{}{"$nor": []interface{}{map[string]interface{}{}}}
+	case filterAnd, filterOr, filterNot:
+		oper := map[filterKind]string{filterAnd: "$and", filterOr: "$or", filterNot: "$nor"}[f.kind]
+		children := make([]interface{}, len(f.children))
+		for i, child := range f.children {
+			children[i] = mongoFilter(child)
+		}
+		return map[string]interface{}{oper: children}
+	case filterCompare:
+		return map[string]interface{}{f.field: map[string]interface{}{mongoCompareOperDict[f.oper]: f.value}}
+	}
+
+	pattern := f.value.(string)
+	switch f.kind {
+	case filterPrefix:
+		pattern = "^" + regexp.QuoteMeta(pattern)
+	case filterSuffix:
+		pattern = regexp.QuoteMeta(pattern) + "$"
+	}
+	return map[string]interface{}{f.field: map[string]interface{}{"$regex": pattern}}
+}
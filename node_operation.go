@@ -1,12 +1,71 @@
 package rule_engine
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/shopspring/decimal"
 )
 
 type TokenOperator struct {
-	decimalMode bool
-	varMap      map[string]*TokenNode
+	decimalMode       bool
+	varMap            map[string]*TokenNode
+	divisionPrecision int32
+	roundingMode      RoundingMode
+	resultScale       int32
+	useResultScale    bool
+	integerOverflow   IntegerOverflowMode
+	floatEqual        floatEqual
+	resolver          VariableResolver
+	budget            evalBudget
+	limit             parseLimit
+	regex             regexConfig
+	regexCache        *regexCache
+}
+
+// round the final decimal result if set WithResultScale
+func (o *TokenOperator) roundResult(t *TokenNode) *TokenNode {
+	if !o.useResultScale || t == nil || t.ValueType != ValueTypeDecimal {
+		return t
+	}
+	return GetTokenNode(ValueTypeDecimal, roundDecimal(t.GetDecimal(), o.resultScale, o.roundingMode))
+}
+
+// calculate in decimal if any side is decimal or bigint, or the decimal mode is on.
+// NaN and Inf are always calculated in float.
+func (o *TokenOperator) needDecimal(x, y *TokenNode) bool {
+	if isNonFiniteFloat(x) || isNonFiniteFloat(y) {
+		return false
+	}
+	return o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal ||
+		x.ValueType == ValueTypeBigInt || y.ValueType == ValueTypeBigInt
+}
+
+// an integer result which may not fit in int64, handle it according to the integer overflow mode
+func (o *TokenOperator) bigIntResult(v *big.Int, operName string) (*TokenNode, error) {
+	if v.IsInt64() {
+		return GetTokenNode(ValueTypeInteger, v.Int64()), nil
+	}
+	switch o.integerOverflow {
+	case IntegerOverflowBigInt:
+		return GetTokenNode(ValueTypeBigInt, v), nil
+	case IntegerOverflowDecimal:
+		return GetTokenNode(ValueTypeDecimal, decimal.NewFromBigInt(v, 0)), nil
+	}
+	return nil, GetError(ErrRuleEngineIntegerOverflow, fmt.Sprintf("integer overflow in %v, result: %v", operName, v))
+}
+
+// calculate in int64 if possible, otherwise calculate in big.Int
+func (o *TokenOperator) integerOperation(x, y *TokenNode, operName string,
+	intOper func(int64, int64) (int64, bool), bigOper func(z, x, y *big.Int) *big.Int) (*TokenNode, error) {
+	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
+		if res, ok := intOper(x.GetInt(), y.GetInt()); ok {
+			return GetTokenNode(ValueTypeInteger, res), nil
+		}
+	}
+	return o.bigIntResult(bigOper(new(big.Int), x.GetBigInt(), y.GetBigInt()), operName)
 }
 
 func (o *TokenOperator) tokenNodeAdd(x, y *TokenNode) (*TokenNode, error) {
@@ -14,11 +73,11 @@ func (o *TokenOperator) tokenNodeAdd(x, y *TokenNode) (*TokenNode, error) {
 		return nil, err
 	}
 
-	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
-		return GetTokenNode(ValueTypeInteger, x.GetInt()+y.GetInt()), nil
+	if isIntegerType(x) && isIntegerType(y) {
+		return o.integerOperation(x, y, "+", intAdd, (*big.Int).Add)
 	}
 
-	if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	if o.needDecimal(x, y) {
 		res := x.GetDecimal().Add(y.GetDecimal())
 		return GetTokenNode(ValueTypeDecimal, res), nil
 	}
@@ -31,11 +90,11 @@ func (o *TokenOperator) tokenNodeSub(x, y *TokenNode) (*TokenNode, error) {
 		return nil, err
 	}
 
-	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
-		return GetTokenNode(ValueTypeInteger, x.GetInt()-y.GetInt()), nil
+	if isIntegerType(x) && isIntegerType(y) {
+		return o.integerOperation(x, y, "-", intSub, (*big.Int).Sub)
 	}
 
-	if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	if o.needDecimal(x, y) {
 		res := x.GetDecimal().Sub(y.GetDecimal())
 		return GetTokenNode(ValueTypeDecimal, res), nil
 	}
@@ -48,11 +107,11 @@ func (o *TokenOperator) tokenNodeMul(x, y *TokenNode) (*TokenNode, error) {
 		return nil, err
 	}
 
-	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
-		return GetTokenNode(ValueTypeInteger, x.GetInt()*y.GetInt()), nil
+	if isIntegerType(x) && isIntegerType(y) {
+		return o.integerOperation(x, y, "*", intMul, (*big.Int).Mul)
 	}
 
-	if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	if o.needDecimal(x, y) {
 		res := x.GetDecimal().Mul(y.GetDecimal())
 		return GetTokenNode(ValueTypeDecimal, res), nil
 	}
@@ -65,16 +124,16 @@ func (o *TokenOperator) tokenNodeDiv(x, y *TokenNode) (*TokenNode, error) {
 		return nil, err
 	}
 
-	if y.GetDecimal().IsZero() {
+	if isZeroValue(y) {
 		return nil, GetError(ErrRuleEngineDivideByZero, "divide by zero")
 	}
 
-	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
-		return GetTokenNode(ValueTypeInteger, x.GetInt()/y.GetInt()), nil
+	if isIntegerType(x) && isIntegerType(y) {
+		return o.integerOperation(x, y, "/", intDiv, (*big.Int).Quo)
 	}
 
-	if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
-		res := x.GetDecimal().Div(y.GetDecimal())
+	if o.needDecimal(x, y) {
+		res := divDecimal(x.GetDecimal(), y.GetDecimal(), o.divisionPrecision, o.roundingMode)
 		return GetTokenNode(ValueTypeDecimal, res), nil
 	}
 
@@ -86,11 +145,11 @@ func (o *TokenOperator) tokenNodeMod(x, y *TokenNode) (*TokenNode, error) {
 		return nil, err
 	}
 
-	if y.GetInt() == 0 {
+	if (y.ValueType == ValueTypeInteger && y.GetInt() == 0) || (y.ValueType == ValueTypeBigInt && y.GetBigInt().Sign() == 0) {
 		return nil, GetError(ErrRuleEngineDivideByZero, "divide by zero")
 	}
 
-	return GetTokenNode(ValueTypeInteger, x.GetInt()%y.GetInt()), nil
+	return o.integerOperation(x, y, "%", intMod, (*big.Int).Rem)
 }
 
 func (o *TokenOperator) tokenNodeMinus(t *TokenNode) (*TokenNode, error) {
@@ -100,9 +159,15 @@ func (o *TokenOperator) tokenNodeMinus(t *TokenNode) (*TokenNode, error) {
 	res := &TokenNode{ValueType: t.ValueType}
 	switch t.ValueType {
 	case ValueTypeInteger:
+		if t.GetInt() == math.MinInt64 {
+			return o.bigIntResult(new(big.Int).Neg(t.GetBigInt()), "-")
+		}
 		res.Value = -t.GetInt()
+	case ValueTypeBigInt:
+		return o.bigIntResult(new(big.Int).Neg(t.GetBigInt()), "-")
 	case ValueTypeFloat:
-		if o.decimalMode {
+		// decimal can not keep NaN and Inf, they are kept as float
+		if o.decimalMode && !isNonFiniteFloat(t) {
 			res.Value = t.GetDecimal().Neg()
 			res.ValueType = ValueTypeDecimal
 		} else {
@@ -126,7 +191,7 @@ func (o *TokenOperator) tokenNodeGreater(x, y *TokenNode) (*TokenNode, error) {
 	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
 		// integer
 		res.Value = x.GetInt() > y.GetInt()
-	} else if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	} else if o.needDecimal(x, y) {
 		// decimal
 		res.Value = x.GetDecimal().GreaterThan(y.GetDecimal())
 		return res, nil
@@ -150,7 +215,7 @@ func (o *TokenOperator) tokenNodeLess(x, y *TokenNode) (*TokenNode, error) {
 	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
 		// integer
 		res.Value = x.GetInt() < y.GetInt()
-	} else if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	} else if o.needDecimal(x, y) {
 		// decimal
 		res.Value = x.GetDecimal().LessThan(y.GetDecimal())
 		return res, nil
@@ -174,7 +239,7 @@ func (o *TokenOperator) tokenNodeGreaterEqual(x, y *TokenNode) (*TokenNode, erro
 	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
 		// integer
 		res.Value = x.GetInt() >= y.GetInt()
-	} else if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	} else if o.needDecimal(x, y) {
 		// decimal
 		res.Value = x.GetDecimal().GreaterThanOrEqual(y.GetDecimal())
 		return res, nil
@@ -198,7 +263,7 @@ func (o *TokenOperator) tokenNodeLessEqual(x, y *TokenNode) (*TokenNode, error)
 	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
 		// integer
 		res.Value = x.GetInt() <= y.GetInt()
-	} else if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	} else if o.needDecimal(x, y) {
 		// decimal
 		res.Value = x.GetDecimal().LessThanOrEqual(y.GetDecimal())
 		return res, nil
@@ -240,13 +305,13 @@ func (o *TokenOperator) tokenNodeEqual(x, y *TokenNode) (*TokenNode, error) {
 	if x.ValueType == ValueTypeInteger && y.ValueType == ValueTypeInteger {
 		// integer
 		res.Value = x.GetInt() == y.GetInt()
-	} else if o.decimalMode || x.ValueType == ValueTypeDecimal || y.ValueType == ValueTypeDecimal {
+	} else if o.needDecimal(x, y) {
 		// decimal
 		res.Value = x.GetDecimal().Equal(y.GetDecimal())
 		return res, nil
 	} else {
 		// float
-		res.Value = isFloatEqual(x.GetFloat(), y.GetFloat())
+		res.Value = o.floatEqual.equal(x.GetFloat(), y.GetFloat())
 	}
 
 	return res, nil
@@ -301,21 +366,25 @@ func (o *TokenOperator) tokenNodeVarName(varNameToken *TokenNode, identifier *To
 	return res, nil
 }
 
-func (o *TokenOperator) tokenNodeVar(t *TokenNode) (*TokenNode, error) {
+// varCache keep the variables resolved by the resolver in a Parse
+func (o *TokenOperator) tokenNodeVar(ctx context.Context, t *TokenNode, varCache map[string]*TokenNode) (*TokenNode, error) {
 	varName := t.Value.(string)
 
 	unknownVarErr := GetError(ErrRuleEngineUnknownVarName, fmt.Sprintf("unknown var name: %v", varName))
 
-	if o.varMap == nil {
-		return nil, unknownVarErr
+	if variable, ok := o.varMap[varName]; ok {
+		return GetTokenNode(variable.ValueType, variable.Value), nil
 	}
 
-	variable, ok := o.varMap[varName]
-	if !ok {
-		return nil, unknownVarErr
+	if o.resolver != nil {
+		variable, err := o.resolveVar(ctx, varName, varCache)
+		if err != nil {
+			return nil, err
+		}
+		return GetTokenNode(variable.ValueType, variable.Value), nil
 	}
 
-	return GetTokenNode(variable.ValueType, variable.Value), nil
+	return nil, unknownVarErr
 }
 
 func (o *TokenOperator) tokenNodeThirdOper(x *TokenNode, c *TokenNode, y *TokenNode) (*TokenNode, error) {
@@ -0,0 +1,145 @@
+package rule_engine
+
+// Option change the default behavior of the Praser, pass it to GetNewPraser.
+type Option func(o *TokenOperator)
+
+// set the digits kept after the decimal point when divide decimal.
+// default is 16, the same as decimal.DivisionPrecision, but will not change the global value.
+func WithDivisionPrecision(precision int32) Option {
+	return func(o *TokenOperator) {
+		o.divisionPrecision = precision
+	}
+}
+
+// set the rounding mode used by decimal division and result scale.
+// default is RoundHalfUp.
+func WithRoundingMode(mode RoundingMode) Option {
+	return func(o *TokenOperator) {
+		o.roundingMode = mode
+	}
+}
+
+// round the final decimal result to scale digits after the decimal point.
+// only the result returned by Parse is rounded, the intermediate values keep their precision.
+func WithResultScale(scale int32) Option {
+	return func(o *TokenOperator) {
+		o.resultScale = scale
+		o.useResultScale = true
+	}
+}
+
+// set how to handle the integer overflow in calculation, params and literals.
+// default is IntegerOverflowError.
+func WithIntegerOverflow(mode IntegerOverflowMode) Option {
+	return func(o *TokenOperator) {
+		o.integerOverflow = mode
+	}
+}
+
+// compare float with x == y.
+func WithFloatEqualExact() Option {
+	return func(o *TokenOperator) {
+		o.floatEqual = floatEqual{mode: FloatEqualExact}
+	}
+}
+
+// compare float with |x - y| < epsilon, this is the default with epsilon 1e-11.
+func WithFloatEqualAbsolute(epsilon float64) Option {
+	return func(o *TokenOperator) {
+		o.floatEqual = floatEqual{mode: FloatEqualAbsolute, epsilon: epsilon}
+	}
+}
+
+// compare float with |x - y| <= epsilon * max(|x|, |y|).
+func WithFloatEqualRelative(epsilon float64) Option {
+	return func(o *TokenOperator) {
+		o.floatEqual = floatEqual{mode: FloatEqualRelative, epsilon: epsilon}
+	}
+}
+
+// float are equal if there are at most ulps representable float between them.
+func WithFloatEqualULP(ulps uint64) Option {
+	return func(o *TokenOperator) {
+		o.floatEqual = floatEqual{mode: FloatEqualULP, ulps: ulps}
+	}
+}
+
+// resolve the variables not in params by the resolver when they are used.
+func WithVariableResolver(resolver VariableResolver) Option {
+	return func(o *TokenOperator) {
+		o.resolver = resolver
+	}
+}
+
+// limit the steps in an evaluation, each operator, function and variable is a step.
+// exceed the limit will return ErrRuleEngineBudgetExceeded. 0 means no limit.
+func WithMaxSteps(n int) Option {
+	return func(o *TokenOperator) {
+		o.budget.maxSteps = n
+	}
+}
+
+// limit the function calls in an evaluation. 0 means no limit.
+func WithMaxFuncCalls(n int) Option {
+	return func(o *TokenOperator) {
+		o.budget.maxFuncCalls = n
+	}
+}
+
+// limit the total bytes of the strings created by functions in an evaluation. 0 means no limit.
+func WithMaxStringBytes(n int) Option {
+	return func(o *TokenOperator) {
+		o.budget.maxStringBytes = n
+	}
+}
+
+// limit the bytes of the source string. 0 means no limit.
+func WithMaxSourceLength(n int) Option {
+	return func(o *TokenOperator) {
+		o.limit.maxSourceLength = n
+	}
+}
+
+// limit the depth of the expression, both the depth of the syntax tree and the nested parentheses.
+// 0 means no limit.
+func WithMaxDepth(n int) Option {
+	return func(o *TokenOperator) {
+		o.limit.maxDepth = n
+	}
+}
+
+// limit the count of the arguments of a function call. 0 means no limit.
+func WithMaxArgs(n int) Option {
+	return func(o *TokenOperator) {
+		o.limit.maxArgs = n
+	}
+}
+
+// limit the bytes of a string literal. 0 means no limit.
+func WithMaxStringLength(n int) Option {
+	return func(o *TokenOperator) {
+		o.limit.maxStringLength = n
+	}
+}
+
+// set the max count of the compiled regex cached by the Praser, default is 128. 0 means no cache.
+func WithRegexCacheSize(n int) Option {
+	return func(o *TokenOperator) {
+		o.regex.cacheSize = n
+	}
+}
+
+// limit the length of the regex pattern, exceed it will get ErrRuleEngineRegexLimit. 0 means no limit.
+func WithMaxRegexLength(n int) Option {
+	return func(o *TokenOperator) {
+		o.regex.maxPatternLength = n
+	}
+}
+
+// limit the length of the string matched by the regex functions, exceed it will get ErrRuleEngineRegexLimit.
+// 0 means no limit.
+func WithMaxRegexInputLength(n int) Option {
+	return func(o *TokenOperator) {
+		o.regex.maxInputLength = n
+	}
+}
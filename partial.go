@@ -0,0 +1,100 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// PartialEval fold the sub expressions which only depend on the literals, the params and the variables of the Praser,
+// and return the residual program. the other variables are kept, and will be resolved when evaluate the residual.
+// the sub expression failed to calculate is kept too, so the error is still returned when evaluate it.
+// x if c else y with the known c is the branch taken, if the other branch is folded to a value which can not fail.
+//
+// the resolver of the Praser is not called in PartialEval.
+func (prog *Program) PartialEval(params []*Param) (*Program, error) {
+	known := make(map[string]*TokenNode, len(params))
+	for _, param := range params {
+		node, err := prog.oper.parseParam(param)
+		if err != nil {
+			return nil, err
+		}
+		if !hasLiteral(node) {
+			return nil, GetError(ErrRuleEngineInvalidParam,
+				fmt.Sprintf("param %v can not be written in the residual program", param.Name))
+		}
+		known[param.Name] = node
+	}
+
+	folder := newEvaluator(context.Background(), prog.oper)
+	folder.budget = evalBudget{}
+
+	root := folder.fold(prog.root, known)
+	residual := &Program{root: root, oper: prog.oper}
+	residual.source = residual.String()
+	return residual, nil
+}
+
+// the final value if the program is folded to a constant
+func (prog *Program) Value() (*TokenNode, bool) {
+	if prog.root.nodeType != astNodeValue {
+		return nil, false
+	}
+	res, err := prog.Eval()
+	return res, err == nil
+}
+
+// fold the node, the origin syntax tree is not changed
+func (e *evaluator) fold(n *astNode, known map[string]*TokenNode) *astNode {
+	switch n.nodeType {
+	case astNodeValue:
+		return n
+	case astNodeVar:
+		value, ok := known[n.varName()]
+		if !ok {
+			value, ok = e.oper.varMap[n.varName()]
+		}
+		if !ok || !hasLiteral(value) {
+			return n
+		}
+		return newTokenAstNode(value, n.pos, n.end)
+	}
+
+	folded := *n
+	folded.children = make([]*astNode, len(n.children))
+	allValue := true
+	for i, child := range n.children {
+		folded.children[i] = e.fold(child, known)
+		allValue = allValue && folded.children[i].nodeType == astNodeValue
+	}
+	if n.nodeType == astNodeThirdOper {
+		// x if c else y, all the operands are evaluated, so the other branch is kept if it may fail
+		x, c, y := folded.children[0], folded.children[1], folded.children[2]
+		if c.nodeType == astNodeValue && c.value.ValueType == ValueTypeBool {
+			if c.value.GetBool() && y.nodeType == astNodeValue {
+				return x
+			}
+			if !c.value.GetBool() && x.nodeType == astNodeValue {
+				return y
+			}
+		}
+	}
+	if !allValue {
+		return &folded
+	}
+
+	// all the built-in functions are pure, so the node can be calculated now
+	value, err := e.eval(&folded)
+	if err != nil || !hasLiteral(value) {
+		return &folded
+	}
+	return newTokenAstNode(value, n.pos, n.end)
+}
+
+// the value can be printed in the residual program, the string with a newline or ends with a single backslash can not
+func hasLiteral(t *TokenNode) bool {
+	if t.ValueType != ValueTypeString {
+		return true
+	}
+	_, ok := printStringLiteral(t.GetString())
+	return ok
+}
@@ -0,0 +1,172 @@
+package rule_engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// the priority of the nodes when print, the same as the grammar in rule_engine.y
+const (
+	printLevelOr = iota + 1
+	printLevelAnd
+	printLevelThirdOper
+	printLevelEqual
+	printLevelRelation
+	printLevelAdd
+	printLevelMul
+	printLevelUnary
+	printLevelFunc
+	printLevelPrimary
+)
+
+var binaryPrintLevel = map[int]int{
+	OR:  printLevelOr,
+	AND: printLevelAnd,
+	EQ:  printLevelEqual,
+	NE:  printLevelEqual,
+	'<': printLevelRelation,
+	'>': printLevelRelation,
+	LE:  printLevelRelation,
+	GE:  printLevelRelation,
+	'+': printLevelAdd,
+	'-': printLevelAdd,
+	'*': printLevelMul,
+	'/': printLevelMul,
+	'%': printLevelMul,
+}
+
+// astPrinter print the syntax tree to the expression, which can be compiled again.
+type astPrinter struct {
+	decimalMode bool // the float literal is parsed as decimal
+}
+
+func (p *astPrinter) print(n *astNode) string {
+	s, _ := p.printLevel(n)
+	return s
+}
+
+// print the node with the least level it needs, add parentheses if the level is lower than minLevel
+func (p *astPrinter) printChild(n *astNode, minLevel int) string {
+	s, level := p.printLevel(n)
+	if level < minLevel {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func (p *astPrinter) printLevel(n *astNode) (string, int) {
+	switch n.nodeType {
+	case astNodeValue:
+		return p.printValue(n.value)
+	case astNodeVar:
+		return "{{" + n.varName() + "}}", printLevelPrimary
+	case astNodeUnary:
+		// the operand of unary operator can only be primary expression
+		operand := p.printChild(n.children[0], printLevelPrimary)
+		if n.oper == NOT {
+			return "not " + operand, printLevelUnary
+		}
+		return "-" + operand, printLevelUnary
+	case astNodeBinary:
+		level := binaryPrintLevel[n.oper]
+		leftLevel, rightLevel := level, level+1
+		if level == printLevelRelation {
+			// relation expression is right recursive, like ADD_EXPR '<' RELATION_EXPR
+			leftLevel, rightLevel = level+1, level
+		}
+		return fmt.Sprintf("%v %v %v", p.printChild(n.children[0], leftLevel), operNameDict[n.oper],
+			p.printChild(n.children[1], rightLevel)), level
+	case astNodeThirdOper:
+		x := p.printChild(n.children[0], printLevelEqual)
+		c := p.printChild(n.children[1], printLevelThirdOper)
+		y := p.printChild(n.children[2], printLevelThirdOper)
+		return fmt.Sprintf("%v if %v else %v", x, c, y), printLevelThirdOper
+	case astNodeFunc:
+		args := make([]string, len(n.children))
+		for i, child := range n.children {
+			args[i] = p.print(child)
+		}
+		return fmt.Sprintf("%v(%v)", n.funcName(), strings.Join(args, ", ")), printLevelFunc
+	}
+	return "", printLevelPrimary
+}
+
+// print the value as a literal, return the level of the literal
+func (p *astPrinter) printValue(t *TokenNode) (string, int) {
+	s, level := "", printLevelPrimary
+	switch t.ValueType {
+	case ValueTypeInteger:
+		if t.GetInt() == math.MinInt64 {
+			// the literal 9223372036854775808 overflow int64
+			return fmt.Sprintf("-%v - 1", int64(math.MaxInt64)), printLevelAdd
+		}
+		s = strconv.FormatInt(t.GetInt(), 10)
+	case ValueTypeBigInt:
+		s = t.GetBigInt().String()
+	case ValueTypeBool:
+		s = strconv.FormatBool(t.GetBool())
+	case ValueTypeString:
+		s, _ = printStringLiteral(t.GetString())
+		if strings.HasPrefix(s, "concat(") {
+			return s, printLevelFunc
+		}
+		return s, printLevelPrimary
+	case ValueTypeFloat:
+		f := t.GetFloat()
+		if math.IsNaN(f) || math.IsInf(f, 0) || p.decimalMode {
+			return fmt.Sprintf(`float("%v")`, strconv.FormatFloat(f, 'g', -1, 64)), printLevelFunc
+		}
+		s = withPoint(strconv.FormatFloat(f, 'g', -1, 64))
+	case ValueTypeDecimal:
+		if !p.decimalMode {
+			return fmt.Sprintf(`decimal("%v")`, t.GetDecimal()), printLevelFunc
+		}
+		s = withPoint(t.GetDecimal().String())
+	}
+	if strings.HasPrefix(s, "-") {
+		level = printLevelUnary
+	}
+	return s, level
+}
+
+// the float without point and exponent will be parsed as integer
+func withPoint(s string) string {
+	if strings.ContainsAny(s, ".eE") {
+		return s
+	}
+	return s + ".0"
+}
+
+// the lexer does not unescape the string, the string with both quotes is printed as the concat of the literals,
+// like concat('say "', "it's", '"'). false if the string has a newline or ends with a single backslash,
+// which has no literal.
+func printStringLiteral(s string) (string, bool) {
+	var literals []string
+	start, quotes := 0, `"'` // the quotes can enclose s[start:i]
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			return `"` + s + `"`, false
+		case '\\':
+			if i+1 == len(s) || s[i+1] == '\n' {
+				return `"` + s + `"`, false
+			}
+			// the escape pair is kept as it is in both quotes
+			i++
+		case '"', '\'':
+			rest := strings.Replace(quotes, string(c), "", 1)
+			if rest == "" {
+				literals = append(literals, quotes+s[start:i]+quotes)
+				start, rest = i, strings.Replace(`"'`, string(c), "", 1)
+			}
+			quotes = rest
+		}
+	}
+	literals = append(literals, quotes[:1]+s[start:]+quotes[:1])
+	if len(literals) == 1 {
+		return literals[0], true
+	}
+	return "concat(" + strings.Join(literals, ", ") + ")", true
+}
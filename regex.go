@@ -0,0 +1,143 @@
+package rule_engine
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+const defaultRegexCacheSize = 128
+
+type regexConfig struct {
+	cacheSize        int // the max count of the compiled regex kept by a Praser
+	maxPatternLength int // 0 means no limit
+	maxInputLength   int // 0 means no limit
+}
+
+// the functions take the regex pattern as the first argument
+var regexFuncSet = map[string]bool{
+	"regexMatch":   true,
+	"regexFind":    true,
+	"regexFindAll": true,
+	"regexReplace": true,
+	"regexGroups":  true,
+}
+
+type regexCacheEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// regexCache keep the compiled regex, remove the least recently used one when it is full.
+// the Praser may be used in many goroutines, so it is protected by a mutex.
+type regexCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newRegexCache(size int) *regexCache {
+	return &regexCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *regexCache) get(pattern string) (*regexp.Regexp, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[pattern]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*regexCacheEntry).re, true
+	}
+	return nil, false
+}
+
+func (c *regexCache) add(pattern string, re *regexp.Regexp) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[pattern]; ok {
+		return
+	}
+	c.entries[pattern] = c.lru.PushFront(&regexCacheEntry{pattern: pattern, re: re})
+	for c.lru.Len() > c.size {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*regexCacheEntry).pattern)
+	}
+}
+
+func (c *regexCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// compile the pattern with the cache, and check the pattern length limit
+func (o *TokenOperator) compileRegex(pattern string, funcName string) (*regexp.Regexp, error) {
+	if limit := o.regex.maxPatternLength; limit > 0 && len(pattern) > limit {
+		return nil, GetError(ErrRuleEngineRegexLimit,
+			fmt.Sprintf("%v pattern length %v exceed the limit %v", funcName, len(pattern), limit))
+	}
+
+	if re, ok := o.regexCache.get(pattern); ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, GetError(ErrRuleEngineRegexMatch, fmt.Sprintf("%v failed, %v", funcName, err))
+	}
+	o.regexCache.add(pattern, re)
+	return re, nil
+}
+
+func (o *TokenOperator) checkRegexInput(s string, funcName string) error {
+	if limit := o.regex.maxInputLength; limit > 0 && len(s) > limit {
+		return GetError(ErrRuleEngineRegexLimit,
+			fmt.Sprintf("%v input length %v exceed the limit %v", funcName, len(s), limit))
+	}
+	return nil
+}
+
+// get the pattern and the input string of the regex functions
+func (o *TokenOperator) regexArgs(argList []*TokenNode, argNum int, funcName string) (*regexp.Regexp, string, error) {
+	if len(argList) != argNum {
+		return nil, "", getArgNumberError(argNum, len(argList))
+	}
+	if err := batchCheckOperType(argList[:2], operTypeRegex, funcName); err != nil {
+		return nil, "", err
+	}
+
+	s := argList[1].GetString()
+	if err := o.checkRegexInput(s, funcName); err != nil {
+		return nil, "", err
+	}
+	re, err := o.compileRegex(argList[0].GetString(), funcName)
+	if err != nil {
+		return nil, "", err
+	}
+	return re, s, nil
+}
+
+// check the literal pattern of the regex functions when compile the expression
+func (o *TokenOperator) checkRegexPattern(funcNode *astNode) error {
+	funcName := funcNode.funcName()
+	if !regexFuncSet[funcName] || len(funcNode.children) == 0 {
+		return nil
+	}
+	arg := funcNode.children[0]
+	if arg.nodeType != astNodeValue || arg.value.ValueType != ValueTypeString {
+		return nil
+	}
+	if _, err := o.compileRegex(arg.value.GetString(), funcName); err != nil {
+		engineErr := err.(*EngineErr)
+		return GetError(engineErr.ErrCode, fmt.Sprintf("%v, pos: %v", engineErr.ErrMsg, arg.pos))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+)
+
+// VariableResolver resolve the variable when it is used in the calculation,
+// so the variables don't need to be prepared before Parse.
+//
+// name is the var path in {{}}, like "order.amount".
+// the value returned will be parsed like the Value in Param.
+// return nil value means the variable not exist, will get ErrRuleEngineUnknownVarName.
+// return an EngineErr, the error will be returned by Parse directly,
+// other errors will be wrapped to ErrRuleEngineResolveVar.
+type VariableResolver interface {
+	Resolve(name string) (interface{}, error)
+}
+
+// ContextVariableResolver is a VariableResolver which can get the context passed to ParseContext or EvalContext.
+// if the resolver implements it, ResolveContext will be used instead of Resolve.
+type ContextVariableResolver interface {
+	VariableResolver
+	ResolveContext(ctx context.Context, name string) (interface{}, error)
+}
+
+// VariableResolverFunc is an adapter to use a function as VariableResolver.
+type VariableResolverFunc func(name string) (interface{}, error)
+
+func (f VariableResolverFunc) Resolve(name string) (interface{}, error) {
+	return f(name)
+}
+
+// resolve the variable by the resolver, each variable is resolved at most once in a Parse.
+func (o *TokenOperator) resolveVar(ctx context.Context, varName string, varCache map[string]*TokenNode) (*TokenNode, error) {
+	return o.resolveVarBy(ctx, o.resolver, varName, varCache)
+}
+
+func (o *TokenOperator) resolveVarBy(ctx context.Context, resolver VariableResolver, varName string,
+	varCache map[string]*TokenNode) (*TokenNode, error) {
+	if node, ok := varCache[varName]; ok {
+		return node, nil
+	}
+
+	var value interface{}
+	var err error
+	if ctxResolver, ok := resolver.(ContextVariableResolver); ok {
+		value, err = ctxResolver.ResolveContext(ctx, varName)
+	} else {
+		value, err = resolver.Resolve(varName)
+	}
+	if err != nil {
+		if engineErr, ok := err.(*EngineErr); ok {
+			return nil, engineErr
+		}
+		return nil, GetError(ErrRuleEngineResolveVar, fmt.Sprintf("resolve var %v failed, %v", varName, err))
+	}
+	if value == nil {
+		return nil, GetError(ErrRuleEngineUnknownVarName, fmt.Sprintf("unknown var name: %v", varName))
+	}
+
+	node, err := o.parseParam(&Param{Name: varName, Value: value})
+	if err != nil {
+		return nil, err
+	}
+	if varCache != nil {
+		varCache[varName] = node
+	}
+	return node, nil
+}
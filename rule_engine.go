@@ -10,8 +10,8 @@ import __yyfmt__ "fmt"
 
 //line rule_engine.y:9
 type ruleEngineSymType struct {
-	yys  int
-	node *TokenNode
+	yys int
+	ast *astNode
 }
 
 const INTEGER = 57346
@@ -78,8 +78,9 @@ const ruleEngineEofCode = 1
 const ruleEngineErrCode = 2
 const ruleEngineInitialStackSize = 16
 
-//line rule_engine.y:326
+//line rule_engine.y:245
 /*  start  of  programs  */
+
 //line yacctab:1
 var ruleEngineExca = [...]int8{
 	-1, 1,
@@ -89,36 +90,35 @@ var ruleEngineExca = [...]int8{
 
 const ruleEnginePrivate = 57344
 
-const ruleEngineLast = 109
+const ruleEngineLast = 92
 
 var ruleEngineAct = [...]int8{
-	3, 6, 11, 63, 17, 18, 20, 24, 42, 16,
-	19, 10, 67, 68, 66, 14, 37, 38, 39, 5,
-	21, 29, 30, 43, 64, 28, 25, 13, 26, 47,
-	48, 22, 61, 17, 18, 20, 24, 15, 16, 19,
-	57, 58, 59, 62, 14, 8, 46, 55, 56, 21,
-	65, 40, 41, 33, 34, 27, 13, 45, 1, 60,
-	22, 32, 31, 35, 36, 70, 2, 9, 71, 72,
-	69, 4, 7, 12, 44, 49, 50, 51, 52, 53,
-	54, 17, 18, 20, 24, 23, 0, 19, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 21, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 22,
+	3, 6, 11, 22, 8, 17, 18, 20, 25, 64,
+	16, 19, 68, 69, 43, 67, 14, 26, 10, 65,
+	27, 21, 28, 46, 44, 38, 39, 40, 13, 5,
+	48, 49, 23, 62, 1, 50, 51, 52, 53, 54,
+	55, 58, 59, 60, 63, 66, 22, 71, 17, 18,
+	20, 25, 70, 16, 19, 56, 57, 47, 22, 14,
+	17, 18, 20, 25, 21, 15, 19, 61, 2, 72,
+	73, 13, 9, 34, 35, 23, 21, 4, 7, 41,
+	42, 33, 32, 36, 37, 30, 31, 23, 12, 29,
+	45, 24,
 }
 
 var ruleEnginePact = [...]int16{
-	29, -1000, -1000, 5, 14, 42, -1000, 3, -1000, 37,
-	-12, -1000, -1000, 77, 77, -1000, -23, -1000, -1000, -1000,
-	-1000, -1000, 29, -1000, 48, -1000, 29, 29, 29, 29,
-	29, 29, 29, 29, 29, 29, 29, 29, 29, 29,
-	-1000, -1000, 0, -29, 16, -1000, 42, -1000, -9, -1000,
-	-1000, -1000, -1000, -1000, -1000, -12, -12, -1000, -1000, -1000,
-	-20, -1000, -1000, -1000, -1000, 61, 29, -1000, 29, -1000,
-	-1000, -1000, -1000,
+	44, -1000, -1000, -4, 6, 9, -1000, 67, -1000, 57,
+	-3, -1000, -1000, 56, 56, -1000, -17, -1000, -1000, -1000,
+	-1000, -1000, -1000, 44, -1000, 14, -1000, 44, 44, 44,
+	44, 44, 44, 44, 44, 44, 44, 44, 44, 44,
+	44, -1000, -1000, 1, -23, 11, -1000, 9, -1000, -8,
+	-1000, -1000, -1000, -1000, -1000, -1000, -3, -3, -1000, -1000,
+	-1000, -20, -1000, -1000, -1000, -1000, 43, 44, -1000, 44,
+	-1000, -1000, -1000, -1000,
 }
 
 var ruleEnginePgo = [...]int8{
-	0, 85, 74, 37, 2, 73, 45, 72, 71, 19,
-	0, 67, 11, 66, 59, 1, 58,
+	0, 91, 90, 65, 2, 88, 4, 78, 77, 29,
+	0, 72, 18, 68, 67, 1, 34,
 }
 
 var ruleEngineR1 = [...]int8{
@@ -126,37 +126,37 @@ var ruleEngineR1 = [...]int8{
 	7, 7, 7, 6, 6, 6, 6, 6, 11, 11,
 	11, 12, 12, 12, 12, 4, 4, 4, 5, 5,
 	5, 14, 14, 3, 3, 3, 3, 3, 3, 3,
-	1, 2, 2, 2,
+	3, 1, 2, 2, 2,
 }
 
 var ruleEngineR2 = [...]int8{
 	0, 1, 2, 1, 1, 3, 1, 3, 1, 5,
 	1, 3, 3, 1, 3, 3, 3, 3, 1, 3,
 	3, 1, 3, 3, 3, 1, 2, 2, 1, 4,
-	3, 1, 3, 1, 1, 1, 1, 1, 3, 1,
-	3, 1, 3, 3,
+	3, 1, 3, 1, 1, 1, 1, 1, 1, 3,
+	1, 3, 1, 3, 3,
 }
 
 var ruleEngineChk = [...]int16{
 	-1000, -16, -13, -10, -8, -9, -15, -7, -6, -11,
 	-12, -4, -5, 27, 15, -3, 9, 4, 5, 10,
-	6, 20, 31, -1, 7, 21, 14, 13, 22, 18,
-	19, 25, 24, 16, 17, 26, 27, 28, 29, 30,
-	-3, -3, 31, -10, -2, 9, -9, -15, -15, -6,
-	-6, -6, -6, -6, -6, -12, -12, -4, -4, -4,
-	-14, 32, -10, 32, 8, 34, 23, 32, 33, 9,
-	4, -15, -10,
+	6, 20, 2, 31, -1, 7, 21, 14, 13, 22,
+	18, 19, 25, 24, 16, 17, 26, 27, 28, 29,
+	30, -3, -3, 31, -10, -2, 9, -9, -15, -15,
+	-6, -6, -6, -6, -6, -6, -12, -12, -4, -4,
+	-4, -14, 32, -10, 32, 8, 34, 23, 32, 33,
+	9, 4, -15, -10,
 }
 
 var ruleEngineDef = [...]int8{
 	0, -2, 1, 0, 3, 4, 6, 8, 10, 13,
 	18, 21, 25, 0, 0, 28, 0, 33, 34, 35,
-	36, 37, 0, 39, 0, 2, 0, 0, 0, 0,
+	36, 37, 38, 0, 40, 0, 2, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	26, 27, 0, 0, 0, 41, 5, 7, 0, 11,
-	12, 14, 15, 16, 17, 19, 20, 22, 23, 24,
-	0, 30, 31, 38, 40, 0, 0, 29, 0, 42,
-	43, 9, 32,
+	0, 26, 27, 0, 0, 0, 42, 5, 7, 0,
+	11, 12, 14, 15, 16, 17, 19, 20, 22, 23,
+	24, 0, 30, 31, 39, 41, 0, 0, 29, 0,
+	43, 44, 9, 32,
 }
 
 var ruleEngineTok1 = [...]int8{
@@ -521,381 +521,303 @@ ruleEnginedefault:
 //line rule_engine.y:40
 		{
 			lex := ruleEnginelex.(*RuleEngineLex)
-			lex.resNode = ruleEngineDollar[1].node
+			lex.root = ruleEngineDollar[1].ast
 			return 0
 		}
 	case 2:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-2 : ruleEnginept+1]
 //line rule_engine.y:47
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 3:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
 //line rule_engine.y:52
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 4:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
 //line rule_engine.y:57
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 5:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
 //line rule_engine.y:60
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeOr(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode(OR, ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 6:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:70
+//line rule_engine.y:65
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 7:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:73
+//line rule_engine.y:68
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeAnd(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode(AND, ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 8:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:83
+//line rule_engine.y:73
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 9:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-5 : ruleEnginept+1]
-//line rule_engine.y:86
+//line rule_engine.y:76
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeThirdOper(ruleEngineDollar[1].node, ruleEngineDollar[3].node, ruleEngineDollar[5].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newThirdOperAstNode(ruleEngineDollar[1].ast, ruleEngineDollar[3].ast, ruleEngineDollar[5].ast)
 		}
 	case 10:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:96
+//line rule_engine.y:81
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 11:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:99
+//line rule_engine.y:84
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeEqual(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode(EQ, ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 12:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:107
+//line rule_engine.y:87
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeNotEqual(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode(NE, ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 13:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:117
+//line rule_engine.y:92
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 14:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:120
+//line rule_engine.y:95
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeLess(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('<', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 15:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:128
+//line rule_engine.y:98
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeGreater(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('>', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 16:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:136
+//line rule_engine.y:101
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeLessEqual(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			// __yyfmt__.Println($1, $3)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode(LE, ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 17:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:145
+//line rule_engine.y:104
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeGreaterEqual(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode(GE, ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 18:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:156
+//line rule_engine.y:110
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 19:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:159
+//line rule_engine.y:113
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeAdd(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('+', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 20:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:167
+//line rule_engine.y:116
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeSub(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('-', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 21:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:177
+//line rule_engine.y:121
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 22:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:180
+//line rule_engine.y:124
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeMul(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('*', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 23:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:188
+//line rule_engine.y:127
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeDiv(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('/', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 24:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:196
+//line rule_engine.y:130
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeMod(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newBinaryAstNode('%', ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
 		}
 	case 25:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:206
+//line rule_engine.y:135
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 26:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-2 : ruleEnginept+1]
-//line rule_engine.y:209
+//line rule_engine.y:138
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeMinus(ruleEngineDollar[2].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newUnaryAstNode('-', ruleEngineDollar[2].ast, ruleEngineDollar[1].ast.pos)
 		}
 	case 27:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-2 : ruleEnginept+1]
-//line rule_engine.y:217
+//line rule_engine.y:141
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeNot(ruleEngineDollar[2].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newUnaryAstNode(NOT, ruleEngineDollar[2].ast, ruleEngineDollar[1].ast.pos)
 		}
 	case 28:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:227
+//line rule_engine.y:146
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 29:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-4 : ruleEnginept+1]
-//line rule_engine.y:230
+//line rule_engine.y:149
 		{
 			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeFunc(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
+			node, err := newFuncAstNode(ruleEngineDollar[1].ast, ruleEngineDollar[3].ast, ruleEngineDollar[4].ast.end)
 			if err != nil {
-				return lex.setErr(err)
+				// go on to find the other errors
+				lex.setErrAt(err, ruleEngineDollar[1].ast.pos, ruleEngineDollar[1].ast.end)
+				node = lex.errorNode()
+			} else if err := lex.oper.checkRegexPattern(node); err != nil {
+				lex.setErrAt(err, node.children[0].pos, node.children[0].end)
 			}
-			// __yyfmt__.Println(*node)
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = node
 		}
 	case 30:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:239
+//line rule_engine.y:161
 		{
 			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeFunc(ruleEngineDollar[1].node, nil)
+			node, err := newFuncAstNode(ruleEngineDollar[1].ast, nil, ruleEngineDollar[3].ast.end)
 			if err != nil {
-				return lex.setErr(err)
+				lex.setErrAt(err, ruleEngineDollar[1].ast.pos, ruleEngineDollar[1].ast.end)
+				node = lex.errorNode()
 			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = node
 		}
 	case 31:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:249
+//line rule_engine.y:172
 		{
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeArg(ruleEngineDollar[1].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newArgsAstNode(nil, ruleEngineDollar[1].ast)
 		}
 	case 32:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:257
+//line rule_engine.y:175
 		{
 			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeArgList(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
-			if err != nil {
-				return lex.setErr(err)
+			ruleEngineVAL.ast = newArgsAstNode(ruleEngineDollar[1].ast, ruleEngineDollar[3].ast)
+			if err := lex.checkArgs(ruleEngineVAL.ast); err != nil {
+				return lex.setErrAt(err, ruleEngineVAL.ast.pos, ruleEngineVAL.ast.end)
 			}
-			ruleEngineVAL.node = node
 		}
 	case 33:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:268
+//line rule_engine.y:185
 		{
-			// __yyfmt__.Println($1)
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 34:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:272
+//line rule_engine.y:188
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 35:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:275
+//line rule_engine.y:191
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 36:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:278
+//line rule_engine.y:194
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 37:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:281
+//line rule_engine.y:197
 		{
-			ruleEnginelex.Error("syntax error")
-			return ruleEnginelex.(*RuleEngineLex).getErrCode()
+			// the lexer has reported the error
+			lex := ruleEnginelex.(*RuleEngineLex)
+			if lex.fatal {
+				return lex.getErrCode()
+			}
+			ruleEngineVAL.ast = lex.errorNode()
 		}
 	case 38:
-		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:285
+		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
+//line rule_engine.y:205
 		{
-			ruleEngineVAL.node = ruleEngineDollar[2].node
+			// recover from the syntax error, the parser go on to find the other errors
+			ruleEngineVAL.ast = ruleEnginelex.(*RuleEngineLex).errorNode()
 		}
 	case 39:
-		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:288
+		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
+//line rule_engine.y:209
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			// the span of the node include the parentheses
+			ruleEngineDollar[2].ast.pos, ruleEngineDollar[2].ast.end = ruleEngineDollar[1].ast.pos, ruleEngineDollar[3].ast.end
+			ruleEngineVAL.ast = ruleEngineDollar[2].ast
 		}
 	case 40:
-		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:293
+		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
+//line rule_engine.y:214
 		{
-			// __yyfmt__.Println($2)
-			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeVar(ruleEngineDollar[2].node)
-			if err != nil {
-				return lex.setErr(err)
-			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
 		}
 	case 41:
-		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
-//line rule_engine.y:304
+		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
+//line rule_engine.y:219
 		{
-			ruleEngineVAL.node = ruleEngineDollar[1].node
+			ruleEngineVAL.ast = newVarAstNode(ruleEngineDollar[2].ast, ruleEngineDollar[1].ast.pos, ruleEngineDollar[3].ast.end)
 		}
 	case 42:
+		ruleEngineDollar = ruleEngineS[ruleEnginept-1 : ruleEnginept+1]
+//line rule_engine.y:224
+		{
+			ruleEngineVAL.ast = ruleEngineDollar[1].ast
+		}
+	case 43:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:307
+//line rule_engine.y:227
 		{
 			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeVarName(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
+			node, err := lex.oper.tokenNodeVarName(ruleEngineDollar[1].ast.value, ruleEngineDollar[3].ast.value)
 			if err != nil {
-				return lex.setErr(err)
+				return lex.setErrAt(err, ruleEngineDollar[1].ast.pos, ruleEngineDollar[3].ast.end)
 			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newTokenAstNode(node, ruleEngineDollar[1].ast.pos, ruleEngineDollar[3].ast.end)
 		}
-	case 43:
+	case 44:
 		ruleEngineDollar = ruleEngineS[ruleEnginept-3 : ruleEnginept+1]
-//line rule_engine.y:315
+//line rule_engine.y:235
 		{
-			// __yyfmt__.Println($1, $3)
 			lex := ruleEnginelex.(*RuleEngineLex)
-			node, err := lex.oper.tokenNodeVarName(ruleEngineDollar[1].node, ruleEngineDollar[3].node)
+			node, err := lex.oper.tokenNodeVarName(ruleEngineDollar[1].ast.value, ruleEngineDollar[3].ast.value)
 			if err != nil {
-				return lex.setErr(err)
+				return lex.setErrAt(err, ruleEngineDollar[1].ast.pos, ruleEngineDollar[3].ast.end)
 			}
-			ruleEngineVAL.node = node
+			ruleEngineVAL.ast = newTokenAstNode(node, ruleEngineDollar[1].ast.pos, ruleEngineDollar[3].ast.end)
 		}
 	}
 	goto ruleEnginestack /* stack new state and value */
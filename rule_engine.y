@@ -7,27 +7,27 @@ package rule_engine
 // fields inside this union end up as the fields in a structure known
 // as ${PREFIX}SymType, of which a reference is passed to the lexer.
 %union{
-	node *TokenNode
+	ast *astNode
 }
 
-%type <node> VALUE_EXPR VAR_NAME
-%type <node> PRIMARY_EXPR UNARY_EXPR POST_EXPR
-%type <node> RELATION_EXPR EQUAL_EXPR
-%type <node> LOGIC_OR_EXPR LOGIC_AND_EXPR LOGIC_EXPR
-%type <node> ADD_EXPR MUL_EXPR
-%type <node> TRANSLATION_UNIT
-%type <node> ARGUMENT_EXPRSSION_LIST
-%type <node> THIRD_OPER_EXPR
+%type <ast> VALUE_EXPR VAR_NAME
+%type <ast> PRIMARY_EXPR UNARY_EXPR POST_EXPR
+%type <ast> RELATION_EXPR EQUAL_EXPR
+%type <ast> LOGIC_OR_EXPR LOGIC_AND_EXPR LOGIC_EXPR
+%type <ast> ADD_EXPR MUL_EXPR
+%type <ast> TRANSLATION_UNIT
+%type <ast> ARGUMENT_EXPRSSION_LIST
+%type <ast> THIRD_OPER_EXPR
 
 
 // same for terminals
-%token <node> INTEGER FLOAT STRING
-%token <node> IDLEFT IDRIGHT IDENTIFIER
-%token <node> BOOL TRUE FALSE
-%token <node> AND OR NOT
-%token <node> LE GE EQ NE
-%token <node> ERROR END
-%token <node> IF ELSE
+%token <ast> INTEGER FLOAT STRING
+%token <ast> IDLEFT IDRIGHT IDENTIFIER
+%token <ast> BOOL TRUE FALSE
+%token <ast> AND OR NOT
+%token <ast> LE GE EQ NE
+%token <ast> ERROR END
+%token <ast> IF ELSE
 
 %left AND OR
 %left '>' '<' LE GE EQ NE
@@ -39,7 +39,7 @@ package rule_engine
 top :
 	TRANSLATION_UNIT {
 		lex := ruleEnginelex.(*RuleEngineLex)
-		lex.resNode = $1
+		lex.root = $1
 		return 0
 	}
 
@@ -58,12 +58,7 @@ LOGIC_OR_EXPR :
 		$$ = $1
 	}
 	| LOGIC_OR_EXPR OR LOGIC_AND_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeOr($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode(OR, $1, $3)
 	}
 
 LOGIC_AND_EXPR :
@@ -71,12 +66,7 @@ LOGIC_AND_EXPR :
 		$$ = $1
 	}
 	| LOGIC_AND_EXPR AND THIRD_OPER_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeAnd($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode(AND, $1, $3)
 	}
 
 THIRD_OPER_EXPR :
@@ -84,12 +74,7 @@ THIRD_OPER_EXPR :
 		$$ = $1
 	}
 	| EQUAL_EXPR IF THIRD_OPER_EXPR ELSE THIRD_OPER_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeThirdOper($1, $3, $5)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newThirdOperAstNode($1, $3, $5)
 	}
 
 EQUAL_EXPR :
@@ -97,20 +82,10 @@ EQUAL_EXPR :
 		$$ = $1
 	}
 	| EQUAL_EXPR EQ RELATION_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeEqual($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode(EQ, $1, $3)
 	}
 	| EQUAL_EXPR NE RELATION_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeNotEqual($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode(NE, $1, $3)
 	}
 
 RELATION_EXPR :
@@ -118,37 +93,16 @@ RELATION_EXPR :
 		$$  =  $1
 	}
 	| ADD_EXPR '<' RELATION_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeLess($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('<', $1, $3)
 	}
 	| ADD_EXPR '>' RELATION_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeGreater($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('>', $1, $3)
 	}
 	| ADD_EXPR LE RELATION_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeLessEqual($1, $3)
-		// __yyfmt__.Println($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode(LE, $1, $3)
 	}
 	| ADD_EXPR GE RELATION_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeGreaterEqual($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode(GE, $1, $3)
 	}
 
 
@@ -157,20 +111,10 @@ ADD_EXPR :
 		$$  =  $1
 	}
 	| ADD_EXPR '+' MUL_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeAdd($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('+', $1, $3)
 	}
 	| ADD_EXPR '-' MUL_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeSub($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('-', $1, $3)
 	}
 
 MUL_EXPR :
@@ -178,28 +122,13 @@ MUL_EXPR :
 		$$ = $1
 	}
 	| MUL_EXPR '*' UNARY_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeMul($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('*', $1, $3)
 	}
 	| MUL_EXPR '/' UNARY_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeDiv($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('/', $1, $3)
 	}
 	| MUL_EXPR '%' UNARY_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeMod($1, $3)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newBinaryAstNode('%', $1, $3)
 	}
 
 UNARY_EXPR :
@@ -207,20 +136,10 @@ UNARY_EXPR :
 		$$ = $1
 	}
 	| '-' PRIMARY_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeMinus($2)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newUnaryAstNode('-', $2, $<ast>1.pos)
 	}
 	| NOT PRIMARY_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeNot($2)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newUnaryAstNode(NOT, $2, $1.pos)
 	}
 
 POST_EXPR :
@@ -229,44 +148,41 @@ POST_EXPR :
 	}
 	| IDENTIFIER '(' ARGUMENT_EXPRSSION_LIST ')' {
 		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeFunc($1, $3)
+		node, err := newFuncAstNode($1, $3, $<ast>4.end)
 		if err != nil {
-			return lex.setErr(err)
+			// go on to find the other errors
+			lex.setErrAt(err, $1.pos, $1.end)
+			node = lex.errorNode()
+		} else if err := lex.oper.checkRegexPattern(node); err != nil {
+			lex.setErrAt(err, node.children[0].pos, node.children[0].end)
 		}
-		// __yyfmt__.Println(*node)
 		$$ = node
 	}
 	| IDENTIFIER '(' ')' {
 		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeFunc($1, nil);
+		node, err := newFuncAstNode($1, nil, $<ast>3.end)
 		if err != nil {
-			return lex.setErr(err)
+			lex.setErrAt(err, $1.pos, $1.end)
+			node = lex.errorNode()
 		}
 		$$ = node
 	}
 
 ARGUMENT_EXPRSSION_LIST :
 	LOGIC_EXPR {
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeArg($1)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newArgsAstNode(nil, $1)
 	}
 	| ARGUMENT_EXPRSSION_LIST ',' LOGIC_EXPR {
 		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeArgList($1, $3);
-		if err != nil {
-			return lex.setErr(err)
+		$$ = newArgsAstNode($1, $3)
+		if err := lex.checkArgs($$); err != nil {
+			return lex.setErrAt(err, $$.pos, $$.end)
 		}
-		$$ = node
 	}
 
 
 PRIMARY_EXPR :
 	INTEGER {
-		// __yyfmt__.Println($1)
 		$$ = $1
 	}
 	| FLOAT {
@@ -279,11 +195,21 @@ PRIMARY_EXPR :
 		$$ = $1
 	}
 	| ERROR {
-		ruleEnginelex.Error("syntax error")
-		return ruleEnginelex.(*RuleEngineLex).getErrCode()
+		// the lexer has reported the error
+		lex := ruleEnginelex.(*RuleEngineLex)
+		if lex.fatal {
+			return lex.getErrCode()
+		}
+		$$ = lex.errorNode()
+	}
+	| error {
+		// recover from the syntax error, the parser go on to find the other errors
+		$$ = ruleEnginelex.(*RuleEngineLex).errorNode()
 	}
 	| '(' LOGIC_EXPR ')' {
-		$$  =  $2
+		// the span of the node include the parentheses
+		$2.pos, $2.end = $<ast>1.pos, $<ast>3.end
+		$$ = $2
 	}
 	| VALUE_EXPR {
 		$$ = $1
@@ -291,13 +217,7 @@ PRIMARY_EXPR :
 
 VALUE_EXPR :
 	IDLEFT VAR_NAME IDRIGHT {
-		// __yyfmt__.Println($2)
-		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeVar($2)
-		if err != nil {
-			return lex.setErr(err)
-		}
-		$$ = node
+		$$ = newVarAstNode($2, $1.pos, $3.end)
 	}
 
 VAR_NAME :
@@ -306,21 +226,20 @@ VAR_NAME :
 	}
 	| VAR_NAME '.' IDENTIFIER {
 		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeVarName($1, $3);
+		node, err := lex.oper.tokenNodeVarName($1.value, $3.value)
 		if err != nil {
-			return lex.setErr(err)
+			return lex.setErrAt(err, $1.pos, $3.end)
 		}
-		$$ = node
+		$$ = newTokenAstNode(node, $1.pos, $3.end)
 	}
 	| VAR_NAME '.' INTEGER {
-		// __yyfmt__.Println($1, $3)
 		lex := ruleEnginelex.(*RuleEngineLex)
-		node, err := lex.oper.tokenNodeVarName($1, $3)
+		node, err := lex.oper.tokenNodeVarName($1.value, $3.value)
 		if err != nil {
-			return lex.setErr(err)
+			return lex.setErrAt(err, $1.pos, $3.end)
 		}
-		$$ = node
+		$$ = newTokenAstNode(node, $1.pos, $3.end)
 	}
 
 
-%%      /*  start  of  programs  */
\ No newline at end of file
+%%      /*  start  of  programs  */
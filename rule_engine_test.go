@@ -1,8 +1,19 @@
 package rule_engine
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -18,8 +29,8 @@ type RuleEngineTest struct {
 	praser Praser
 }
 
-func GetNewRuleEngineTest(t testing.TB, params []*Param, useDecimal bool) (*RuleEngineTest, error) {
-	praser, err := GetNewPraser(params, useDecimal)
+func GetNewRuleEngineTest(t testing.TB, params []*Param, useDecimal bool, opts ...Option) (*RuleEngineTest, error) {
+	praser, err := GetNewPraser(params, useDecimal, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -199,275 +210,2309 @@ func TestRuleEngineDecimal(t *testing.T) {
 	rt.batchCheck(&checkList)
 }
 
-func TestRuleEngineRelation(t *testing.T) {
-	checkList := []CheckUnit{
-		{"100 * 3 == 300", true, 0},
-		{"5.6 - 1.6 == 4", true, 0},
-		{"5.6 - 1.6 > 4", false, 0},
-		{"5.6  + -1.6 > 4", false, 0},
-		{"5.6  - 10 > -4.4", false, 0},
-		{"5.6  - 10 < -4.4", false, 0},
-		{"5.6  - 10 == -4.4", true, 0},
-		{"5.6  - 10 >= -4.4", true, 0},
-		{"5.6  - 10 <= -4.4", true, 0},
-		{"0x10 == 16", true, 0},
-		{"0x10 == 020", true, 0},
-		{"true > false", 0, int(ErrRuleEngineNotSupportedOperator)},
-		{"1 + 1 == 2 == true", true, 0},
-		{" 3.0 / 3  == 6.6 / 6.6 ", true, 0},
-		{" 0x10 > 0x30 ", false, 0},
-		{" 1 != 2 ", true, 0},
+func TestRuleEngineDecimalRounding(t *testing.T) {
+	d := decimal.RequireFromString
+	checkMap := map[RoundingMode][]CheckUnit{
+		RoundHalfUp: {
+			{"1 / 8.0", d("0.13"), 0},
+			{"-1 / 8.0", d("-0.13"), 0},
+			{"2 / 3.0", d("0.67"), 0},
+		},
+		RoundHalfEven: {
+			{"1 / 8.0", d("0.12"), 0},
+			{"3 / 8.0", d("0.38"), 0},
+			{"-1 / 8.0", d("-0.12"), 0},
+			{"2 / 3.0", d("0.67"), 0},
+		},
+		RoundDown: {
+			{"2 / 3.0", d("0.66"), 0},
+			{"-2 / 3.0", d("-0.66"), 0},
+		},
+		RoundUp: {
+			{"1 / 3.0", d("0.34"), 0},
+			{"-1 / 3.0", d("-0.34"), 0},
+			{"1 / 4.0", d("0.25"), 0},
+		},
+		RoundCeiling: {
+			{"1 / 3.0", d("0.34"), 0},
+			{"-2 / 3.0", d("-0.66"), 0},
+		},
+		RoundFloor: {
+			{"2 / 3.0", d("0.66"), 0},
+			{"-1 / 3.0", d("-0.34"), 0},
+		},
 	}
 
-	rt, err := GetNewRuleEngineTest(t, nil, false)
+	for mode, checkList := range checkMap {
+		rt, err := GetNewRuleEngineTest(t, nil, true, WithDivisionPrecision(2), WithRoundingMode(mode))
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		rt.batchCheck(&checkList)
+	}
+
+	if decimal.DivisionPrecision != defaultDivisionPrecision {
+		t.Fatalf("global division precision changed: %v", decimal.DivisionPrecision)
+	}
+
+	checkList := []CheckUnit{
+		{"1.005 * 1", d("1.00"), 0},
+		{"1.015 * 1", d("1.02"), 0},
+		{"10 / 4.0", d("2.5"), 0},
+		{"1 + 1", int64(2), 0},
+	}
+	rt, err := GetNewRuleEngineTest(t, nil, true, WithResultScale(2), WithRoundingMode(RoundHalfEven))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 
-	rt, err = GetNewRuleEngineTest(t, nil, true)
+	if _, err := GetNewPraser(nil, true, WithRoundingMode(RoundingMode(100))); err == nil {
+		t.Fatalf("expect error for unknown rounding mode")
+	}
+}
+
+func TestRuleEngineIntegerOverflow(t *testing.T) {
+	bigValue, _ := new(big.Int).SetString("9223372036854775808", 10)
+	params := []*Param{
+		GetParam("max", int64(math.MaxInt64)),
+		GetParam("min", int64(math.MinInt64)),
+		GetParam("small_id", uint64(100)),
+		GetParamWithType("big", ValueTypeBigInt, "100000000000000000000"),
+		GetParam("id", uint64(math.MaxUint64)),
+	}
+
+	checkList := []CheckUnit{
+		{"9223372036854775807 + 1", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"{{max}} + 1", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"{{min}} - 1", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"{{max}} * 2", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"{{min}} / -1", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"-{{min}}", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"abs({{min}})", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"9223372036854775808", 0, int(ErrRuleEngineIntegerOverflow)},
+		{"{{max}} - 1 + 1", int64(math.MaxInt64), 0},
+		{"{{min}} % -1", int64(0), 0},
+		{"{{small_id}} + 1", int64(101), 0},
+		{"{{big}} / 100000000000", int64(1000000000), 0},
+		{"{{big}} > {{max}}", true, 0},
+	}
+	rt, err := GetNewRuleEngineTest(t, params[:4], false)
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
-}
 
-func TestRuleEngineLogic(t *testing.T) {
-	checkList := []CheckUnit{
-		{"1 > 2 or 1 < 2", true, 0},
-		{"1 > 2 || 1 < 2", true, 0},
-		{"1 > 2 and 1 < 2", false, 0},
-		{"1 > 2 && 1 < 2", false, 0},
-		{"1 and true", 0, int(ErrRuleEngineNotSupportedOperator)},
-		{"1 && true", 0, int(ErrRuleEngineNotSupportedOperator)},
-		{"true AND false", false, 0},
-		{"true OR false", true, 0},
-		{"1 < 2 and 2 < 3 and 4.0 != 4.0001", true, 0},
-		{"1 < 2 or 2.0 < 3 or 4.00001 > 4", true, 0},
-		{"1 < 2 or 3.0 > 2.5 and 2.5 != 2.4", true, 0},
-		{"not(1 > 2) and not (2.5 < 2.0)", true, 0},
-		{"not true == false", true, 0},
-		{"not true == true", false, 0},
-		{"not (not true) == false", false, 0},
+	if _, err := GetNewPraser(params, false); err == nil ||
+		err.(*EngineErr).ErrCode != ErrRuleEngineIntegerOverflow {
+		t.Fatalf("expect overflow error for uint64 param, err: %v", err)
 	}
 
-	rt, err := GetNewRuleEngineTest(t, nil, false)
+	checkList = []CheckUnit{
+		{"{{max}} + 1", bigValue, 0},
+		{"9223372036854775807 + 1", bigValue, 0},
+		{"-{{min}}", bigValue, 0},
+		{"abs({{min}})", bigValue, 0},
+		{"{{max}} + 1 - 1", int64(math.MaxInt64), 0},
+		{"{{id}}", new(big.Int).SetUint64(math.MaxUint64), 0},
+		{"{{id}} % 10", int64(5), 0},
+		{"{{id}} > {{max}} and {{id}} > 1.5", true, 0},
+		{"max({{id}}, {{max}}, 1) == {{id}}", true, 0},
+		{"min({{id}}, {{max}}) == {{max}}", true, 0},
+		{"string({{id}})", "18446744073709551615", 0},
+		{"int(\"18446744073709551615\") == {{id}}", true, 0},
+		{"9223372036854775808 * 2 + 1.5", decimal.RequireFromString("18446744073709551617.5"), 0},
+	}
+	rt, err = GetNewRuleEngineTest(t, params, false, WithIntegerOverflow(IntegerOverflowBigInt))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 
-	rt, err = GetNewRuleEngineTest(t, nil, true)
+	checkList = []CheckUnit{
+		{"{{max}} + 1", decimal.NewFromBigInt(bigValue, 0), 0},
+		{"{{id}}", decimal.RequireFromString("18446744073709551615"), 0},
+		{"({{max}} + 1) / 2 == 4611686018427387904", true, 0},
+	}
+	rt, err = GetNewRuleEngineTest(t, params, false, WithIntegerOverflow(IntegerOverflowDecimal))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 }
 
-func TestRuleEngineFunc(t *testing.T) {
-	checkList := []CheckUnit{
-		{`len("test")`, int64(4), 0},
-		{`len("test") == 4`, true, 0},
-		{`len("test") == len('test')`, true, 0},
-		{`len("test") - len('test2') == -1`, true, 0},
-		{`max(1,2,5.5,4,8)`, 8.0, 0},
-		{`min(1,2,5.5,4,8)`, 1.0, 0},
-		{`max(min(10.0, 20, 30), len("test"))`, 10, 0},
-		{`min(min(10.0, 20, 30), len("test"))`, 4, 0},
-		{`min(1,2,5.5,4,8) == 1`, true, 0},
-		{`abs(-1)`, int64(1), 0},
-		{`abs(-5.5)`, 5.5, 0},
-		{`abs(3.0 - 100)`, 97, 0},
-		{`max(len("test"), abs(-3), min(5,6))`, int64(5), 0},
-		{`min(len("test"), abs(-4.5), min(5,6))`, 4, 0},
-
-		{`upper("abc") == "ABC"`, true, 0},
-		{`lower("CN")`, "cn", 0},
-		{`len("HelloWorld") <= 5`, false, 0},
-		{`len("HelloWorld")`, int64(10), 0},
-
-		{`test()`, 0, int(ErrRuleEngineUnkonwnFunc)},
-		{`min()`, 0, int(ErrRuleEngineFuncArgument)},
-		{`max(1)`, 0, int(ErrRuleEngineFuncArgument)},
-
-		{`startWith("hello world", "hell")`, true, 0},
-		{`startWith("hello world", "ell")`, false, 0},
-
-		{`endWith("hello world", "world")`, true, 0},
-		{`startWith("hello world", "worl")`, false, 0},
-
-		{`int(3.33) == 3`, true, 0},
-		{`int("1878") - 8 == 187 * 10`, true, 0},
-		{`int(97) + 3 == max(100, -1)`, true, 0},
-		{`int(decimal(3.33)) -3 == 0`, true, 0},
+func TestRuleEngineFloatEqual(t *testing.T) {
+	checkMap := map[string]struct {
+		opts      []Option
+		checkList []CheckUnit
+	}{
+		"default": {nil, []CheckUnit{
+			{"0.1 + 0.2 == 0.3", true, 0},
+			{"1e20 + 100000 == 1e20", false, 0},
+			{"1e-20 == 2e-20", true, 0},
+		}},
+		"exact": {[]Option{WithFloatEqualExact()}, []CheckUnit{
+			{"0.1 + 0.2 == 0.3", false, 0},
+			{"0.5 + 0.25 == 0.75", true, 0},
+		}},
+		"relative": {[]Option{WithFloatEqualRelative(1e-9)}, []CheckUnit{
+			{"0.1 + 0.2 == 0.3", true, 0},
+			{"1e20 + 100000 == 1e20", true, 0},
+			{"1e-20 == 2e-20", false, 0},
+		}},
+		"ulp": {[]Option{WithFloatEqualULP(1)}, []CheckUnit{
+			{"0.1 + 0.2 == 0.3", true, 0},
+			{"0.1 + 0.2 + 0.0000000000000002 == 0.3", false, 0},
+			{"-0.0 == 0.0", true, 0},
+		}},
+	}
 
-		{`float(300)`, 300.0, 0},
-		{`float("3.33") * 100 == int(1000 / 3.0)`, true, 0},
-		{`float(decimal(-3.33)) * 100 == -(1000 / 3)`, true, 0},
+	for name, item := range checkMap {
+		rt, err := GetNewRuleEngineTest(t, nil, false, item.opts...)
+		if err != nil {
+			t.Fatalf("%v: %v\n", name, err)
+		}
+		rt.batchCheck(&item.checkList)
+	}
 
-		{`decimal(200) / 3`, decimal.NewFromFloat(200).Div(decimal.NewFromInt(3)), 0},
-		{`decimal("3.33")`, 3.33, 0},
-		{`decimal(3.33) * 10 - 0.3 == int(100.0 / 3)`, true, 0},
-		{`int(decimal(3.33) * 10) == int(100.0 / 3)`, true, 0},
+	if _, err := GetNewPraser(nil, false, WithFloatEqualAbsolute(-1)); err == nil {
+		t.Fatalf("expect error for negative epsilon")
+	}
+}
 
-		{`string(decimal("3.33"))`, "3.33", 0},
-		{`string(100.234) == "100.234"`, true, 0},
-		{`decimal(string(3.33))`, 3.33, 0},
-		{`len(string(int(100.0 / 3)))`, 2, 0},
+func TestRuleEngineFloatSpecialValue(t *testing.T) {
+	checkList := []CheckUnit{
+		{`float("nan") == float("nan")`, false, 0},
+		{`float("nan") != float("nan")`, true, 0},
+		{`float("nan") > 1 or float("nan") <= 1`, false, 0},
+		{`isNaN(float("nan"))`, true, 0},
+		{`isNaN(float("inf") - float("inf"))`, true, 0},
+		{`isNaN(1.5) or isNaN(1) or isNaN(decimal(1))`, false, 0},
+		{`isInf(float("inf")) and isInf(float("-inf"))`, true, 0},
+		{`isInf(1.5)`, false, 0},
+		{`float("inf") == float("inf")`, true, 0},
+		{`float("inf") == float("-inf")`, false, 0},
+		{`float("-inf") < -1e308`, true, 0},
+		{`float("inf") > 1.5`, true, 0},
+		{`isInf(float("inf") + decimal(1))`, true, 0},
+		{`string(float("nan"))`, "NaN", 0},
+		{`isNaN("nan")`, 0, int(ErrRuleEngineNotSupportedOperator)},
+		{`int(float("nan"))`, 0, int(ErrRuleEngineFuncArgument)},
+		{`decimal(float("inf"))`, 0, int(ErrRuleEngineFuncArgument)},
+		{`float("inf") / 0`, 0, int(ErrRuleEngineDivideByZero)},
 	}
 
-	rt, err := GetNewRuleEngineTest(t, nil, false)
+	rt, err := GetNewRuleEngineTest(t, nil, true)
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 
-	rt, err = GetNewRuleEngineTest(t, nil, true)
+	// float overflow produce Inf only when not use decimal
+	checkList = append(checkList, CheckUnit{`isInf(1e308 * 10) and isInf(-1e308 / 1e-10)`, true, 0})
+	rt, err = GetNewRuleEngineTest(t, nil, false)
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
+
+	// the NaN and Inf params are kept as float when use decimal, decimal can not keep them
+	params := []*Param{GetParam("nan", math.NaN()), GetParam("inf", math.Inf(1))}
+	rt, err = GetNewRuleEngineTest(t, params, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&[]CheckUnit{
+		{`isNaN({{nan}}) and isInf({{inf}})`, true, 0},
+		{`{{inf}} > 1e308 and not ({{nan}} > 1)`, true, 0},
+		{`isNaN(-{{nan}}) and isInf(-{{inf}}) and -{{inf}} < 0`, true, 0},
+		{`isNaN(-(float("nan"))) and -(float("inf")) < -1e308`, true, 0},
+	})
+	if _, err := GetNewPraser([]*Param{GetParamWithType("inf", ValueTypeDecimal, math.Inf(1))}, true); err == nil ||
+		err.(*EngineErr).ErrCode != ErrRuleEngineDecimalError {
+		t.Fatalf("unexpected err: %v", err)
+	}
 }
 
-func TestRuleEngineVar(t *testing.T) {
-	params := []*Param{
-		GetParamWithType("value", ValueTypeInteger, 100),
-		GetParam("int_value2", 33),
-		GetParamWithType("float_value", ValueTypeFloat, 5.5),
-		GetParam("float_value2", 3.33),
-		GetParamWithType("str_value", ValueTypeString, "test"),
-		GetParam("true_value", true),
-		GetParam("false_value", false),
-		GetParam("a.b.c", 10),
-		GetParamWithType("a.1", ValueTypeInteger, int64(1)),
-		GetParamWithType("d1", ValueTypeDecimal, decimal.NewFromInt(100)),
-		GetParam("d2", decimal.NewFromFloat(3.33)),
-		GetParamWithType("d3", ValueTypeDecimal, "3.33"),
+func TestTokenNodeAs(t *testing.T) {
+	intValue, err := GetTokenNode(ValueTypeString, "0x10").AsInt()
+	if err != nil || intValue != 16 {
+		t.Fatalf("AsInt failed, value: %v, err: %v", intValue, err)
+	}
+	if intValue, err = GetTokenNode(ValueTypeFloat, -3.9).AsInt(); err != nil || intValue != -3 {
+		t.Fatalf("AsInt failed, value: %v, err: %v", intValue, err)
+	}
+	floatValue, err := GetTokenNode(ValueTypeDecimal, decimal.RequireFromString("1.25")).AsFloat()
+	if err != nil || floatValue != 1.25 {
+		t.Fatalf("AsFloat failed, value: %v, err: %v", floatValue, err)
+	}
+	strValue, err := GetTokenNode(ValueTypeInteger, int64(100)).AsString()
+	if err != nil || strValue != "100" {
+		t.Fatalf("AsString failed, value: %v, err: %v", strValue, err)
+	}
+	bigValue, err := GetTokenNode(ValueTypeString, "18446744073709551616").AsBigInt()
+	if err != nil || bigValue.String() != "18446744073709551616" {
+		t.Fatalf("AsBigInt failed, value: %v, err: %v", bigValue, err)
 	}
 
-	checkList := []CheckUnit{
-		{`len({{str_value}})`, int64(4), 0},
-		{`len({{str_value}}) - {{value}}`, int64(-96), 0},
-		{`len({{str_value}}) - {{value}} == -96 and {{true_value}} == true`, true, 0},
-		{`max(len({{str_value}}), {{value}}, {{float_value}})`, 100, 0},
-		{`min(len({{str_value}}), {{value}}, {{float_value}})`, 4, 0},
-		{`{{true_value}} or {{false_value}}`, true, 0},
-		{`{{true_value}} and {{false_value}}`, false, 0},
-		{`{{a.b.c}}`, int64(10), 0},
-		{`{{.b.c}}`, 0, int(ErrRuleEngineSyntaxError)},
-		{`{{a.b.}}`, 0, int(ErrRuleEngineSyntaxError)},
-		{`{{unknown_name}}`, 0, int(ErrRuleEngineUnknownVarName)},
-		{`{{a.1}}`, int64(1), 0},
-		{`{{d1}} * {{d2}}`, 333, 0},
-		{`{{d1}} * {{d2}} - 3.0 == {{int_value2}} * {{value}} / 10`, true, 0},
-		{`{{d1}} * {{d2}} - 3 == {{float_value2}} * {{d1}} - ({{int_value2}} - 3)/ 10.0`, true, 0},
-		{`{{d3}} == {{d2}}`, true, 0},
+	errList := []struct {
+		f       func() error
+		errcode int
+	}{
+		{func() error { _, err := GetTokenNode(ValueTypeBool, true).AsInt(); return err }, ErrRuleEngineNotSupportedOperator},
+		{func() error { _, err := GetTokenNode(ValueTypeString, "abc").AsFloat(); return err }, ErrRuleEngineFuncArgument},
+		{func() error { _, err := GetTokenNode(ValueTypeBool, false).AsString(); return err }, ErrRuleEngineNotSupportedOperator},
+		{func() error { _, err := GetTokenNode(ValueTypeInteger, int64(1)).AsBool(); return err }, ErrRuleEngineInvalidVarType},
+		{func() error { _, err := GetTokenNode(ValueTypeString, "18446744073709551616").AsInt(); return err }, ErrRuleEngineIntegerOverflow},
+		{func() error { var node *TokenNode; _, err := node.AsDecimal(); return err }, ErrRuleEngineInvalidVarType},
+	}
+	for i, item := range errList {
+		err := item.f()
+		if err == nil || err.(*EngineErr).ErrCode != item.errcode {
+			t.Fatalf("case %v expect err code %v, but err: %v", i, item.errcode, err)
+		}
 	}
 
-	rt, err := GetNewRuleEngineTest(t, params, false)
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "to float") {
+				t.Fatalf("expect panic to float, but: %v", r)
+			}
+		}()
+		GetTokenNode(ValueTypeString, "1.5").GetFloat()
+	}()
+}
+
+func TestEvalAs(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("x", 10)}, true)
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
-	rt.batchCheck(&checkList)
 
-	rt, err = GetNewRuleEngineTest(t, params, true)
-	if err != nil {
-		t.Fatalf("%v\n", err)
+	if res, err := EvalAs[int64](praser, "{{x}} * 2"); err != nil || res != 20 {
+		t.Fatalf("EvalAs int64 failed, res: %v, err: %v", res, err)
+	}
+	if res, err := EvalAs[decimal.Decimal](praser, "{{x}} / 4.0"); err != nil || !res.Equal(decimal.NewFromFloat(2.5)) {
+		t.Fatalf("EvalAs decimal failed, res: %v, err: %v", res, err)
+	}
+	if res, err := EvalAs[string](praser, "{{x}} + 1.5"); err != nil || res != "11.5" {
+		t.Fatalf("EvalAs string failed, res: %v, err: %v", res, err)
+	}
+	if res, err := EvalAs[bool](praser, "{{x}} > 5"); err != nil || !res {
+		t.Fatalf("EvalAs bool failed, res: %v, err: %v", res, err)
+	}
+	if _, err := EvalAs[bool](praser, "{{x}}"); err == nil {
+		t.Fatalf("EvalAs bool expect error")
+	}
+	if _, err := EvalAs[float64](praser, "{{y}}"); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineUnknownVarName {
+		t.Fatalf("EvalAs float expect unknown var error, err: %v", err)
 	}
-	rt.batchCheck(&checkList)
 }
 
-func TestRuleEngineRegexMatch(t *testing.T) {
+type testAddress struct {
+	City    string
+	ZipCode string `rule:"zip"`
+}
+
+type testUser struct {
+	Age int
+}
+
+type testOrder struct {
+	testUser
+	ID       uint64 `rule:"id"`
+	Amount   decimal.Decimal
+	Price    float64
+	Paid     bool
+	Address  *testAddress
+	Billing  *testAddress
+	Items    []testAddress
+	Tags     map[string]string
+	Discount *int
+	Secret   string `rule:"-"`
+	internal int
+}
+
+func TestRuleEngineStructEnv(t *testing.T) {
+	discount := 10
+	order := &testOrder{
+		testUser: testUser{Age: 20},
+		ID:       123,
+		Amount:   decimal.RequireFromString("99.9"),
+		Price:    1.5,
+		Paid:     true,
+		Address:  &testAddress{City: "SG", ZipCode: "018956"},
+		Items:    []testAddress{{City: "CN"}},
+		Tags:     map[string]string{"level": "vip"},
+		Discount: &discount,
+		Secret:   "secret",
+		internal: 1,
+	}
+
 	checkList := []CheckUnit{
-		{`regexMatch("^test$", "test")`, true, 0},
-		{`regexMatch("^test$", "test0")`, false, 0},
-		{`regexMatch("^0[xX][a-fA-F0-9]+", "0xasf4")`, true, 0},
-		{`regexMatch("foo.*", "seafood")`, true, 0},
-		{`regexMatch("(https?|ftp|file)://[-A-Za-z0-9+&@#/%?=~_|!:,.;]+[-A-Za-z0-9+&@#/%=~_|]",
-				"https://www.baidu.com")`, true, 0},
-		{`regexMatch("^\s*(DEV|TEST|UAT|STAGING|STABLE|LIVE)\s*$", " TEST ")`, true, 0},
-		{`regexMatch("^\s*http(s)?://.*shopee\.com", " https://test.shopee.com")`, true, 0},
+		{`{{id}} + 1`, int64(124), 0},
+		{`{{Age}} >= 18`, true, 0},
+		{`{{Amount}} * 10`, 999, 0},
+		{`{{Price}} * 2`, 3, 0},
+		{`{{Paid}}`, true, 0},
+		{`{{Address.City}} == "SG" and len({{Address.zip}}) == 6`, true, 0},
+		{`{{Items.0.City}}`, "CN", 0},
+		{`{{Tags.level}}`, "vip", 0},
+		{`{{Discount}}`, int64(10), 0},
+		{`{{Billing.City}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{Address.ZipCode}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{Items.1.City}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{Tags.unknown}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{Secret}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{internal}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{Address}}`, 0, int(ErrRuleEngineNotSupportedVarType)},
 	}
 
-	rt, err := GetNewRuleEngineTest(t, nil, false)
+	for _, useDecimal := range []bool{false, true} {
+		praser, err := GetNewPraserFromStruct(order, useDecimal)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		rt := &RuleEngineTest{t: t, praser: *praser}
+		rt.batchCheck(&checkList)
+	}
+
+	if _, err := GetNewPraserFromStruct(*order, false); err != nil {
+		t.Fatalf("struct value env failed: %v", err)
+	}
+	for _, env := range []interface{}{nil, 1, (*testOrder)(nil)} {
+		if _, err := GetNewPraserFromStruct(env, false); err == nil {
+			t.Fatalf("expect error for env: %v", env)
+		}
+	}
+}
+
+func TestRuleEngineResolver(t *testing.T) {
+	resolveCount := map[string]int{}
+	resolver := VariableResolverFunc(func(name string) (interface{}, error) {
+		resolveCount[name]++
+		switch name {
+		case "a":
+			return 10, nil
+		case "user.level":
+			return "vip", nil
+		case "fail":
+			return nil, fmt.Errorf("backend unavailable")
+		case "invalid":
+			return nil, GetError(ErrRuleEngineInvalidParam, "invalid")
+		}
+		return nil, nil
+	})
+
+	checkList := []CheckUnit{
+		{`{{a}} + {{a}} * {{a}}`, int64(110), 0},
+		{`{{user.level}} == "vip"`, true, 0},
+		{`{{b}} + {{a}}`, int64(110), 0},
+		{`{{unknown}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{fail}}`, 0, int(ErrRuleEngineResolveVar)},
+		{`{{invalid}}`, 0, int(ErrRuleEngineInvalidParam)},
+	}
+	rt, err := GetNewRuleEngineTest(t, []*Param{GetParam("b", 100)}, false, WithVariableResolver(resolver))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 
-	rt, err = GetNewRuleEngineTest(t, nil, true)
+	// resolved once in each Parse
+	if resolveCount["a"] != 2 || resolveCount["user.level"] != 1 || resolveCount["b"] != 0 {
+		t.Fatalf("unexpected resolve count: %v", resolveCount)
+	}
+}
+
+func TestRuleEngineContext(t *testing.T) {
+	rt, err := GetNewRuleEngineTest(t, []*Param{GetParam("a", 1)}, false)
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
-	rt.batchCheck(&checkList)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rt.praser.ParseContext(ctx, `{{a}} + 1`); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineCanceled {
+		t.Fatalf("expect canceled error, get: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := rt.praser.ParseContext(ctx, `1 + 1`); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineCanceled {
+		t.Fatalf("expect deadline error, get: %v", err)
+	}
+
+	// only literal, nothing to do
+	res, err := rt.praser.ParseContext(ctx, `1`)
+	if err != nil || res.GetInt() != 1 {
+		t.Fatalf("unexpected result: %v, %v", res, err)
+	}
+
+	resolver := &testContextResolver{}
+	rt, err = GetNewRuleEngineTest(t, nil, false, WithVariableResolver(resolver))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	ctx = context.WithValue(context.Background(), testContextKey{}, 42)
+	res, err = rt.praser.ParseContext(ctx, `{{a}} + 1`)
+	if err != nil || res.GetInt() != 43 {
+		t.Fatalf("unexpected result: %v, %v", res, err)
+	}
 }
 
-func TestRuleEngineIfElse(t *testing.T) {
+type testContextKey struct{}
+
+type testContextResolver struct{}
+
+func (r *testContextResolver) Resolve(name string) (interface{}, error) {
+	return nil, nil
+}
+
+func (r *testContextResolver) ResolveContext(ctx context.Context, name string) (interface{}, error) {
+	return ctx.Value(testContextKey{}), nil
+}
+
+func TestRuleEngineBudget(t *testing.T) {
 	params := []*Param{
-		GetParamWithType("x", ValueTypeInteger, int64(100)),
-		GetParamWithType("y", ValueTypeFloat, 50),
-		GetParamWithType("COUNTRY", ValueTypeString, "CN"),
-		GetParamWithType("field1", ValueTypeInteger, 2),
-		GetParamWithType("field2", ValueTypeFloat, 7.2),
-		GetParamWithType("field3", ValueTypeString, "Hel"),
+		GetParam("a", 1),
+		GetParam("s", "hello"),
 	}
 
 	checkList := []CheckUnit{
-		{`1 if true else 2`, int64(1), 0},
-		{`1 if false else 2`, int64(2), 0},
-		{`{{x}} + 10 >= 105.3 if {{y}} == 50 else false`, true, 0},
-		{`{{COUNTRY}} != "CN" or {{x}} >= 100`, true, 0},
-		{`{{x}} >= 100 if {{COUNTRY}} == "CN" else true`, true, 0},
-		{`{{y}} >= 100 if {{COUNTRY}} == "CN" else true`, false, 0},
-		{`1 if 1 else 2`, 0, int(ErrRuleEngineInvalidOperation)},
-		{"(({{field1}} > 0) and ({{field2}} > 7.8)) if len({{field3}}) >= 5 else {{field1}} + {{field2}} > 2.6", true, 0},
+		{`{{a}} + 1`, int64(2), 0},
+		{`{{a}} + 1 + 2 + 3`, int64(7), 0},
+		{`{{a}} + 1 + 2 + 3 + 4`, 0, int(ErrRuleEngineBudgetExceeded)},
+		{`-{{a}} * 3`, int64(-3), 0},
 	}
-
-	rt, err := GetNewRuleEngineTest(t, params, false)
+	rt, err := GetNewRuleEngineTest(t, params, false, WithMaxSteps(4))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 
-	rt, err = GetNewRuleEngineTest(t, params, false)
+	checkList = []CheckUnit{
+		{`len(upper({{s}}))`, int64(5), 0},
+		{`len(upper(lower({{s}})))`, 0, int(ErrRuleEngineBudgetExceeded)},
+	}
+	rt, err = GetNewRuleEngineTest(t, params, false, WithMaxFuncCalls(2))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
-}
 
-func TestRuleEngineExapmle(t *testing.T) {
-	checkList := []CheckUnit{
-		{`3 * (5 - 2) + 1`, int64(10), 0},
+	checkList = []CheckUnit{
+		{`upper({{s}})`, "HELLO", 0},
+		{`upper({{s}}) == lower({{s}})`, 0, int(ErrRuleEngineBudgetExceeded)},
+		{`lower({{s}}) == {{s}}`, true, 0},
 	}
-
-	rt, err := GetNewRuleEngineTest(t, nil, false)
+	rt, err = GetNewRuleEngineTest(t, params, false, WithMaxStringBytes(8))
 	if err != nil {
 		t.Fatalf("%v\n", err)
 	}
 	rt.batchCheck(&checkList)
 }
 
-func BenchmarkRule(b *testing.B) {
-	checkCase := CheckUnit{
-		"(({{field1}} > 0) and ({{field2}} > 7.8)) if len({{field3}}) >= 5 else {{field1}} + {{field2}} > 2.6",
-		true, 0,
+func TestRuleEngineCompile(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("a", 1)}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
 	}
 
-	params := []*Param{
-		GetParamWithType("x", ValueTypeInteger, int64(100)),
-		GetParamWithType("y", ValueTypeInteger, 50),
+	prog, err := praser.Compile(`{{a}} * 2 + 1`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	for i := 0; i < 3; i++ {
+		res, err := prog.Eval()
+		if err != nil || res.GetInt() != 3 {
+			t.Fatalf("unexpected result: %v, %v", res, err)
+		}
+	}
+
+	if _, err := praser.Compile(`unknownFunc(1)`); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineUnkonwnFunc {
+		t.Fatalf("expect unknown func error, get: %v", err)
+	}
+	if _, err := praser.Compile(`1 +`); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineSyntaxError {
+		t.Fatalf("expect syntax error, get: %v", err)
+	}
+}
+
+func TestRuleEngineInputLimit(t *testing.T) {
+	checkList := []CheckUnit{
+		{`((1 + 2)) * 3`, int64(9), 0},
+		{strings.Repeat("(", 5) + "1" + strings.Repeat(")", 5), 0, int(ErrRuleEngineTooDeep)},
+		{`1 + 2 + 3 + 4`, 0, int(ErrRuleEngineTooDeep)},
+		{`max(1, 2, 3)`, int64(3), 0},
+		{`max(1, 2, 3, 4)`, 0, int(ErrRuleEngineTooManyArgs)},
+		{`"abcd" == "abcd"`, true, 0},
+		{`"abcde"`, 0, int(ErrRuleEngineStringTooLong)},
+		{strings.Repeat(" ", 30) + "1", 0, int(ErrRuleEngineSourceTooLong)},
+	}
+	rt, err := GetNewRuleEngineTest(t, nil, false,
+		WithMaxSourceLength(30), WithMaxDepth(3), WithMaxArgs(3), WithMaxStringLength(4))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+// the inputs found by fuzzing and some hostile inputs, the praser should return error instead of panic
+func TestRuleEngineHostileInput(t *testing.T) {
+	inputList := []string{
+		``,
+		` `,
+		`(`,
+		`)`,
+		`{{`,
+		`}}`,
+		`{{}}`,
+		`{{.}}`,
+		`{{x.}}`,
+		`{{x..y}}`,
+		`"`,
+		`"""`,
+		`len(`,
+		`len(,)`,
+		`len(1,)`,
+		`1 if`,
+		`1 if 2 else`,
+		`not`,
+		`- -1`,
+		`1 / 0`,
+		`1 % 0`,
+		`1.0 / 0`,
+		`9223372036854775807 + 1`,
+		`-9223372036854775808 / -1`,
+		`99999999999999999999999999999999`,
+		`1e999999`,
+		`0x`,
+		`int("9999999999999999999999")`,
+		`regexMatch("(", "x")`,
+		strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000),
+		strings.Repeat("-(", 1000) + "1" + strings.Repeat(")", 1000),
+		strings.Repeat("1 + ", 10000) + "1",
+		strings.Repeat("1 if true else ", 1000) + "1",
+		"len(" + strings.Repeat("1, ", 1000) + "1)",
+		"\x00\xff",
+	}
+
+	praser, err := GetNewPraser([]*Param{GetParam("x", 1)}, false, WithMaxDepth(100))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	for _, input := range inputList {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panic with input: %q, %v", input, r)
+				}
+			}()
+			praser.Parse(input)
+		}()
+	}
+}
+
+func TestPartialEval(t *testing.T) {
+	type partialCase struct {
+		input    string
+		params   []*Param
+		residual string
+	}
+
+	praser, err := GetNewPraser([]*Param{GetParam("region", "EU")}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	caseList := []partialCase{
+		{`{{ttl}} > 3 * 24 * 3600`, nil, `{{ttl}} > 259200`},
+		{`upper("abc") == {{name}}`, nil, `"ABC" == {{name}}`},
+		{`{{region}} == "EU" and {{amount}} > {{limit}}`, nil, `true and {{amount}} > {{limit}}`},
+		{`{{region}} == "EU" and {{amount}} > {{limit}}`, []*Param{GetParam("limit", 100)}, `true and {{amount}} > 100`},
+		{`({{a}} + 1) * -{{b}}`, []*Param{GetParam("b", -2)}, `({{a}} + 1) * 2`},
+		{`-({{a}} + 1) if not {{c}} else {{a}} - (1 - 2)`, nil, `-({{a}} + 1) if not {{c}} else {{a}} - -1`},
+		{`{{a}} < (2 < 3) == ({{a}} < 2) < 3`, nil, `{{a}} < true == ({{a}} < 2) < 3`},
+		{`{{a}} / 0 + 1 / 0`, nil, `{{a}} / 0 + 1 / 0`},
+		{`{{x}} + float("2") + decimal("1.5")`, nil, `{{x}} + 2.0 + decimal("1.5")`},
+		{`{{a}} + 1 if {{region}} == "EU" else {{b}}`, nil, `{{a}} + 1 if true else {{b}}`},
+		{`{{x}} if {{flag}} else {{y}} * 2`, []*Param{GetParam("flag", false)}, `{{x}} if false else {{y}} * 2`},
+		{`{{a}} + 1 if {{region}} == "EU" else 0`, nil, `{{a}} + 1`},
+		{`2 if {{region}} == "EU" else 1 / 0`, nil, `2 if true else 1 / 0`},
+		{`{{s}} == {{t}}`, []*Param{GetParam("s", `say "hi", it's`)}, `concat('say "hi", it', "'s") == {{t}}`},
+		{`{{a}} + {{m}}`, []*Param{GetParam("m", int64(math.MinInt64))}, `{{a}} + (-9223372036854775807 - 1)`},
+	}
+
+	for _, c := range caseList {
+		prog, err := praser.Compile(c.input)
+		if err != nil {
+			t.Fatalf("compile %v failed: %v", c.input, err)
+		}
+		residual, err := prog.PartialEval(c.params)
+		if err != nil {
+			t.Fatalf("partial eval %v failed: %v", c.input, err)
+		}
+		if residual.String() != c.residual {
+			t.Fatalf("unexpected residual of %v: %v, expect: %v", c.input, residual.String(), c.residual)
+		}
+		// the printable form can be compiled again
+		if _, err := praser.Compile(residual.String()); err != nil {
+			t.Fatalf("compile residual %v failed: %v", residual.String(), err)
+		}
+	}
+
+	// all the variables are known
+	prog, err := praser.Compile(`{{region}} == "EU" and {{amount}} >= 100`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	residual, err := prog.PartialEval([]*Param{GetParam("amount", 150)})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if res, ok := residual.Value(); !ok || !res.GetBool() {
+		t.Fatalf("unexpected value: %v, %v", res, ok)
+	}
+	if _, ok := prog.Value(); ok {
+		t.Fatalf("the origin program should not be changed")
+	}
+
+	// the residual program compiled again gives the same result
+	type roundTripCase struct {
+		input string
+		known []*Param
+		rest  []*Param
+	}
+	for _, c := range []roundTripCase{
+		{`concat({{s}}, {{t}})`, []*Param{GetParam("s", `say "hi", it's`)}, []*Param{GetParam("t", `'"`)}},
+		{`{{s}} == {{t}}`, []*Param{GetParam("s", `\"'`)}, []*Param{GetParam("t", `\"'`)}},
+		{`{{m}} + {{n}}`, []*Param{GetParam("m", int64(math.MinInt64))}, []*Param{GetParam("n", 0)}},
+		{`{{x}} if {{flag}} else {{y}}`, []*Param{GetParam("flag", true)}, []*Param{GetParam("x", 1), GetParam("y", 2)}},
+	} {
+		fullPraser, err := GetNewPraser(append(append([]*Param{}, c.known...), c.rest...), false)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		expect, err := fullPraser.Parse(c.input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		prog, err := praser.Compile(c.input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		residual, err := prog.PartialEval(c.known)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		restPraser, err := GetNewPraser(c.rest, false)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		compiled, err := restPraser.Compile(residual.String())
+		if err != nil {
+			t.Fatalf("compile residual %v failed: %v", residual.String(), err)
+		}
+		res, err := compiled.Eval()
+		if err != nil || res.ValueType != expect.ValueType || !res.compare(expect, defaultFloatEqual) {
+			t.Fatalf("unexpected result of residual %v: %v, %v, expect: %v", residual.String(), res, err, expect)
+		}
+	}
+
+	// the string with a newline or ends with a single backslash has no literal
+	for _, value := range []string{`a\`, "a\nb"} {
+		if _, err := prog.PartialEval([]*Param{GetParam("region", value)}); err == nil ||
+			err.(*EngineErr).ErrCode != ErrRuleEngineInvalidParam {
+			t.Fatalf("unexpected err of %q: %v", value, err)
+		}
+	}
+
+	// the error of the branch not taken is still returned
+	prog, err = praser.Compile(`2 if {{flag}} else 1 / 0`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	residual, err = prog.PartialEval([]*Param{GetParam("flag", true)})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, err := residual.Eval(); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineDivideByZero {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	deps, err := praser.Dependencies(`{{amount}} >= 1000 and ({{user.level}} == "vip" or -5 < {{amount}}) ` +
+		`and len({{code}}) == 6 and {{items.0.price}} > {{amount}} and upper({{user.level}}) != "X" and {{amount}} >= 1000`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	varNames := []string{}
+	for _, v := range deps.Vars {
+		varNames = append(varNames, v.Name)
+	}
+	if strings.Join(varNames, ",") != "amount,user.level,code,items.0.price" {
+		t.Fatalf("unexpected vars: %v", varNames)
+	}
+	if strings.Join(deps.Funcs, ",") != "len,upper" {
+		t.Fatalf("unexpected funcs: %v", deps.Funcs)
+	}
+	if path := deps.Var("items.0.price").Path; strings.Join(path, "|") != "items|0|price" {
+		t.Fatalf("unexpected path: %v", path)
+	}
+
+	compared := deps.Var("amount").Compared
+	if len(compared) != 2 || compared[0].GetInt() != 1000 || compared[1].GetInt() != -5 {
+		t.Fatalf("unexpected compared values: %v", compared)
+	}
+	if compared := deps.Var("user.level").Compared; len(compared) != 1 || compared[0].GetString() != "vip" {
+		t.Fatalf("unexpected compared values: %v", compared)
+	}
+	if compared := deps.Var("code").Compared; len(compared) != 0 {
+		t.Fatalf("unexpected compared values: %v", compared)
+	}
+	if deps.Var("unknown") != nil {
+		t.Fatalf("unexpected var")
+	}
+}
+
+func TestBytecodeVM(t *testing.T) {
+	params := []*Param{
+		GetParam("i", 7),
+		GetParam("f", 2.5),
+		GetParam("s", "hello"),
+		GetParam("b", true),
+		GetParam("d", decimal.RequireFromString("1.25")),
+		GetParam("max", int64(math.MaxInt64)),
+		GetParam("min", int64(math.MinInt64)),
+		GetParam("nan", math.NaN()),
+	}
+
+	inputList := []string{
+		`1 + 2 * 3 - 4 / 2 % 3`,
+		`{{i}} + {{f}} * 2 > 10 and not {{b}} or {{s}} == "hello"`,
+		`{{i}} / 2 + {{i}} % 4 - -{{i}}`,
+		`{{f}} / 2 == 1.25 and {{f}} != 1.25`,
+		`{{d}} * 3 + {{i}} / 3.0`,
+		`{{max}} + 1`,
+		`{{min}} / -1`,
+		`-{{min}}`,
+		`{{i}} / 0`,
+		`{{f}} / 0`,
+		`{{i}} % 0`,
+		`{{f}} % 2`,
+		`{{nan}} == {{nan}} or {{nan}} < 1`,
+		`{{i}} if {{b}} else {{s}}`,
+		`1 if {{i}} else 2`,
+		`{{s}} == 1`,
+		`{{b}} and 1`,
+		`len(upper({{s}})) + min(1, {{f}}, {{d}})`,
+		`regexFind("l+", {{s}}) == "ll"`,
+		`{{unknown}} + 1`,
+		`0.1 + 0.2 == 0.3`,
+		`int({{f}}) * 2 >= 4`,
+	}
+
+	optsList := [][]Option{
+		nil,
+		{WithIntegerOverflow(IntegerOverflowBigInt)},
+		{WithIntegerOverflow(IntegerOverflowDecimal), WithFloatEqualExact()},
+		{WithMaxSteps(5)},
+	}
+	for _, useDecimal := range []bool{false, true} {
+		for _, opts := range optsList {
+			praser, err := GetNewPraser(params, useDecimal, opts...)
+			if err != nil {
+				t.Fatalf("%v\n", err)
+			}
+			for _, input := range inputList {
+				prog, err := praser.Compile(input)
+				if err != nil {
+					t.Fatalf("compile %v failed: %v", input, err)
+				}
+				expect, expectErr := prog.Eval()
+				res, err := prog.Bytecode().Eval()
+				if (expectErr == nil) != (err == nil) ||
+					(err != nil && err.(*EngineErr).ErrCode != expectErr.(*EngineErr).ErrCode) {
+					t.Fatalf("unexpected error of %v, decimal: %v, get: %v, expect: %v", input, useDecimal, err, expectErr)
+				}
+				if err == nil && (res.ValueType != expect.ValueType || fmt.Sprint(res.Value) != fmt.Sprint(expect.Value)) {
+					t.Fatalf("unexpected result of %v, decimal: %v, get: %v, expect: %v", input, useDecimal, res, expect)
+				}
+			}
+		}
+	}
+}
+
+func TestEvalBatch(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("limit", 100)}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	prog, err := praser.Compile(`{{amount}} > {{limit}} and {{user.level}} == "vip"`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	checkResults := func(results []BatchResult, expect []interface{}) {
+		t.Helper()
+		if len(results) != len(expect) {
+			t.Fatalf("unexpected results count: %v", len(results))
+		}
+		for i, res := range results {
+			if code, ok := expect[i].(int); ok {
+				if res.Err == nil || res.Err.(*EngineErr).ErrCode != code {
+					t.Fatalf("unexpected error of record %v: %v", i, res.Err)
+				}
+			} else if res.Err != nil || res.Value.GetBool() != expect[i].(bool) {
+				t.Fatalf("unexpected result of record %v: %v, %v", i, res.Value, res.Err)
+			}
+		}
+	}
+
+	mapRecords := []map[string]interface{}{
+		{"amount": 150, "user": map[string]interface{}{"level": "vip"}},
+		{"amount": 50, "user": map[string]interface{}{"level": "vip"}},
+		{"amount": 150, "user": map[string]interface{}{"level": "normal"}},
+		{"amount": 500, "limit": 1000, "user": map[string]interface{}{"level": "vip"}},
+		{"amount": "150", "user": map[string]interface{}{"level": "vip"}},
+		{"user": map[string]interface{}{"level": "vip"}},
+	}
+	expect := []interface{}{true, false, false, false, ErrRuleEngineNotSupportedOperator, ErrRuleEngineUnknownVarName}
+
+	records, err := SliceRecords(mapRecords)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	checkResults(prog.EvalBatch(context.Background(), records), expect)
+
+	records, _ = SliceRecords(mapRecords)
+	checkResults(prog.EvalBatch(context.Background(), records, WithBatchWorkers(4)), expect)
+
+	type batchUser struct {
+		Level string `rule:"level"`
+	}
+	type batchRecord struct {
+		Amount int64      `rule:"amount"`
+		User   *batchUser `rule:"user"`
+	}
+	records, _ = SliceRecords([]batchRecord{{150, &batchUser{"vip"}}, {150, nil}})
+	checkResults(prog.EvalBatch(context.Background(), records), []interface{}{true, ErrRuleEngineUnknownVarName})
+
+	records, err = ColumnRecords(map[string]interface{}{
+		"amount":     []int64{150, 50, 200},
+		"user.level": []string{"vip", "vip", "normal"},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	checkResults(prog.EvalBatch(context.Background(), records, WithBatchWorkers(2)), []interface{}{true, false, false})
+
+	if _, err := ColumnRecords(map[string]interface{}{"a": []int{1}, "b": []int{1, 2}}); err == nil {
+		t.Fatalf("expect column length error")
+	}
+	if _, err := SliceRecords(1); err == nil {
+		t.Fatalf("expect slice error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	records, _ = SliceRecords(mapRecords[:2])
+	checkResults(prog.EvalBatch(ctx, records), []interface{}{ErrRuleEngineCanceled, ErrRuleEngineCanceled})
+
+	// the records are streamed to the workers, and a var is resolved once for each record
+	prog, err = praser.Compile(`{{amount}} > 1 and {{amount}} < 100 or {{amount}} == 500`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	var read, resolved, maxPending int64
+	index := 0
+	iter := RecordIteratorFunc(func() (VariableResolver, bool) {
+		if index >= 1000 {
+			return nil, false
+		}
+		if pending := read - atomic.LoadInt64(&resolved); pending > maxPending {
+			maxPending = pending
+		}
+		index++
+		read++
+		amount := index
+		return VariableResolverFunc(func(name string) (interface{}, error) {
+			atomic.AddInt64(&resolved, 1)
+			return amount, nil
+		}), true
+	})
+	results := prog.EvalBatch(context.Background(), iter, WithBatchWorkers(4))
+	if len(results) != 1000 || !results[1].Value.GetBool() || results[100].Value.GetBool() || !results[499].Value.GetBool() {
+		t.Fatalf("unexpected results: %v", len(results))
+	}
+	if resolved != 1000 {
+		t.Fatalf("unexpected resolved: %v", resolved)
+	}
+	if maxPending > 9 {
+		t.Fatalf("too many records read before evaluated: %v", maxPending)
+	}
+}
+
+func TestToSQL(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	columns := map[string]string{
+		"amount":     "o.amount",
+		"user.level": "u.level",
+		"name":       "u.name",
+		"active":     "u.active",
+	}
+
+	type sqlCase struct {
+		input   string
+		dialect SQLDialect
+		sql     string
+		args    []interface{}
+	}
+	caseList := []sqlCase{
+		{`{{amount}} >= 100 and {{user.level}} == "vip"`, SQLDialectMySQL,
+			`(o.amount >= ?) AND (u.level = ?)`, []interface{}{int64(100), "vip"}},
+		{`{{amount}} >= 100 and {{user.level}} == "vip"`, SQLDialectPostgres,
+			`(o.amount >= $1) AND (u.level = $2)`, []interface{}{int64(100), "vip"}},
+		{`not {{active}} or ({{amount}} * 2 - 1) % 3 != 0`, SQLDialectMySQL,
+			`(NOT u.active) OR ((((o.amount * ?) - ?) % ?) <> ?)`, []interface{}{int64(2), int64(1), int64(3), int64(0)}},
+		{`startWith({{name}}, "a_b") and endWith({{name}}, "%")`, SQLDialectPostgres,
+			`(u.name LIKE $1) AND (u.name LIKE $2)`, []interface{}{`a\_b%`, `%\%`}},
+		{`regexMatch("^a+$", {{name}})`, SQLDialectPostgres, `u.name ~ $1`, []interface{}{"^a+$"}},
+		{`regexMatch("^a+$", {{name}})`, SQLDialectMySQL, `u.name REGEXP ?`, []interface{}{"^a+$"}},
+		{`({{amount}} if {{active}} else -{{amount}}) > max(1, len(upper({{name}})))`, SQLDialectPostgres,
+			`CASE WHEN u.active THEN o.amount ELSE -o.amount END > GREATEST($1, OCTET_LENGTH(UPPER(u.name)))`,
+			[]interface{}{int64(1)}},
+	}
+	for _, c := range caseList {
+		prog, err := praser.Compile(c.input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		sql, args, err := prog.ToSQL(c.dialect, columns)
+		if err != nil {
+			t.Fatalf("translate %v failed: %v", c.input, err)
+		}
+		if sql != c.sql || fmt.Sprint(args) != fmt.Sprint(c.args) {
+			t.Fatalf("unexpected sql of %v: %v, %v, expect: %v, %v", c.input, sql, args, c.sql, c.args)
+		}
+	}
+
+	prog, err := praser.Compile(`{{unknown}} > 1 and startWith({{name}}, {{name}}) and isNaN({{amount}})`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	_, _, err = prog.ToSQL(SQLDialectMySQL, columns)
+	if err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineTranslate ||
+		!strings.Contains(err.Error(), "unknown") || !strings.Contains(err.Error(), "startWith") ||
+		!strings.Contains(err.Error(), "isNaN") {
+		t.Fatalf("expect translate error, get: %v", err)
+	}
+}
+
+func TestFilterDocument(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	fields := map[string]string{"user.level": "level"}
+
+	type filterCase struct {
+		input   string
+		mongo   string
+		elastic string
+	}
+	caseList := []filterCase{
+		{`{{amount}} >= 100 and {{user.level}} == "vip" and 3 * 1000 > {{score}}`,
+			`{"$and":[{"amount":{"$gte":100}},{"level":{"$eq":"vip"}},{"score":{"$lt":3000}}]}`,
+			`{"bool":{"filter":[{"range":{"amount":{"gte":100}}},{"term":{"level":"vip"}},{"range":{"score":{"lt":3000}}}]}}`},
+		{`not {{active}} or {{name}} != "a"`,
+			`{"$or":[{"$nor":[{"active":{"$eq":true}}]},{"name":{"$ne":"a"}}]}`,
+			`{"bool":{"minimum_should_match":1,"should":[{"bool":{"must_not":[{"term":{"active":true}}]}},{"bool":{"must_not":[{"term":{"name":"a"}}]}}]}}`},
+		{`startWith({{name}}, "a.b") and endWith({{name}}, "*x") and regexMatch("^[a-z]+", {{name}})`,
+			`{"$and":[{"name":{"$regex":"^a\\.b"}},{"name":{"$regex":"\\*x$"}},{"name":{"$regex":"^[a-z]+"}}]}`,
+			`{"bool":{"filter":[{"prefix":{"name":"a.b"}},{"wildcard":{"name":"*\\*x"}},{"regexp":{"name":"([a-z]+).*"}}]}}`},
+		{`{{price}} < decimal("1.50") or 1 > 2`,
+			`{"$or":[{"price":{"$lt":1.5}},{"$nor":[{}]}]}`,
+			`{"bool":{"minimum_should_match":1,"should":[{"range":{"price":{"lt":1.5}}},{"match_none":{}}]}}`},
+	}
+	for _, c := range caseList {
+		prog, err := praser.Compile(c.input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		mongo, err := prog.ToMongoFilter(fields)
+		if err != nil {
+			t.Fatalf("translate %v to mongo failed: %v", c.input, err)
+		}
+		if data, _ := json.Marshal(mongo); string(data) != c.mongo {
+			t.Fatalf("unexpected mongo filter of %v: %s", c.input, data)
+		}
+		elastic, err := prog.ToElasticQuery(fields)
+		if err != nil {
+			t.Fatalf("translate %v to elastic failed: %v", c.input, err)
+		}
+		if data, _ := json.Marshal(elastic); string(data) != c.elastic {
+			t.Fatalf("unexpected elastic query of %v: %s", c.input, data)
+		}
+	}
+
+	for _, input := range []string{
+		`{{a}} + 1 > {{b}} * 2`,
+		`{{a}} > {{b}}`,
+		`{{a}} + 1`,
+		`len({{name}}) > 3`,
+		`startWith({{name}}, {{prefix}})`,
+	} {
+		prog, err := praser.Compile(input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if _, err := prog.ToMongoFilter(nil); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineTranslate {
+			t.Fatalf("expect translate error of %v, get: %v", input, err)
+		}
+	}
+
+	prog, _ := praser.Compile(`regexMatch("\d+", {{name}})`)
+	if _, err := prog.ToElasticQuery(nil); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineTranslate {
+		t.Fatalf("expect translate error, get: %v", err)
+	}
+
+	for pattern, expect := range map[string]string{
+		`^[a-z]+@example\.com$`: `[a-z]+\@example\.com`,
+		`a&b~c<d>#"`:            `.*(a\&b\~c\<d\>\#\").*`,
+		`^price\$`:              `(price\$).*`,
+		`^price\\$`:             `price\\`,
+		`\\d`:                   `.*(\\d).*`,
+	} {
+		if res, err := elasticRegex(pattern); err != nil || res != expect {
+			t.Fatalf("unexpected regex of %v: %v %v", pattern, res, err)
+		}
+	}
+	for _, pattern := range []string{`\w+`, `(?i)abc`, `a\tb`, `^a|b`, `a|b\\$`} {
+		if _, err := elasticRegex(pattern); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineTranslate {
+			t.Fatalf("expect translate error of %v, get: %v", pattern, err)
+		}
+	}
+}
+
+func TestRuleEngineRelation(t *testing.T) {
+	checkList := []CheckUnit{
+		{"100 * 3 == 300", true, 0},
+		{"5.6 - 1.6 == 4", true, 0},
+		{"5.6 - 1.6 > 4", false, 0},
+		{"5.6  + -1.6 > 4", false, 0},
+		{"5.6  - 10 > -4.4", false, 0},
+		{"5.6  - 10 < -4.4", false, 0},
+		{"5.6  - 10 == -4.4", true, 0},
+		{"5.6  - 10 >= -4.4", true, 0},
+		{"5.6  - 10 <= -4.4", true, 0},
+		{"0x10 == 16", true, 0},
+		{"0x10 == 020", true, 0},
+		{"true > false", 0, int(ErrRuleEngineNotSupportedOperator)},
+		{"1 + 1 == 2 == true", true, 0},
+		{" 3.0 / 3  == 6.6 / 6.6 ", true, 0},
+		{" 0x10 > 0x30 ", false, 0},
+		{" 1 != 2 ", true, 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	rt, err = GetNewRuleEngineTest(t, nil, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func TestRuleEngineLogic(t *testing.T) {
+	checkList := []CheckUnit{
+		{"1 > 2 or 1 < 2", true, 0},
+		{"1 > 2 || 1 < 2", true, 0},
+		{"1 > 2 and 1 < 2", false, 0},
+		{"1 > 2 && 1 < 2", false, 0},
+		{"1 and true", 0, int(ErrRuleEngineNotSupportedOperator)},
+		{"1 && true", 0, int(ErrRuleEngineNotSupportedOperator)},
+		{"true AND false", false, 0},
+		{"true OR false", true, 0},
+		{"1 < 2 and 2 < 3 and 4.0 != 4.0001", true, 0},
+		{"1 < 2 or 2.0 < 3 or 4.00001 > 4", true, 0},
+		{"1 < 2 or 3.0 > 2.5 and 2.5 != 2.4", true, 0},
+		{"not(1 > 2) and not (2.5 < 2.0)", true, 0},
+		{"not true == false", true, 0},
+		{"not true == true", false, 0},
+		{"not (not true) == false", false, 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	rt, err = GetNewRuleEngineTest(t, nil, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func TestRuleEngineFunc(t *testing.T) {
+	checkList := []CheckUnit{
+		{`len("test")`, int64(4), 0},
+		{`len("test") == 4`, true, 0},
+		{`len("test") == len('test')`, true, 0},
+		{`len("test") - len('test2') == -1`, true, 0},
+		{`max(1,2,5.5,4,8)`, 8.0, 0},
+		{`min(1,2,5.5,4,8)`, 1.0, 0},
+		{`max(min(10.0, 20, 30), len("test"))`, 10, 0},
+		{`min(min(10.0, 20, 30), len("test"))`, 4, 0},
+		{`min(1,2,5.5,4,8) == 1`, true, 0},
+		{`abs(-1)`, int64(1), 0},
+		{`abs(-5.5)`, 5.5, 0},
+		{`abs(3.0 - 100)`, 97, 0},
+		{`max(len("test"), abs(-3), min(5,6))`, int64(5), 0},
+		{`min(len("test"), abs(-4.5), min(5,6))`, 4, 0},
+
+		{`upper("abc") == "ABC"`, true, 0},
+		{`lower("CN")`, "cn", 0},
+		{`len("HelloWorld") <= 5`, false, 0},
+		{`len("HelloWorld")`, int64(10), 0},
+
+		{`test()`, 0, int(ErrRuleEngineUnkonwnFunc)},
+		{`min()`, 0, int(ErrRuleEngineFuncArgument)},
+		{`max(1)`, 0, int(ErrRuleEngineFuncArgument)},
+
+		{`startWith("hello world", "hell")`, true, 0},
+		{`startWith("hello world", "ell")`, false, 0},
+
+		{`endWith("hello world", "world")`, true, 0},
+		{`startWith("hello world", "worl")`, false, 0},
+
+		{`int(3.33) == 3`, true, 0},
+		{`int("1878") - 8 == 187 * 10`, true, 0},
+		{`int(97) + 3 == max(100, -1)`, true, 0},
+		{`int(decimal(3.33)) -3 == 0`, true, 0},
+
+		{`float(300)`, 300.0, 0},
+		{`float("3.33") * 100 == int(1000 / 3.0)`, true, 0},
+		{`float(decimal(-3.33)) * 100 == -(1000 / 3)`, true, 0},
+
+		{`decimal(200) / 3`, decimal.NewFromFloat(200).Div(decimal.NewFromInt(3)), 0},
+		{`decimal("3.33")`, 3.33, 0},
+		{`decimal(3.33) * 10 - 0.3 == int(100.0 / 3)`, true, 0},
+		{`int(decimal(3.33) * 10) == int(100.0 / 3)`, true, 0},
+
+		{`string(decimal("3.33"))`, "3.33", 0},
+		{`string(100.234) == "100.234"`, true, 0},
+		{`decimal(string(3.33))`, 3.33, 0},
+		{`len(string(int(100.0 / 3)))`, 2, 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	rt, err = GetNewRuleEngineTest(t, nil, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func TestRuleEngineVar(t *testing.T) {
+	params := []*Param{
+		GetParamWithType("value", ValueTypeInteger, 100),
+		GetParam("int_value2", 33),
+		GetParamWithType("float_value", ValueTypeFloat, 5.5),
+		GetParam("float_value2", 3.33),
+		GetParamWithType("str_value", ValueTypeString, "test"),
+		GetParam("true_value", true),
+		GetParam("false_value", false),
+		GetParam("a.b.c", 10),
+		GetParamWithType("a.1", ValueTypeInteger, int64(1)),
+		GetParamWithType("d1", ValueTypeDecimal, decimal.NewFromInt(100)),
+		GetParam("d2", decimal.NewFromFloat(3.33)),
+		GetParamWithType("d3", ValueTypeDecimal, "3.33"),
+	}
+
+	checkList := []CheckUnit{
+		{`len({{str_value}})`, int64(4), 0},
+		{`len({{str_value}}) - {{value}}`, int64(-96), 0},
+		{`len({{str_value}}) - {{value}} == -96 and {{true_value}} == true`, true, 0},
+		{`max(len({{str_value}}), {{value}}, {{float_value}})`, 100, 0},
+		{`min(len({{str_value}}), {{value}}, {{float_value}})`, 4, 0},
+		{`{{true_value}} or {{false_value}}`, true, 0},
+		{`{{true_value}} and {{false_value}}`, false, 0},
+		{`{{a.b.c}}`, int64(10), 0},
+		{`{{.b.c}}`, 0, int(ErrRuleEngineSyntaxError)},
+		{`{{a.b.}}`, 0, int(ErrRuleEngineSyntaxError)},
+		{`{{unknown_name}}`, 0, int(ErrRuleEngineUnknownVarName)},
+		{`{{a.1}}`, int64(1), 0},
+		{`{{d1}} * {{d2}}`, 333, 0},
+		{`{{d1}} * {{d2}} - 3.0 == {{int_value2}} * {{value}} / 10`, true, 0},
+		{`{{d1}} * {{d2}} - 3 == {{float_value2}} * {{d1}} - ({{int_value2}} - 3)/ 10.0`, true, 0},
+		{`{{d3}} == {{d2}}`, true, 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, params, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	rt, err = GetNewRuleEngineTest(t, params, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func TestRuleEngineRegexMatch(t *testing.T) {
+	checkList := []CheckUnit{
+		{`regexMatch("^test$", "test")`, true, 0},
+		{`regexMatch("^test$", "test0")`, false, 0},
+		{`regexMatch("^0[xX][a-fA-F0-9]+", "0xasf4")`, true, 0},
+		{`regexMatch("foo.*", "seafood")`, true, 0},
+		{`regexMatch("(https?|ftp|file)://[-A-Za-z0-9+&@#/%?=~_|!:,.;]+[-A-Za-z0-9+&@#/%=~_|]",
+				"https://www.baidu.com")`, true, 0},
+		{`regexMatch("^\s*(DEV|TEST|UAT|STAGING|STABLE|LIVE)\s*$", " TEST ")`, true, 0},
+		{`regexMatch("^\s*http(s)?://.*shopee\.com", " https://test.shopee.com")`, true, 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	rt, err = GetNewRuleEngineTest(t, nil, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func TestRuleEngineRegexFunc(t *testing.T) {
+	params := []*Param{
+		GetParam("email", "alice@example.com"),
+		GetParam("pattern", "("),
+		GetParam("long", strings.Repeat("a", 20)),
+	}
+
+	checkList := []CheckUnit{
+		{`regexFind("[0-9]+", "abc123def45")`, "123", 0},
+		{`regexFind("[0-9]+", "abc")`, "", 0},
+		{`regexFindAll("[0-9]+", "abc123def45", 1)`, "45", 0},
+		{`regexFindAll("[0-9]+", "abc123def45", 2)`, "", 0},
+		{`regexFindAll("[0-9]+", "abc123def45", -1)`, 0, int(ErrRuleEngineFuncArgument)},
+		{`regexReplace("a(b*)", "abbcab", "<$1>")`, "<bb>c<b>", 0},
+		{`regexGroups("(\w+)@(?P<domain>[\w.]+)", {{email}}, 1)`, "alice", 0},
+		{`regexGroups("(\w+)@(?P<domain>[\w.]+)", {{email}}, "domain") == "example.com"`, true, 0},
+		{`regexGroups("(\w+)@(\w+)", "none", 2)`, "", 0},
+		{`regexGroups("(\w+)@(\w+)", {{email}}, 3)`, 0, int(ErrRuleEngineFuncArgument)},
+		{`regexMatch({{pattern}}, "x")`, 0, int(ErrRuleEngineRegexMatch)},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, params, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	checkList = []CheckUnit{
+		{`regexMatch("[0-9]{3}", "123")`, true, 0},
+		{`regexMatch("[0-9]{3}", {{long}})`, 0, int(ErrRuleEngineRegexLimit)},
+		{`regexMatch("[0-9]{3}[a-z]{3}", "x")`, 0, int(ErrRuleEngineRegexLimit)},
+	}
+	rt, err = GetNewRuleEngineTest(t, params, false, WithMaxRegexLength(12), WithMaxRegexInputLength(18))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	// literal pattern is checked when compile
+	_, err = rt.praser.Compile(`1 > 0 and regexMatch("a(b", "ab")`)
+	if err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineRegexMatch || !strings.Contains(err.Error(), "pos: 21") {
+		t.Fatalf("expect regex error with pos, get: %v", err)
+	}
+}
+
+func TestRegexCache(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("p", "a+")}, false, WithRegexCacheSize(2))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	for _, str := range []string{`regexMatch({{p}}, "aa")`, `regexMatch("b", "b")`, `regexMatch("c", "c")`} {
+		res, err := praser.Parse(str)
+		if err != nil || !res.GetBool() {
+			t.Fatalf("unexpected result: %v, %v", res, err)
+		}
+	}
+
+	cache := praser.operator.regexCache
+	if cache.len() != 2 {
+		t.Fatalf("unexpected cache size: %v", cache.len())
+	}
+	if _, ok := cache.get("a+"); ok {
+		t.Fatalf("the least recently used pattern should be removed")
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Fatalf("pattern c should be cached")
+	}
+}
+
+func TestRuleEngineIfElse(t *testing.T) {
+	params := []*Param{
+		GetParamWithType("x", ValueTypeInteger, int64(100)),
+		GetParamWithType("y", ValueTypeFloat, 50),
+		GetParamWithType("COUNTRY", ValueTypeString, "CN"),
+		GetParamWithType("field1", ValueTypeInteger, 2),
+		GetParamWithType("field2", ValueTypeFloat, 7.2),
+		GetParamWithType("field3", ValueTypeString, "Hel"),
+	}
+
+	checkList := []CheckUnit{
+		{`1 if true else 2`, int64(1), 0},
+		{`1 if false else 2`, int64(2), 0},
+		{`{{x}} + 10 >= 105.3 if {{y}} == 50 else false`, true, 0},
+		{`{{COUNTRY}} != "CN" or {{x}} >= 100`, true, 0},
+		{`{{x}} >= 100 if {{COUNTRY}} == "CN" else true`, true, 0},
+		{`{{y}} >= 100 if {{COUNTRY}} == "CN" else true`, false, 0},
+		{`1 if 1 else 2`, 0, int(ErrRuleEngineInvalidOperation)},
+		{"(({{field1}} > 0) and ({{field2}} > 7.8)) if len({{field3}}) >= 5 else {{field1}} + {{field2}} > 2.6", true, 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, params, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+
+	rt, err = GetNewRuleEngineTest(t, params, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func TestRuleEngineExapmle(t *testing.T) {
+	checkList := []CheckUnit{
+		{`3 * (5 - 2) + 1`, int64(10), 0},
+	}
+
+	rt, err := GetNewRuleEngineTest(t, nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	rt.batchCheck(&checkList)
+}
+
+func BenchmarkRule(b *testing.B) {
+	checkCase := CheckUnit{
+		"(({{field1}} > 0) and ({{field2}} > 7.8)) if len({{field3}}) >= 5 else {{field1}} + {{field2}} > 2.6",
+		true, 0,
+	}
+
+	params := []*Param{
+		GetParamWithType("x", ValueTypeInteger, int64(100)),
+		GetParamWithType("y", ValueTypeInteger, 50),
 		GetParamWithType("COUNTRY", ValueTypeString, "CN"),
 		GetParamWithType("field1", ValueTypeInteger, int64(2)),
 		GetParamWithType("field2", ValueTypeFloat, 7.2),
 		GetParamWithType("field3", ValueTypeString, "Hel"),
 	}
-	rt, err := GetNewRuleEngineTest(b, params, false)
+	rt, err := GetNewRuleEngineTest(b, params, false)
+	if err != nil {
+		b.Fatalf("%v\n", err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		rt.check(&checkCase)
+	}
+}
+
+var benchmarkFraudRule = `{{amount}} * 1.5 > {{limit}} and {{count}} + 3 < 10 or {{country}} == "CN" and {{score}} >= 0.75`
+
+func benchmarkFraudProgram(b *testing.B) *Program {
+	params := []*Param{
+		GetParam("amount", 1200.5),
+		GetParam("limit", 1000),
+		GetParam("count", 4),
+		GetParam("country", "SG"),
+		GetParam("score", 0.8),
+	}
+	praser, err := GetNewPraser(params, false)
+	if err != nil {
+		b.Fatalf("%v\n", err)
+	}
+	prog, err := praser.Compile(benchmarkFraudRule)
+	if err != nil {
+		b.Fatalf("%v\n", err)
+	}
+	return prog
+}
+
+func BenchmarkTreeEval(b *testing.B) {
+	prog := benchmarkFraudProgram(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := prog.Eval(); err != nil {
+			b.Fatalf("%v\n", err)
+		}
+	}
+}
+
+func BenchmarkBytecodeEval(b *testing.B) {
+	vp := benchmarkFraudProgram(b).Bytecode()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := vp.Eval(); err != nil {
+			b.Fatalf("%v\n", err)
+		}
+	}
+}
+
+func FuzzRuleEngineParse(f *testing.F) {
+	for _, seed := range []string{
+		`3 * (5 - 2) + 1`,
+		`{{x}} + 10 >= 105.3 if {{y}} == 50 else false`,
+		`len(upper({{s}})) > 3 and not false`,
+		`{{a.b.0}} != "str"`,
+		`min(1, 2.5, -3) % 2`,
+	} {
+		f.Add(seed)
+	}
+
+	params := []*Param{
+		GetParam("x", 100),
+		GetParam("y", 1.5),
+		GetParam("s", "hello"),
+	}
+	praser, err := GetNewPraser(params, false, WithMaxSourceLength(1024), WithMaxDepth(64))
 	if err != nil {
-		b.Fatalf("%v\n", err)
+		f.Fatalf("%v\n", err)
 	}
+	f.Fuzz(func(t *testing.T, str string) {
+		// only check it never panic
+		praser.Parse(str)
+	})
+}
 
-	for i := 0; i < b.N; i++ {
-		rt.check(&checkCase)
+func TestJsonLogic(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{
+		GetParam("a", 5), GetParam("b", 2), GetParam("name", "Springfield"), GetParam("tags", "x"),
+	}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	type jsonLogicCase struct {
+		logic  string
+		source string
+		result interface{}
+		export string
+	}
+	caseList := []jsonLogicCase{
+		{`{"and": [{">": [{"var": "a"}, 1]}, {"!=": [{"var": "b"}, 3]}, true]}`,
+			`{{a}} > 1 and {{b}} != 3 and true`, true,
+			`{"and":[{">":[{"var":"a"},1]},{"!=":[{"var":"b"},3]},true]}`},
+		{`{"<": [1, {"var": "a"}, 10]}`,
+			`1 < {{a}} and {{a}} < 10`, true,
+			`{"and":[{"<":[1,{"var":"a"}]},{"<":[{"var":"a"},10]}]}`},
+		{`{"if": [{">": [{"var": "a"}, 10]}, "big", {">": [{"var": "a"}, 3]}, "mid", "small"]}`,
+			`"big" if {{a}} > 10 else "mid" if {{a}} > 3 else "small"`, "mid",
+			`{"if":[{">":[{"var":"a"},10]},"big",{">":[{"var":"a"},3]},"mid","small"]}`},
+		{`{"*": [{"+": [{"var": "a"}, 1, 2]}, {"-": [{"var": "b"}]}, {"%": [7, 4]}]}`,
+			`({{a}} + 1 + 2) * -{{b}} * (7 % 4)`, int64(-48),
+			`{"*":[{"+":[{"var":"a"},1,2]},{"-":[{"var":"b"}]},{"%":[7,4]}]}`},
+		{`{"/": [{"var": "a"}, {"var": "b"}]}`,
+			`float({{a}}) / {{b}}`, 2.5,
+			`{"/":[{"var":"a"},{"var":"b"}]}`},
+		{`{"max": [{"var": "a"}, {"min": [1.5, 2]}]}`,
+			`max({{a}}, min(1.5, 2))`, 5.0,
+			`{"max":[{"var":"a"},{"min":[1.5,2]}]}`},
+		{`{"in": [{"var": "a"}, [1, 3, 5]]}`,
+			`{{a}} == 1 or {{a}} == 3 or {{a}} == 5`, true,
+			`{"or":[{"==":[{"var":"a"},1]},{"==":[{"var":"a"},3]},{"==":[{"var":"a"},5]}]}`},
+		{`{"in": ["ring.", {"var": "name"}]}`,
+			`regexMatch("ring\.", {{name}})`, false,
+			`{"in":["ring.",{"var":"name"}]}`},
+		{`{"!": {"in": ["Spring", {"var": "name"}]}}`,
+			`not (regexMatch("Spring", {{name}}))`, false,
+			`{"!":[{"in":["Spring",{"var":"name"}]}]}`},
+		{`{"==": [{"cat": ["tag-", {"var": "tags"}, 1]}, "tag-x1"]}`,
+			`concat("tag-", {{tags}}, "1") == "tag-x1"`, true,
+			`{"==":[{"cat":["tag-",{"var":"tags"},"1"]},"tag-x1"]}`},
+	}
+	for _, c := range caseList {
+		prog, err := praser.CompileJsonLogic([]byte(c.logic))
+		if err != nil {
+			t.Fatalf("compile %v failed: %v", c.logic, err)
+		}
+		if prog.Source() != c.source {
+			t.Fatalf("unexpected source of %v: %v", c.logic, prog.Source())
+		}
+		res, err := prog.Eval()
+		if err != nil {
+			t.Fatalf("eval %v failed: %v", c.source, err)
+		}
+		if res.Value != c.result {
+			t.Fatalf("unexpected result of %v: %v", c.source, res.Value)
+		}
+		data, err := prog.ToJsonLogic()
+		if err != nil {
+			t.Fatalf("export %v failed: %v", c.source, err)
+		}
+		if string(data) != c.export {
+			t.Fatalf("unexpected JsonLogic of %v: %s", c.source, data)
+		}
+	}
+
+	for _, logic := range []string{
+		`{"some": [{"var": "list"}, {"==": [{"var": ""}, 1]}]}`,
+		`{"var": ["a", 0]}`,
+		`{"var": "a..b"}`,
+		`{"if": [true, 1]}`,
+		`{"==": [{"var": "a"}, null]}`,
+		`{"+": ["1"]}`,
+		`{"in": [{"var": "a"}, {"var": "name"}]}`,
+		`{"and": [true], "or": [false]}`,
+		`{"==": [1, 1]} {}`,
+	} {
+		if _, err := praser.CompileJsonLogic([]byte(logic)); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineTranslate {
+			t.Fatalf("expect translate error of %v, get: %v", logic, err)
+		}
+	}
+
+	for _, input := range []string{`len({{name}}) > 3`, `regexMatch("^a", {{name}})`, `float("NaN") > 1`, `7 / 2`, `{{a}} / {{b}}`} {
+		prog, err := praser.Compile(input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if _, err := prog.ToJsonLogic(); err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineTranslate {
+			t.Fatalf("expect translate error of %v, get: %v", input, err)
+		}
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("count", 3)}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	schema := map[string]ValueType{
+		"user.name": ValueTypeString,
+		"amount":    ValueTypeDecimal,
+		"active":    ValueTypeBool,
+		"extra":     ValueTypeNone,
+	}
+
+	type typeCase struct {
+		input string
+		typ   ValueType
+	}
+	for _, c := range []typeCase{
+		{`{{count}} * 2 + 1`, ValueTypeInteger},
+		{`{{count}} / 2.0`, ValueTypeFloat},
+		{`{{amount}} * {{count}}`, ValueTypeDecimal},
+		{`len(upper({{user.name}})) > {{count}} and {{active}}`, ValueTypeBool},
+		{`max({{count}}, 1, 2.5)`, ValueTypeFloat},
+		{`concat("a", regexReplace("b", {{user.name}}, "c"))`, ValueTypeString},
+		{`"a" if {{active}} else "b"`, ValueTypeString},
+		{`1 if {{active}} else "b"`, ValueTypeNone},
+		{`{{extra}} + 1`, ValueTypeNone},
+		{`{{extra}} == "x"`, ValueTypeBool},
+	} {
+		res := praser.TypeCheck(c.input, schema)
+		if len(res.Diagnostics) != 0 {
+			t.Fatalf("unexpected diagnostics of %v: %+v", c.input, res.Diagnostics)
+		}
+		if res.Type != c.typ {
+			t.Fatalf("unexpected type of %v: %v", c.input, res.Type)
+		}
+	}
+
+	type diagnosticCase struct {
+		input string
+		code  int
+		pos   int
+		end   int
+	}
+	for _, c := range []diagnosticCase{
+		{`{{user.name}} + 1`, ErrRuleEngineInvalidOperation, 0, 13},
+		{`len({{count}}) > 1`, ErrRuleEngineInvalidOperation, 4, 13},
+		{`{{active}} == 1`, ErrRuleEngineInvalidOperation, 0, 15},
+		{`min(1)`, ErrRuleEngineFuncArgument, 0, 6},
+		{`{{unknown}} > 1`, ErrRuleEngineUnknownVarName, 0, 11},
+		{`not {{count}}`, ErrRuleEngineInvalidOperation, 4, 13},
+		{`1 + 2 )`, ErrRuleEngineSyntaxError, 6, 7},
+		{`foo(1) > 2`, ErrRuleEngineUnkonwnFunc, 0, 3},
+		{`regexMatch("(", "a")`, ErrRuleEngineRegexMatch, 11, 14},
+	} {
+		res := praser.TypeCheck(c.input, schema)
+		if len(res.Diagnostics) != 1 {
+			t.Fatalf("expect 1 diagnostic of %v, get: %+v", c.input, res.Diagnostics)
+		}
+		d := res.Diagnostics[0]
+		if d.Code != c.code || d.Pos != c.pos || d.End != c.end {
+			t.Fatalf("unexpected diagnostic of %v: %+v", c.input, d)
+		}
+	}
+
+	res := praser.TypeCheck(`len({{user.name}}) + {{count}}`, schema)
+	if info, ok := res.TypeAt(7); !ok || info.Var != "user.name" || info.Type != ValueTypeString {
+		t.Fatalf("unexpected type at 7: %+v", info)
+	}
+	if info, ok := res.TypeAt(1); !ok || info.Func != "len" || info.Type != ValueTypeInteger {
+		t.Fatalf("unexpected type at 1: %+v", info)
+	}
+	if info, ok := res.TypeAt(19); !ok || info.Type != ValueTypeInteger || info.Pos != 0 {
+		t.Fatalf("unexpected type at 19: %+v", info)
+	}
+
+	for name := range funcMap {
+		sig, ok := GetFuncSignature(name)
+		if !ok || !strings.HasPrefix(sig.Label, name+"(") || sig.Doc == "" {
+			t.Fatalf("missing signature of func %v", name)
+		}
+	}
+	if sig, _ := GetFuncSignature("min"); sig.Label != "min(x number, y ...number) number" {
+		t.Fatalf("unexpected signature: %v", sig.Label)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	type tokenCase struct {
+		input  string
+		tokens string
+	}
+	caseList := []tokenCase{
+		{`{{user.age}} >= 18 and not isNaN({{ score . 0 }}) || 'a' != "b" && !true`,
+			`variable:{{user.age}} operator:>= number:18 keyword:and keyword:not function:isNaN operator:( ` +
+				`variable:{{ score . 0 }} operator:) operator:|| string:'a' operator:!= string:"b" operator:&& operator:! keyword:true`},
+		{`1.5e3 if x(0x1F) else len`,
+			`number:1.5e3 keyword:if function:x operator:( number:0x1F operator:) keyword:else function:len`},
+		{`{{user. > 1 and "abc`,
+			`variable:{{user. operator:> number:1 keyword:and error:"abc`},
+		{"a = @ }}\n\"x\"",
+			`error:a error:= error:@ error:}} string:"x"`},
+		{`中 {{`, `error:中 variable:{{`},
+	}
+	for _, c := range caseList {
+		tokens := Tokenize(c.input)
+		var list []string
+		for _, token := range tokens {
+			if token.Text != c.input[token.Pos:token.End] {
+				t.Fatalf("invalid span of %v: %+v", c.input, token)
+			}
+			list = append(list, fmt.Sprintf("%v:%v", token.Kind, token.Text))
+		}
+		if strings.Join(list, " ") != c.tokens {
+			t.Fatalf("unexpected tokens of %v: %v", c.input, strings.Join(list, " "))
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	input := `{{a}} > 1 and  "<b>"`
+	html := `<span class="rule-variable">{{a}}</span> <span class="rule-operator">&gt;</span> ` +
+		`<span class="rule-number">1</span> <span class="rule-keyword">and</span>  <span class="rule-string">&#34;&lt;b&gt;&#34;</span>`
+	if res := HighlightHTML(input); res != html {
+		t.Fatalf("unexpected html: %v", res)
+	}
+	ansi := "\x1b[33m{{a}}\x1b[0m \x1b[37m>\x1b[0m \x1b[36m1\x1b[0m \x1b[35mand\x1b[0m  \x1b[32m\"<b>\"\x1b[0m"
+	if res := HighlightANSI(input); res != ansi {
+		t.Fatalf("unexpected ansi: %q", res)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	for _, c := range []struct {
+		input string
+		msg   string
+	}{
+		{`len(1 2)`, "unexpected integer 2, expected ')' or ',' after argument"},
+		{`(1 + 2`, "unexpected end of input, expected ')'"},
+		{`{{a.}}`, "unexpected '}}', expected var name after '.'"},
+		{`{{a b}}`, "unexpected identifier b, expected '}}' or '.' after var name"},
+		{`1 2`, "unexpected integer 2, expected operator or end of input"},
+		{`1 if 2`, "unexpected end of input, expected 'else' after condition"},
+		{`max(1,)`, "unexpected ')', expected expression"},
+		{`1 && || 2`, "unexpected '||', expected expression"},
+		{`foo 1`, "unexpected integer 1, expected '(' after func name"},
+		{`1 @ 2`, "invalid character '@'"},
+	} {
+		_, err := praser.Compile(c.input)
+		if err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineSyntaxError || !strings.Contains(err.Error(), c.msg) {
+			t.Fatalf("unexpected error of %v: %v", c.input, err)
+		}
+	}
+
+	// all the errors are reported
+	input := `foo(1) or 1 + * 2 and len({{a}} 2) and {{a b}}`
+	_, err = praser.Compile(input)
+	if err == nil || err.(*EngineErr).ErrCode != ErrRuleEngineUnkonwnFunc ||
+		!strings.Contains(err.Error(), "\n              ^                 ^          ^\n") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res := praser.TypeCheck(input, nil)
+	if len(res.Diagnostics) != 4 {
+		t.Fatalf("expect 4 diagnostics, get: %+v", res.Diagnostics)
+	}
+	for i, c := range []struct {
+		code int
+		pos  int
+		end  int
+	}{
+		{ErrRuleEngineUnkonwnFunc, 0, 3},
+		{ErrRuleEngineSyntaxError, 14, 15},
+		{ErrRuleEngineSyntaxError, 32, 33},
+		{ErrRuleEngineSyntaxError, 43, 44},
+	} {
+		if d := res.Diagnostics[i]; d.Code != c.code || d.Pos != c.pos || d.End != c.end {
+			t.Fatalf("unexpected diagnostic %v: %+v", i, d)
+		}
+	}
+
+	// the parser stop when there are too many errors
+	if res := praser.TypeCheck(strings.Repeat("1 + * ", 100), nil); len(res.Diagnostics) != maxSyntaxErrors {
+		t.Fatalf("expect %v diagnostics, get: %v", maxSyntaxErrors, len(res.Diagnostics))
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("limit", 100)}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	type equivCase struct {
+		x     string
+		y     string
+		equal bool
+	}
+	for _, c := range []equivCase{
+		{`{{a}} > 1 and {{b}} == "x"`, `("x" == {{b}}) && 1 < {{a}}`, true},
+		{`{{a}} >= 3 * 1000`, `3000 <= {{a}}`, true},
+		{`{{a}} or {{b}} or {{c}}`, `{{c}} || ({{a}} || {{b}})`, true},
+		{`{{a}} + 1 > 2`, `1 + {{a}} > 2`, true},
+		{`{{a}} + {{limit}} > 2`, `{{limit}} + {{a}} > 2`, true},
+		{`{{a}} > {{limit}}`, `{{a}} > 100`, false},
+		{`{{a}} * {{b}} != 0`, `0 != {{b}} * {{a}}`, true},
+		{`{{a}} + {{b}} > 2`, `{{b}} + {{a}} > 2`, true},
+		{`{{a}} - 1 > 0`, `1 - {{a}} > 0`, false},
+		{`{{a}} > 1 and {{b}}`, `{{a}} > 1 or {{b}}`, false},
+		{`1 / 0 > {{a}}`, `{{a}} < 1 / 0`, true},
+	} {
+		x, err := praser.Compile(c.x)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		y, err := praser.Compile(c.y)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if x.Equivalent(y) != c.equal || (len(x.Diff(y)) == 0) != c.equal {
+			t.Fatalf("unexpected equivalent of %v and %v: %v, %v", c.x, c.y, x.NormalForm(), y.NormalForm())
+		}
+	}
+
+	diffs, err := praser.Diff(`{{amount}} > 100 and {{level}} == "vip" and len({{code}}) == 6`,
+		`len({{code}}) == 6 and "gold" == {{level}} and {{amount}} >= 100 and {{new}}`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	expected := []string{
+		`changed: {{amount}} > 100 -> {{amount}} >= 100`,
+		`changed: "vip" -> "gold"`,
+		`added: {{new}}`,
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("unexpected diffs: %v", diffs)
+	}
+	for i, d := range diffs {
+		if d.String() != expected[i] {
+			t.Fatalf("unexpected diff %v: %v", i, d)
+		}
+	}
+	if diffs[1].Old.Pos != 34 || diffs[1].New.Pos != 23 || diffs[2].Kind != DiffAdded || diffs[2].New.End != 76 {
+		t.Fatalf("unexpected span: %+v, %+v, %+v", diffs[1].Old, diffs[1].New, diffs[2].New)
+	}
+
+	// the parentheses are in the text
+	for _, c := range [][3]string{
+		{`-(-{{a}}) > 1`, `{{a}} > 2`, `changed: -(-{{a}}) -> {{a}}`},
+		{`{{a}} and ({{b}} or {{c}})`, `{{a}} and {{d}}`, `changed: ({{b}} or {{c}}) -> {{d}}`},
+		{`({{a}} + 1) * 2 > 0`, `({{a}} + 2) * 2 > 0`, `changed: 1 -> 2`},
+	} {
+		diffs, err := praser.Diff(c[0], c[1])
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if len(diffs) == 0 || diffs[0].String() != c[2] {
+			t.Fatalf("unexpected diffs of %v: %v", c[0], diffs)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	type findingCase struct {
+		input string
+		kind  FindingKind
+		text  string
+	}
+	for _, c := range []findingCase{
+		{`{{age}} > 18 and {{age}} < 10`, FindingAlwaysFalse, `{{age}} > 18 and {{age}} < 10`},
+		{`len({{code}}) > 6 or len({{code}}) <= 6`, FindingAlwaysTrue, `len({{code}}) > 6 or len({{code}}) <= 6`},
+		{`{{age}} > 18 and ({{age}} > 10 or {{age}} <= 10)`, FindingAlwaysTrue, `({{age}} > 10 or {{age}} <= 10)`},
+		{`{{a}} == 1 and {{a}} != 1`, FindingAlwaysFalse, `{{a}} == 1 and {{a}} != 1`},
+		{`{{a}} == 0.3 and {{a}} == 0.4`, FindingAlwaysFalse, `{{a}} == 0.3 and {{a}} == 0.4`},
+		{`{{age}} > 18 and {{age}} > 10`, FindingAlwaysTrue, `{{age}} > 10`},
+		{`{{a}} >= 1 and {{a}} <= 1 and {{a}} != 1`, FindingAlwaysFalse, `{{a}} >= 1 and {{a}} <= 1 and {{a}} != 1`},
+		{`len({{code}}) == 6 and 7 < len({{code}})`, FindingAlwaysFalse, `len({{code}}) == 6 and 7 < len({{code}})`},
+		{`{{level}} == "vip" and {{level}} == "gold"`, FindingAlwaysFalse, `{{level}} == "vip" and {{level}} == "gold"`},
+		{`{{level}} == "vip" and {{level}} != "gold"`, FindingAlwaysTrue, `{{level}} != "gold"`},
+		{`{{active}} and not {{active}}`, FindingAlwaysFalse, `{{active}} and not {{active}}`},
+		{`{{x}} if true else {{y}}`, FindingDeadBranch, `{{y}}`},
+		{`{{a}} > 5 and ({{x}} if {{a}} > 3 else {{y}})`, FindingDeadBranch, `{{y}}`},
+		{`{{a}} > 5 if {{a}} > 10 else false`, FindingAlwaysTrue, `{{a}} > 5`},
+		{`{{a}} < 1 or {{b}} or {{a}} < 0`, FindingAlwaysFalse, `{{a}} < 0`},
+	} {
+		findings, err := praser.Analyze(c.input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if len(findings) != 1 || findings[0].Kind != c.kind || findings[0].Text != c.text {
+			t.Fatalf("unexpected findings of %v: %v", c.input, findings)
+		}
+	}
+
+	for _, input := range []string{
+		`{{a}} > 1 and {{a}} < 2`,
+		`{{a}} > 18 or {{a}} > 10`,
+		`{{a}} == "x" and {{a}} > 1`,
+		`({{a}} if {{b}} > 3 else 0) + 1 > 2 and {{b}} > 5`,
+		`true`,
+		// NaN is neither > 18 nor <= 18
+		`{{age}} > 18 or {{age}} <= 18`,
+		// equal by the float epsilon
+		`{{a}} == 0.1 + 0.2 and {{a}} == 0.3`,
+		`{{a}} == 0.3 and {{a}} != 0.3000000000001`,
+		// the integers beyond 2^53 are not the same float
+		`{{id}} == 9007199254740993 and {{id}} != 9007199254740992`,
+	} {
+		if findings, _ := praser.Analyze(input); len(findings) != 0 {
+			t.Fatalf("unexpected findings of %v: %v", input, findings)
+		}
+	}
+
+	// the decimals are compared exactly
+	decimalPraser, err := GetNewPraser(nil, true)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if findings, _ := decimalPraser.Analyze(`{{a}} == 0.3 and {{a}} == 0.3000000000001`); len(findings) != 1 ||
+		findings[0].Kind != FindingAlwaysFalse {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+	exactPraser, err := GetNewPraser(nil, false, WithFloatEqualExact())
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if findings, _ := exactPraser.Analyze(`{{a}} == 0.1 + 0.2 and {{a}} == 0.3`); len(findings) != 1 ||
+		findings[0].Kind != FindingAlwaysFalse {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+
+	findings, err := praser.AnalyzeRules(map[string]string{
+		"teen":  `{{age}} >= 13 and {{age}} <= 19`,
+		"adult": `{{age}} >= 18 and {{country}} == "US" and {{age}} < 18`,
+		"promo": `{{amount}} * 0.9 if ({{vip}} or not {{vip}}) else {{amount}}`,
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(findings) != 2 || findings[0].Rule != "adult" || findings[0].Kind != FindingAlwaysFalse ||
+		findings[1].Rule != "promo" || findings[1].Kind != FindingDeadBranch || findings[1].Text != "{{amount}}" {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+	if _, err := praser.AnalyzeRules(map[string]string{"bad": `1 +`}); err == nil ||
+		!strings.Contains(err.Error(), "rule bad: syntax error") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGenerateTests(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	suite, err := praser.GenerateTests(`{{amount}} >= 1000 and len({{code}}) == 6`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(suite.Uncovered) != 0 {
+		t.Fatalf("unexpected uncovered: %v", suite.Uncovered)
+	}
+	amounts, lens := make(map[int64]bool), make(map[int]bool)
+	for _, c := range suite.Cases {
+		values := make(map[string]interface{})
+		for _, param := range c.Params {
+			values[param.Name] = param.Value
+		}
+		amounts[values["amount"].(int64)] = true
+		lens[len(values["code"].(string))] = true
+
+		// the expected value is the result of the program
+		casePraser, err := GetNewPraser(c.Params, false)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		res, err := casePraser.Parse(suite.Source)
+		if err != nil || c.Err != nil || res.GetBool() != c.Value.GetBool() {
+			t.Fatalf("unexpected case %v: %v %v", values, c.Value, c.Err)
+		}
+	}
+	if !amounts[1000] || !amounts[999] || !lens[6] {
+		t.Fatalf("boundary not generated: %v %v", amounts, lens)
+	}
+
+	// each condition both true and false, including the branch of the ternary
+	for _, input := range []string{
+		`{{amount}} > 99.5 or {{vip}}`,
+		`{{level}} == "gold" and ({{amount}} * 0.9 if {{amount}} > 100 else {{amount}}) > 50`,
+		`not {{flag}} or {{n}} != 3`,
+		`{{id}} == 9007199254740993`,
+	} {
+		suite, err := praser.GenerateTests(input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if len(suite.Uncovered) != 0 {
+			t.Fatalf("unexpected uncovered of %v: %v", input, suite.Uncovered)
+		}
+	}
+
+	// the length is a whole number not less than 0
+	for input, uncovered := range map[string]string{
+		`len({{code}}) > 2 and len({{code}}) < 3`: "len({{code}}) > 2 and len({{code}}) < 3 is true",
+		`len({{code}}) < -1`:                      "len({{code}}) < -1 is true",
+	} {
+		suite, err := praser.GenerateTests(input)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if len(suite.Uncovered) != 1 || suite.Uncovered[0] != uncovered {
+			t.Fatalf("unexpected uncovered of %v: %v", input, suite.Uncovered)
+		}
+	}
+
+	// the vars in the branches get the type of the constant compared with
+	suite, err = praser.GenerateTests(`({{x}} if {{a}} > 1 else {{y}}) == "q"`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(suite.Uncovered) != 0 {
+		t.Fatalf("unexpected uncovered: %v", suite.Uncovered)
+	}
+	for _, c := range suite.Cases {
+		if c.Err != nil {
+			t.Fatalf("unexpected error of case %v: %v", c.Params, c.Err)
+		}
+	}
+
+	// the boundaries do not overflow int64
+	for _, value := range []int64{math.MaxInt64, math.MinInt64} {
+		for _, c := range boundaryCandidates(GetTokenNode(ValueTypeInteger, value), false) {
+			if c.value.(int64) != value && (c.value.(int64) < 0) != (value < 0) {
+				t.Fatalf("overflow candidate of %v: %v", value, c.value)
+			}
+		}
+	}
+
+	// the conflict conditions can not be covered
+	suite, err = praser.GenerateTests(`{{age}} > 18 and {{age}} < 10`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(suite.Uncovered) != 1 || suite.Uncovered[0] != "{{age}} > 18 and {{age}} < 10 is true" {
+		t.Fatalf("unexpected uncovered: %v", suite.Uncovered)
+	}
+
+	// the error of the case is kept
+	suite, err = praser.GenerateTests(`10 / {{n}} > 1`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(suite.Cases) == 0 || suite.Cases[0].Err == nil {
+		t.Fatalf("unexpected cases: %v", suite.Cases)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	praser, err := GetNewPraser(nil, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	prog, err := praser.Compile(`{{amount}} >= 1000 and len({{code}}) == 6 or ({{vip}} if {{level}} > 3 else false)`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	c := prog.NewCoverage()
+	records, err := SliceRecords([]map[string]interface{}{
+		{"amount": 1000, "code": "abcdef", "vip": false, "level": 1},
+		{"amount": 10, "code": "abcdef", "vip": true, "level": 5},
+		{"amount": 10, "code": "abc"},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	results := c.EvalBatch(context.Background(), records)
+	if !results[0].Value.GetBool() || !results[1].Value.GetBool() || results[2].Err == nil {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	r := c.Report()
+	if r.Evals != 3 || r.Total != 18 || r.Covered != 13 {
+		t.Fatalf("unexpected report: %v", r)
+	}
+	counts := make(map[string][2]int)
+	for _, item := range r.Items {
+		counts[item.Kind.String()+" "+item.Text] = item.Counts
+	}
+	for key, expect := range map[string][2]int{
+		// the right side is not counted when the left side is false
+		"condition len({{code}}) == 6":                            {1, 0},
+		"short circuit {{amount}} >= 1000 and len({{code}}) == 6": {2, 1},
+		"condition {{amount}} >= 1000":                            {1, 2},
+		// the third record failed on {{vip}}, the branch is unknown
+		"branch ({{vip}} if {{level}} > 3 else false)": {1, 0},
+		"condition {{vip}}":                            {1, 0},
+		"condition {{level}} > 3":                      {1, 0},
+	} {
+		if counts[key] != expect {
+			t.Fatalf("unexpected counts of %v: %v", key, counts[key])
+		}
+	}
+	if !strings.Contains(r.String(), "condition len({{code}}) == 6, true: 1, false: 0, pos: 23, never false") {
+		t.Fatalf("unexpected text report: %v", r)
+	}
+
+	data, err := r.JSON()
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	var decoded CoverageReport
+	if err := json.Unmarshal(data, &decoded); err != nil || !strings.Contains(string(data), `"kind": "short circuit"`) ||
+		decoded.Covered != 13 || len(decoded.Items) != len(r.Items) {
+		t.Fatalf("unexpected json report: %s", data)
+	}
+
+	page := r.HTML()
+	if !strings.HasPrefix(page, `<pre class="rule-coverage"><span class="rule-partial"`) ||
+		!strings.Contains(page, `<span class="rule-covered" title="condition true: 1, false: 2">{{amount}} &gt;= 1000</span>`) ||
+		!strings.HasSuffix(page, `else false)</span></span></pre>`) {
+		t.Fatalf("unexpected html report: %v", page)
+	}
+
+	c.Reset()
+	if r := c.Report(); r.Evals != 0 || r.Covered != 0 {
+		t.Fatalf("unexpected report after reset: %v", r)
+	}
+}
+
+func TestGoldenSpec(t *testing.T) {
+	spec, err := ParseGoldenSpec([]byte(`{
+		"rules": {"adult": "{{age}} >= 18"},
+		"cases": [
+			{"rule": "adult", "params": [{"name": "age", "value": 18}], "expect": {"type": "bool", "value": true}},
+			{"name": "wrong", "rule": "adult", "params": [{"name": "age", "value": 17}], "expect": {"value": true}},
+			{"name": "float", "expr": "{{x}} / 4", "params": [{"name": "x", "type": "float", "value": 1}], "expect": {"value": 0.25}},
+			{"name": "type", "expr": "{{x}} + 1", "params": [{"name": "x", "value": 1}], "expect": {"type": "float"}},
+			{"name": "error", "expr": "1 / {{x}}", "params": [{"name": "x", "value": 0}], "error_code": 6},
+			{"name": "no error", "expr": "1 / {{x}}", "params": [{"name": "x", "value": 0}]},
+			{"name": "unknown rule", "rule": "teen"},
+			{"name": "bad param", "expr": "1", "params": [{"name": "x", "type": "int", "value": 0}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	results := spec.Run()
+	passed := []bool{true, false, true, false, true, false, false, false}
+	for i, res := range results {
+		if res.Passed != passed[i] {
+			t.Fatalf("unexpected result of %v: %v", res.Name, res)
+		}
+	}
+	if results[0].Name != "case 1" {
+		t.Fatalf("unexpected name: %v", results[0].Name)
+	}
+	if msg := results[1].String(); msg != "FAIL wrong\n--- expected\n+++ actual\n-value: true (bool)\n+value: false (bool)\n"+
+		"trace:\n  {{age}}: 17 (integer)\n  {{age}} >= 18: false (bool)\n" {
+		t.Fatalf("unexpected failure: %v", msg)
+	}
+	if !strings.Contains(results[3].Diff, "-type: float\n+value: 2 (integer)") {
+		t.Fatalf("unexpected failure: %v", results[3].Diff)
+	}
+	if !strings.Contains(results[5].Diff, "-no error\n+error: code 6, divide by zero") {
+		t.Fatalf("unexpected failure: %v", results[5].Diff)
+	}
+	if !strings.Contains(results[6].Diff, "unknown rule: teen") || !strings.Contains(results[7].Diff, "unknown type: int") {
+		t.Fatalf("unexpected failures: %v %v", results[6].Diff, results[7].Diff)
+	}
+
+	if _, err := ParseGoldenSpec([]byte(`{"cases": [{"expr": "1", "expected": {"value": 1}}]}`)); err == nil ||
+		err.(*EngineErr).ErrCode != ErrRuleEngineInvalidParam {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if _, err := LoadGoldenSpec("testdata/not_exist.json"); err == nil {
+		t.Fatalf("expect error")
+	}
+}
+
+func TestShadow(t *testing.T) {
+	praser, err := GetNewPraser([]*Param{GetParam("limit", 1000)}, false)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	primary, err := praser.Compile(`{{amount}} >= {{limit}} and len({{code}}) == 6`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	candidate, err := praser.Compile(`{{amount}} > {{limit}} and len({{code}}) == 6`)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resolved := 0
+	record := func(values map[string]interface{}) VariableResolver {
+		return VariableResolverFunc(func(name string) (interface{}, error) {
+			resolved++
+			return values[name], nil
+		})
+	}
+
+	sink := NewMemoryShadowSink(1)
+	shadow := NewShadow(primary, candidate, sink)
+	for _, c := range []struct {
+		values map[string]interface{}
+		expect bool
+	}{
+		{map[string]interface{}{"amount": 2000, "code": "abcdef"}, true},
+		{map[string]interface{}{"amount": 1000, "code": "abcdef"}, true},
+		{map[string]interface{}{"amount": 1000, "code": "abc"}, false},
+	} {
+		res, err := shadow.Eval(record(c.values))
+		if err != nil || res.GetBool() != c.expect {
+			t.Fatalf("unexpected result of %v: %v %v", c.values, res, err)
+		}
+	}
+	// each var is resolved once for the two programs, {{limit}} is not in the record
+	if resolved != 9 {
+		t.Fatalf("unexpected resolved: %v", resolved)
+	}
+	if stats := shadow.Stats(); stats != (ShadowStats{Evals: 3, Sampled: 3, Mismatches: 1}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	mismatches := sink.Mismatches()
+	if len(mismatches) != 1 || mismatches[0].Reason != "value" || mismatches[0].Primary.Value != "true" ||
+		mismatches[0].Candidate.Value != "false" || mismatches[0].Vars["amount"] != 1000 {
+		t.Fatalf("unexpected mismatches: %v", mismatches)
+	}
+
+	// the type and the error code, the same error is not a mismatch
+	var buf bytes.Buffer
+	for _, c := range []struct {
+		primary, candidate string
+		reason             string
+	}{
+		{`{{x}} * 2`, `{{x}} * 2.0`, "type"},
+		{`10 / {{x}}`, `10 / ({{x}} + 1)`, "error"},
+		{`10 / {{x}}`, `20 / {{x}}`, ""},
+		{`{{x}} + 1`, `{{x}} + 1`, ""},
+	} {
+		p, _ := praser.Compile(c.primary)
+		q, _ := praser.Compile(c.candidate)
+		jsonl := NewJSONLShadowSink(&buf)
+		shadow := NewShadow(p, q, jsonl)
+		buf.Reset()
+		res, err := shadow.Eval(record(map[string]interface{}{"x": 0}))
+		expect, expectErr := p.evalRecord(context.Background(), record(map[string]interface{}{"x": 0}))
+		if !reflect.DeepEqual(res, expect) || errCode(err) != errCode(expectErr) {
+			t.Fatalf("the primary result is not returned: %v %v", res, err)
+		}
+		if c.reason == "" {
+			if buf.Len() != 0 {
+				t.Fatalf("unexpected mismatch of %v: %s", c.primary, buf.String())
+			}
+			continue
+		}
+		var m ShadowMismatch
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil || m.Reason != c.reason {
+			t.Fatalf("unexpected mismatch of %v: %s", c.primary, buf.String())
+		}
+	}
+
+	// not sampled
+	shadow = NewShadow(primary, candidate, sink, WithShadowSampler(func() bool { return false }))
+	if res, err := shadow.Eval(record(map[string]interface{}{"amount": 1000, "code": "abcdef"})); err != nil || !res.GetBool() {
+		t.Fatalf("unexpected result: %v %v", res, err)
+	}
+	if stats := shadow.Stats(); stats != (ShadowStats{Evals: 1}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// the file sink
+	path := filepath.Join(t.TempDir(), "mismatches.jsonl")
+	fileSink, err := OpenJSONLShadowSink(path)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	shadow = NewShadow(primary, candidate, fileSink, WithShadowSampleRate(1))
+	for i := 0; i < 2; i++ {
+		shadow.Eval(record(map[string]interface{}{"amount": 1000, "code": "abcdef"}))
+	}
+	if err := fileSink.Close(); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 ||
+		!strings.Contains(lines[0], `"reason":"value"`) {
+		t.Fatalf("unexpected file: %s", data)
 	}
 }
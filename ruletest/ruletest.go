@@ -0,0 +1,50 @@
+// Package ruletest run the golden test specs of the rules in go test, see rule_engine.GoldenSpec.
+//
+//	func TestRules(t *testing.T) {
+//		ruletest.Run(t, "testdata/*.json")
+//	}
+package ruletest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/uyouii/rule_engine"
+)
+
+// Run load the spec files matched by the pattern, and run each case as a subtest named by the file and the case,
+// like "discount.json/vip". the opts are passed to rule_engine.GetNewPraser.
+func Run(t *testing.T, pattern string, opts ...rule_engine.Option) {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatalf("invalid pattern %v: %v", pattern, err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no spec file matches %v", pattern)
+	}
+
+	for _, path := range paths {
+		spec, err := rule_engine.LoadGoldenSpec(path)
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			RunSpec(t, spec, opts...)
+		})
+	}
+}
+
+// RunSpec run each case of the spec as a subtest named by the case
+func RunSpec(t *testing.T, spec *rule_engine.GoldenSpec, opts ...rule_engine.Option) {
+	t.Helper()
+	for _, c := range spec.Cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if res := spec.RunCase(c, opts...); !res.Passed {
+				t.Error(res.String())
+			}
+		})
+	}
+}
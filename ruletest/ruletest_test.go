@@ -0,0 +1,7 @@
+package ruletest
+
+import "testing"
+
+func TestRun(t *testing.T) {
+	Run(t, "testdata/*.json")
+}
@@ -0,0 +1,280 @@
+package rule_engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowOutcome is the result of a program in a mismatch, the value or the error
+type ShadowOutcome struct {
+	Type    string `json:"type,omitempty"`
+	Value   string `json:"value,omitempty"`
+	ErrCode int    `json:"err_code,omitempty"`
+	Err     string `json:"err,omitempty"`
+}
+
+func newShadowOutcome(value *TokenNode, err error) *ShadowOutcome {
+	if err != nil {
+		res := &ShadowOutcome{Err: err.Error()}
+		if engineErr, ok := err.(*EngineErr); ok {
+			res.ErrCode = engineErr.ErrCode
+		}
+		return res
+	}
+	return &ShadowOutcome{Type: value.ValueType.String(), Value: value.GetString()}
+}
+
+// ShadowMismatch is an input the candidate gives a different result from the primary
+type ShadowMismatch struct {
+	Time      time.Time              `json:"time"`
+	Reason    string                 `json:"reason"` // "value", "type" or "error"
+	Primary   *ShadowOutcome         `json:"primary"`
+	Candidate *ShadowOutcome         `json:"candidate"`
+	Vars      map[string]interface{} `json:"vars,omitempty"` // the vars got from the record, to replay the input
+}
+
+// ShadowSink receive the mismatches, it is called in the goroutine of the evaluation, so it should be fast.
+// the error is counted in ShadowStats.SinkErrors.
+type ShadowSink interface {
+	Write(m *ShadowMismatch) error
+}
+
+// ShadowStats is the counters of a Shadow
+type ShadowStats struct {
+	Evals      int64 // the evaluations of the primary
+	Sampled    int64 // the evaluations of the candidate
+	Mismatches int64
+	SinkErrors int64
+}
+
+type ShadowOption func(s *Shadow)
+
+// evaluate the candidate for the rate of the inputs, from 0 to 1, default is 1, all the inputs.
+func WithShadowSampleRate(rate float64) ShadowOption {
+	return func(s *Shadow) {
+		s.sample = func() bool {
+			return rand.Float64() < rate
+		}
+	}
+}
+
+// decide whether to evaluate the candidate by the func, like sampling by the user id
+func WithShadowSampler(sample func() bool) ShadowOption {
+	return func(s *Shadow) {
+		s.sample = sample
+	}
+}
+
+// Shadow evaluate the primary program and a candidate with the same inputs, and always return the result of the primary.
+// the different value, type or error code of the candidate is written to the sink.
+// the candidate is evaluated after the primary in the same goroutine, the sampling limits the cost.
+// it is safe to evaluate in many goroutines.
+type Shadow struct {
+	primary   *Program
+	candidate *Program
+	sink      ShadowSink
+	sample    func() bool
+
+	evals      int64
+	sampled    int64
+	mismatches int64
+	sinkErrors int64
+}
+
+func NewShadow(primary *Program, candidate *Program, sink ShadowSink, opts ...ShadowOption) *Shadow {
+	s := &Shadow{primary: primary, candidate: candidate, sink: sink}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Eval evaluate the programs, the variables of the record are used first like EvalBatch, the record can be nil.
+func (s *Shadow) Eval(record VariableResolver) (*TokenNode, error) {
+	return s.EvalContext(context.Background(), record)
+}
+
+func (s *Shadow) EvalContext(ctx context.Context, record VariableResolver) (*TokenNode, error) {
+	atomic.AddInt64(&s.evals, 1)
+	if s.sample != nil && !s.sample() {
+		return s.primary.evalRecord(ctx, record)
+	}
+	atomic.AddInt64(&s.sampled, 1)
+
+	// the vars are resolved once, so the programs get the same values
+	shared := &sharedRecord{record: record, values: make(map[string]interface{}), missing: make(map[string]bool)}
+	value, err := s.primary.evalRecord(ctx, shared)
+	candidateValue, candidateErr := s.candidate.evalRecord(ctx, shared)
+
+	reason := s.compare(value, err, candidateValue, candidateErr)
+	if reason == "" {
+		return value, err
+	}
+	atomic.AddInt64(&s.mismatches, 1)
+	m := &ShadowMismatch{
+		Time:      time.Now(),
+		Reason:    reason,
+		Primary:   newShadowOutcome(value, err),
+		Candidate: newShadowOutcome(candidateValue, candidateErr),
+		Vars:      shared.values,
+	}
+	if s.sink != nil {
+		if sinkErr := s.sink.Write(m); sinkErr != nil {
+			atomic.AddInt64(&s.sinkErrors, 1)
+		}
+	}
+	return value, err
+}
+
+// the reason of the mismatch, empty if the results are the same
+func (s *Shadow) compare(x *TokenNode, xErr error, y *TokenNode, yErr error) string {
+	switch {
+	case xErr != nil || yErr != nil:
+		if xErr == nil || yErr == nil || errCode(xErr) != errCode(yErr) {
+			return "error"
+		}
+		return ""
+	case x.ValueType != y.ValueType:
+		return "type"
+	case !x.compare(y, s.primary.oper.floatEqual):
+		return "value"
+	}
+	return ""
+}
+
+// the code of EngineErr, -1 for the other errors
+func errCode(err error) int {
+	if engineErr, ok := err.(*EngineErr); ok {
+		return engineErr.ErrCode
+	}
+	return -1
+}
+
+func (s *Shadow) Stats() ShadowStats {
+	return ShadowStats{
+		Evals:      atomic.LoadInt64(&s.evals),
+		Sampled:    atomic.LoadInt64(&s.sampled),
+		Mismatches: atomic.LoadInt64(&s.mismatches),
+		SinkErrors: atomic.LoadInt64(&s.sinkErrors),
+	}
+}
+
+// evaluate with the record like EvalBatch
+func (prog *Program) evalRecord(ctx context.Context, record VariableResolver) (*TokenNode, error) {
+	e := newEvaluator(ctx, prog.oper)
+	e.record = record
+	res, err := e.eval(prog.root)
+	if err != nil {
+		return nil, err
+	}
+	return prog.oper.roundResult(res), nil
+}
+
+// sharedRecord keep the values got from the record, it is used by one evaluation at a time
+type sharedRecord struct {
+	record  VariableResolver
+	values  map[string]interface{}
+	missing map[string]bool // the vars not in the record, the variables of the Praser are used
+}
+
+func (r *sharedRecord) ResolveContext(ctx context.Context, name string) (interface{}, error) {
+	if value, ok := r.values[name]; ok {
+		return value, nil
+	}
+	if r.record == nil || r.missing[name] {
+		return nil, nil
+	}
+
+	var value interface{}
+	var err error
+	if ctxResolver, ok := r.record.(ContextVariableResolver); ok {
+		value, err = ctxResolver.ResolveContext(ctx, name)
+	} else {
+		value, err = r.record.Resolve(name)
+	}
+	if err == nil && value != nil {
+		r.values[name] = value
+	} else if err == nil {
+		r.missing[name] = true
+	}
+	return value, err
+}
+
+func (r *sharedRecord) Resolve(name string) (interface{}, error) {
+	return r.ResolveContext(context.Background(), name)
+}
+
+// MemoryShadowSink keep the latest mismatches in memory
+type MemoryShadowSink struct {
+	mu         sync.Mutex
+	limit      int
+	mismatches []*ShadowMismatch
+}
+
+// keep at most limit mismatches, the older ones are dropped, 0 means no limit
+func NewMemoryShadowSink(limit int) *MemoryShadowSink {
+	return &MemoryShadowSink{limit: limit}
+}
+
+func (m *MemoryShadowSink) Write(mismatch *ShadowMismatch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mismatches = append(m.mismatches, mismatch)
+	if m.limit > 0 && len(m.mismatches) > m.limit {
+		m.mismatches = append([]*ShadowMismatch(nil), m.mismatches[len(m.mismatches)-m.limit:]...)
+	}
+	return nil
+}
+
+// the mismatches kept, the oldest first
+func (m *MemoryShadowSink) Mismatches() []*ShadowMismatch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*ShadowMismatch(nil), m.mismatches...)
+}
+
+// JSONLShadowSink write a mismatch as a JSON line
+type JSONLShadowSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewJSONLShadowSink(w io.Writer) *JSONLShadowSink {
+	return &JSONLShadowSink{w: w}
+}
+
+// append the mismatches to the file, create it if not exist
+func OpenJSONLShadowSink(path string) (*JSONLShadowSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("open %v failed, %v", path, err))
+	}
+	return &JSONLShadowSink{w: f, closer: f}, nil
+}
+
+func (j *JSONLShadowSink) Write(m *ShadowMismatch) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(line, '\n'))
+	return err
+}
+
+// close the file opened by OpenJSONLShadowSink
+func (j *JSONLShadowSink) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+	return j.closer.Close()
+}
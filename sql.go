@@ -0,0 +1,189 @@
+package rule_engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SQLDialect is the SQL dialect used by ToSQL
+type SQLDialect int
+
+const (
+	SQLDialectMySQL SQLDialect = iota
+	SQLDialectPostgres
+)
+
+var sqlDialectNameDict = map[SQLDialect]string{
+	SQLDialectMySQL:    "mysql",
+	SQLDialectPostgres: "postgres",
+}
+
+var sqlOperDict = map[int]string{
+	'+': "+",
+	'-': "-",
+	'*': "*",
+	'/': "/",
+	'%': "%",
+	'>': ">",
+	'<': "<",
+	GE:  ">=",
+	LE:  "<=",
+	EQ:  "=",
+	NE:  "<>",
+	AND: "AND",
+	OR:  "OR",
+}
+
+// the built-in functions have the same meaning in SQL
+var sqlFuncDict = map[SQLDialect]map[string]string{
+	SQLDialectMySQL: {
+		"len":   "LENGTH",
+		"upper": "UPPER",
+		"lower": "LOWER",
+		"abs":   "ABS",
+		"min":   "LEAST",
+		"max":   "GREATEST",
+	},
+	SQLDialectPostgres: {
+		"len":   "OCTET_LENGTH",
+		"upper": "UPPER",
+		"lower": "LOWER",
+		"abs":   "ABS",
+		"min":   "LEAST",
+		"max":   "GREATEST",
+	},
+}
+
+type sqlTranslator struct {
+	dialect SQLDialect
+	columns map[string]string
+	args    []interface{}
+	errs    []string // the constructs can not be translated
+}
+
+// ToSQL translate the program to a parameterised SQL predicate, the values are in the args.
+// columns map the var path to the column name, the column name is used as it is.
+// all the constructs which can not be translated are reported in the error.
+//
+// the SQL may be different from the engine in some cases, like the integer division in MySQL,
+// and the regex syntax of the database.
+func (prog *Program) ToSQL(dialect SQLDialect, columns map[string]string) (string, []interface{}, error) {
+	if _, ok := sqlDialectNameDict[dialect]; !ok {
+		return "", nil, GetError(ErrRuleEngineInvalidParam, fmt.Sprintf("unknown sql dialect: %v", dialect))
+	}
+
+	t := &sqlTranslator{dialect: dialect, columns: columns}
+	sql := t.translate(prog.root)
+	if len(t.errs) > 0 {
+		return "", nil, GetError(ErrRuleEngineTranslate, strings.Join(t.errs, "; "))
+	}
+	return sql, t.args, nil
+}
+
+func (t *sqlTranslator) unsupported(n *astNode, format string, args ...interface{}) string {
+	t.errs = append(t.errs, fmt.Sprintf(format, args...)+fmt.Sprintf(", pos: %v", n.pos))
+	return ""
+}
+
+func (t *sqlTranslator) placeholder(value interface{}) string {
+	t.args = append(t.args, value)
+	if t.dialect == SQLDialectPostgres {
+		return fmt.Sprintf("$%v", len(t.args))
+	}
+	return "?"
+}
+
+// the functions translated to the operator, like LIKE
+var sqlOperatorFuncSet = map[string]bool{"startWith": true, "endWith": true, "regexMatch": true}
+
+// translate the child, add parentheses if it is an expression with operator
+func (t *sqlTranslator) translateChild(n *astNode) string {
+	s := t.translate(n)
+	if n.nodeType == astNodeBinary || n.nodeType == astNodeUnary ||
+		(n.nodeType == astNodeFunc && sqlOperatorFuncSet[n.funcName()]) {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func (t *sqlTranslator) translate(n *astNode) string {
+	switch n.nodeType {
+	case astNodeValue:
+		return t.placeholder(sqlValue(n.value))
+	case astNodeVar:
+		column, ok := t.columns[n.varName()]
+		if !ok {
+			return t.unsupported(n, "no column for var %v", n.varName())
+		}
+		return column
+	case astNodeUnary:
+		if n.oper == NOT {
+			return "NOT " + t.translateChild(n.children[0])
+		}
+		return "-" + t.translateChild(n.children[0])
+	case astNodeBinary:
+		return fmt.Sprintf("%v %v %v", t.translateChild(n.children[0]), sqlOperDict[n.oper], t.translateChild(n.children[1]))
+	case astNodeThirdOper:
+		// x if c else y
+		return fmt.Sprintf("CASE WHEN %v THEN %v ELSE %v END",
+			t.translate(n.children[1]), t.translate(n.children[0]), t.translate(n.children[2]))
+	case astNodeFunc:
+		return t.translateFunc(n)
+	}
+	return t.unsupported(n, "unknown node")
+}
+
+func (t *sqlTranslator) translateFunc(n *astNode) string {
+	switch n.funcName() {
+	case "startWith", "endWith":
+		if len(n.children) != 2 {
+			return t.unsupported(n, "%v need 2 args", n.funcName())
+		}
+		arg := n.children[1]
+		if arg.nodeType != astNodeValue || arg.value.ValueType != ValueTypeString {
+			return t.unsupported(n, "%v can only be translated with a string literal", n.funcName())
+		}
+		pattern := escapeLike(arg.value.GetString())
+		if n.funcName() == "startWith" {
+			pattern += "%"
+		} else {
+			pattern = "%" + pattern
+		}
+		// backslash is the default escape character of LIKE
+		return fmt.Sprintf("%v LIKE %v", t.translateChild(n.children[0]), t.placeholder(pattern))
+	case "regexMatch":
+		if len(n.children) != 2 {
+			return t.unsupported(n, "regexMatch need 2 args")
+		}
+		// translate s first, so the args are in the order of the placeholders
+		s := t.translateChild(n.children[1])
+		pattern := t.translate(n.children[0])
+		if t.dialect == SQLDialectPostgres {
+			return fmt.Sprintf("%v ~ %v", s, pattern)
+		}
+		return fmt.Sprintf("%v REGEXP %v", s, pattern)
+	}
+
+	sqlFunc, ok := sqlFuncDict[t.dialect][n.funcName()]
+	if !ok {
+		return t.unsupported(n, "func %v can not be translated to %v", n.funcName(), sqlDialectNameDict[t.dialect])
+	}
+	args := make([]string, len(n.children))
+	for i, child := range n.children {
+		args[i] = t.translate(child)
+	}
+	return fmt.Sprintf("%v(%v)", sqlFunc, strings.Join(args, ", "))
+}
+
+// the value passed to the database driver
+func sqlValue(t *TokenNode) interface{} {
+	switch t.ValueType {
+	case ValueTypeDecimal, ValueTypeBigInt:
+		return t.GetString()
+	}
+	return t.Value
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
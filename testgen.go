@@ -0,0 +1,525 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// TestCase is a generated input of the program and the result of it
+type TestCase struct {
+	Params []*Param   // the values of the vars, sorted by name
+	Value  *TokenNode // the expected result, nil if Err is not nil
+	Err    error
+	Covers []string // the goals first covered by the case, like "{{amount}} >= 1000 is true"
+}
+
+// TestSuite is the test cases generated for a program
+type TestSuite struct {
+	Source    string
+	Cases     []*TestCase
+	Uncovered []string // the goals no case can cover, like the condition always false
+}
+
+// GenerateTests generate the inputs on each side of the boundaries of the comparisons, like 999 and 1000
+// for {{amount}} >= 1000, and aim to make each condition both true and false, including the operands of and/or,
+// the and/or themselves and the condition of x if c else y, which decides the branch taken.
+// the var and the length of the var like len({{code}}) compared with the constants are solved,
+// the other vars get a default value by how they are used.
+//
+// the expected result of each case is calculated by the program, so the cases can be saved as golden tests.
+func (prog *Program) GenerateTests() *TestSuite {
+	g := &testGenerator{
+		prog: prog,
+		analyzer: &analyzer{
+			prog:     prog,
+			constant: newEvaluator(context.Background(), prog.oper),
+			printer:  &astPrinter{decimalMode: prog.oper.decimalMode},
+			conds:    make(map[*astNode]*condition),
+		},
+		subjects: make(map[string]*genSubject),
+		vars:     make(map[string]interface{}),
+	}
+	g.analyzer.constant.budget = evalBudget{}
+	g.collect(prog.root, true)
+	return g.generate()
+}
+
+// the GenerateTests of the str, see Program.GenerateTests
+func (p *Praser) GenerateTests(str string) (*TestSuite, error) {
+	prog, err := p.Compile(str)
+	if err != nil {
+		return nil, err
+	}
+	return prog.GenerateTests(), nil
+}
+
+// candidate is a value of the subject, num is used to check the number domain
+type candidate struct {
+	value interface{} // int64, float64, decimal.Decimal, string or bool
+	num   float64
+}
+
+// genSubject is a var or the length of a var, which can be solved
+type genSubject struct {
+	varName    string
+	isLen      bool
+	candidates []candidate
+}
+
+// testGoal is a condition node with the value wanted
+type testGoal struct {
+	node    *astNode
+	value   bool
+	formula formula
+	prefer  []candidate // the boundary of the comparison is tried first
+	subject *genSubject // the subject of the boundary
+	text    string
+	covered bool
+}
+
+type testGenerator struct {
+	prog     *Program
+	analyzer *analyzer
+	subjects map[string]*genSubject
+	vars     map[string]interface{} // the default values of the vars
+	goals    []*testGoal
+}
+
+// collect the subjects, the candidates and the goals, boolean means the node is used as a condition
+func (g *testGenerator) collect(n *astNode, boolean bool) {
+	if isVarCondition(n, boolean) {
+		g.addGoals(n)
+	}
+
+	switch n.nodeType {
+	case astNodeVar:
+		if boolean {
+			g.setDefault(n.varName(), false)
+			g.subjects[g.analyzer.printer.print(n)] = &genSubject{varName: n.varName(),
+				candidates: []candidate{{value: true}, {value: false}}}
+		} else {
+			g.setDefault(n.varName(), int64(0))
+		}
+	case astNodeUnary:
+		g.collect(n.children[0], n.oper == NOT)
+		return
+	case astNodeBinary:
+		if n.oper == AND || n.oper == OR {
+			g.collect(n.children[0], true)
+			g.collect(n.children[1], true)
+			return
+		}
+		if compareOperSet[n.oper] {
+			g.addAtom(n)
+		}
+	case astNodeThirdOper:
+		g.collect(n.children[0], boolean)
+		g.collect(n.children[1], true)
+		g.collect(n.children[2], boolean)
+		return
+	case astNodeFunc:
+		ft := funcTypeDict[n.funcName()]
+		for i, child := range n.children {
+			if child.nodeType == astNodeVar && len(ft.params) > 0 {
+				param := ft.params[len(ft.params)-1]
+				if i < len(ft.params) {
+					param = ft.params[i]
+				}
+				if strings.HasSuffix(param, " string") {
+					g.setDefault(child.varName(), "")
+				}
+			}
+		}
+	}
+	for _, child := range n.children {
+		g.collect(child, false)
+	}
+}
+
+// the first use decides the default value of the var
+func (g *testGenerator) setDefault(name string, value interface{}) {
+	if _, ok := g.vars[name]; !ok {
+		g.vars[name] = value
+	}
+}
+
+func (g *testGenerator) addGoals(n *astNode) {
+	text := g.analyzer.printer.print(n)
+	for _, goal := range g.goals {
+		if goal.text == text+" is true" {
+			return
+		}
+	}
+	c := g.analyzer.condition(n)
+	g.goals = append(g.goals,
+		&testGoal{node: n, value: true, formula: c.pos, text: text + " is true"},
+		&testGoal{node: n, value: false, formula: c.neg, text: text + " is false"})
+}
+
+// add the boundary of the comparison of a subject and a constant
+func (g *testGenerator) addAtom(n *astNode) {
+	subject, other := n.children[0], n.children[1]
+	if !hasVar(subject) {
+		subject, other = other, subject
+	}
+	if hasVar(other) {
+		return
+	}
+	value, err := g.analyzer.constant.eval(other)
+	if err != nil {
+		return
+	}
+
+	if subject.nodeType == astNodeThirdOper {
+		g.setBranchDefaults(subject, boundaryCandidates(value, false))
+		return
+	}
+
+	s := &genSubject{}
+	switch {
+	case subject.nodeType == astNodeVar:
+		s.varName = subject.varName()
+	case subject.nodeType == astNodeFunc && subject.funcName() == "len" && len(subject.children) == 1 &&
+		subject.children[0].nodeType == astNodeVar:
+		s.varName, s.isLen = subject.children[0].varName(), true
+	default:
+		return
+	}
+	key := g.analyzer.printer.print(subject)
+	if exist, ok := g.subjects[key]; ok {
+		s = exist
+	}
+	g.subjects[key] = s
+
+	bound := boundaryCandidates(value, s.isLen)
+	s.candidates = append(s.candidates, bound...)
+	for _, goal := range g.goals {
+		if goal.node == n {
+			goal.prefer, goal.subject = bound, s
+		}
+	}
+	if len(bound) == 0 {
+		return
+	}
+	if s.isLen {
+		g.setDefault(s.varName, "")
+	} else {
+		g.setDefault(s.varName, bound[0].value)
+	}
+}
+
+// the vars in the branches of x if c else y compared with a constant get the value of the same type,
+// x get the constant and y get the other one, so both results of the comparison can be reached
+func (g *testGenerator) setBranchDefaults(n *astNode, bound []candidate) {
+	if len(bound) == 0 {
+		return
+	}
+	for i, branch := range []*astNode{n.children[0], n.children[2]} {
+		switch branch.nodeType {
+		case astNodeVar:
+			g.setDefault(branch.varName(), bound[i%len(bound)].value)
+		case astNodeThirdOper:
+			g.setBranchDefaults(branch, bound)
+		}
+	}
+}
+
+// the constant and the values just on each side of it
+func boundaryCandidates(value *TokenNode, isLen bool) []candidate {
+	switch value.ValueType {
+	case ValueTypeInteger:
+		c := value.GetInt()
+		res := []candidate{{c, float64(c)}}
+		// skip the values overflow int64
+		if c > math.MinInt64 {
+			res = append(res, candidate{c - 1, float64(c - 1)})
+		}
+		if c < math.MaxInt64 {
+			res = append(res, candidate{c + 1, float64(c + 1)})
+		}
+		if isLen {
+			// the length can not be negative
+			for i := 0; i < len(res); i++ {
+				if res[i].num < 0 {
+					res = append(res[:i], res[i+1:]...)
+					i--
+				}
+			}
+		}
+		return res
+	case ValueTypeFloat, ValueTypeDecimal:
+		if isLen {
+			return nil
+		}
+		if value.ValueType == ValueTypeFloat && (math.IsNaN(value.GetFloat()) || math.IsInf(value.GetFloat(), 0)) {
+			return nil
+		}
+		d := value.GetDecimal()
+		// the step is the last digit of the constant, like 0.1 for 99.5
+		step := decimal.New(1, 0)
+		if d.Exponent() < 0 {
+			step = decimal.New(1, d.Exponent())
+		}
+		res := make([]candidate, 0, 3)
+		for _, v := range []decimal.Decimal{d, d.Sub(step), d.Add(step)} {
+			if value.ValueType == ValueTypeFloat {
+				res = append(res, candidate{v.InexactFloat64(), v.InexactFloat64()})
+			} else {
+				res = append(res, candidate{v, v.InexactFloat64()})
+			}
+		}
+		return res
+	case ValueTypeString:
+		s := value.GetString()
+		other := s + "_"
+		if s == "" {
+			other = "x"
+		}
+		return []candidate{{value: s}, {value: other}}
+	case ValueTypeBool:
+		return []candidate{{value: value.GetBool()}, {value: !value.GetBool()}}
+	}
+	return nil
+}
+
+func (g *testGenerator) generate() *TestSuite {
+	suite := &TestSuite{Source: g.prog.source}
+	for _, goal := range g.goals {
+		if goal.covered {
+			continue
+		}
+		if len(goal.formula) == 0 {
+			// never satisfied, see Analyze
+			continue
+		}
+		for _, t := range goal.formula {
+			values, ok := g.solve(t, goal)
+			if !ok {
+				continue
+			}
+			if tc := g.newCase(values); tc != nil {
+				suite.Cases = append(suite.Cases, tc)
+			}
+			if goal.covered {
+				break
+			}
+		}
+		// the comparison not reasoned about, like the integer beyond 2^53, try the boundary directly
+		for _, c := range goal.prefer {
+			if goal.covered {
+				break
+			}
+			values, _ := g.solve(term{}, nil)
+			goal.subject.assign(values, c)
+			if tc := g.newCase(values); tc != nil {
+				suite.Cases = append(suite.Cases, tc)
+			}
+		}
+	}
+	if len(suite.Cases) == 0 {
+		values, _ := g.solve(term{}, nil)
+		suite.Cases = append(suite.Cases, g.forceCase(values))
+	}
+
+	for _, goal := range g.goals {
+		if !goal.covered {
+			suite.Uncovered = append(suite.Uncovered, goal.text)
+		}
+	}
+	return suite
+}
+
+// the values of the vars satisfy the term, the vars not in the term get the default values.
+// false if no value of a subject is in its domain, like the length less than 0.
+func (g *testGenerator) solve(t term, goal *testGoal) (map[string]interface{}, bool) {
+	values := make(map[string]interface{}, len(g.vars))
+	for name, value := range g.vars {
+		values[name] = value
+	}
+
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s, ok := g.subjects[key]
+		if !ok {
+			// can not be solved, like {{a}} + {{b}} > 1
+			continue
+		}
+		var prefer []candidate
+		if goal != nil {
+			prefer = goal.prefer
+		}
+		c, ok := pickCandidate(t[key], append(append([]candidate(nil), prefer...), s.candidates...), s.isLen)
+		if !ok {
+			return nil, false
+		}
+		s.assign(values, c)
+	}
+	return values, true
+}
+
+// set the value of the subject, the length is set by a string of the length
+func (s *genSubject) assign(values map[string]interface{}, c candidate) {
+	if s.isLen {
+		values[s.varName] = strings.Repeat("a", int(c.value.(int64)))
+	} else {
+		values[s.varName] = c.value
+	}
+}
+
+// the first candidate in the domain, or a value made from the bounds of the domain.
+// isLen means only the integers not less than 0 can be picked.
+func pickCandidate(d *domain, candidates []candidate, isLen bool) (candidate, bool) {
+	for _, c := range candidates {
+		if n, ok := c.value.(int64); isLen && (!ok || n < 0) {
+			continue
+		}
+		if d.contains(c) {
+			return c, true
+		}
+	}
+
+	switch {
+	case d.kind == domainNumber && isLen:
+		// the whole numbers from the lower bound, which is at least 0
+		v := math.Max(math.Ceil(d.lo), 0)
+		if v == d.lo && d.loOpen {
+			v++
+		}
+		for i := 0; i < 8 && v <= d.hi && v < math.MaxInt64; i++ {
+			if c := (candidate{value: int64(v), num: v}); d.contains(c) {
+				return c, true
+			}
+			v++
+		}
+	case d.kind == domainNumber:
+		v := 0.0
+		switch {
+		case !math.IsInf(d.lo, 0) && !math.IsInf(d.hi, 0):
+			v = (d.lo + d.hi) / 2
+		case !math.IsInf(d.lo, 0):
+			v = math.Floor(d.lo) + 1
+		case !math.IsInf(d.hi, 0):
+			v = math.Ceil(d.hi) - 1
+		}
+		for i := 0; i < 8; i++ {
+			c := candidate{value: v, num: v}
+			if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+				c.value = int64(v)
+			}
+			if d.contains(c) {
+				return c, true
+			}
+			v++
+		}
+	case d.kind == domainString:
+		if d.eq != nil {
+			return candidate{value: *d.eq}, true
+		}
+		for s := "x"; ; s += "x" {
+			if !d.ne[s] {
+				return candidate{value: s}, true
+			}
+		}
+	case d.kind == domainBool:
+		for _, b := range []bool{false, true} {
+			if c := (candidate{value: b}); d.contains(c) {
+				return c, true
+			}
+		}
+	}
+	return candidate{}, false
+}
+
+// the candidate is in the domain
+func (d *domain) contains(c candidate) bool {
+	switch d.kind {
+	case domainNumber:
+		switch c.value.(type) {
+		case int64, float64, decimal.Decimal:
+		default:
+			return false
+		}
+		if c.num < d.lo || c.num == d.lo && d.loOpen || c.num > d.hi || c.num == d.hi && d.hiOpen {
+			return false
+		}
+		for _, r := range d.neRanges {
+			if r.contains(c.num) {
+				return false
+			}
+		}
+		return true
+	case domainString:
+		s, ok := c.value.(string)
+		return ok && (d.eq == nil || *d.eq == s) && !d.ne[s]
+	case domainBool:
+		b, ok := c.value.(bool)
+		s := fmt.Sprint(b)
+		return ok && (d.eq == nil || *d.eq == s) && !d.ne[s]
+	}
+	return false
+}
+
+// evaluate the goals by the values, return the case if any goal is first covered
+func (g *testGenerator) newCase(values map[string]interface{}) *TestCase {
+	tc := &TestCase{}
+	e := g.evaluator(values)
+	for _, goal := range g.goals {
+		if goal.covered {
+			continue
+		}
+		e.reset()
+		value, err := e.eval(goal.node)
+		if err == nil && value.ValueType == ValueTypeBool && value.GetBool() == goal.value {
+			goal.covered = true
+			tc.Covers = append(tc.Covers, goal.text)
+		}
+	}
+	if len(tc.Covers) == 0 {
+		return nil
+	}
+	g.fillCase(tc, values)
+	return tc
+}
+
+// the case without goals, when the program has no condition
+func (g *testGenerator) forceCase(values map[string]interface{}) *TestCase {
+	tc := &TestCase{}
+	g.fillCase(tc, values)
+	return tc
+}
+
+func (g *testGenerator) fillCase(tc *TestCase, values map[string]interface{}) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tc.Params = append(tc.Params, GetParam(name, values[name]))
+	}
+
+	value, err := g.evaluator(values).eval(g.prog.root)
+	if err != nil {
+		tc.Err = err
+		return
+	}
+	tc.Value = g.prog.oper.roundResult(value)
+}
+
+// the evaluator get the vars from the values first
+func (g *testGenerator) evaluator(values map[string]interface{}) *evaluator {
+	e := newEvaluator(context.Background(), g.prog.oper)
+	e.record = VariableResolverFunc(func(name string) (interface{}, error) {
+		return values[name], nil
+	})
+	return e
+}
@@ -0,0 +1,126 @@
+package rule_engine
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TokenKind int
+
+const (
+	TokenKindKeyword  TokenKind = iota + 1 // and, or, not, if, else, true, false
+	TokenKindOperator                      // + - * / % > < >= <= == != && || ! ( ) , .
+	TokenKindNumber                        // 1, 0x1f, 1.5e3
+	TokenKindString                        // "str", 'str'
+	TokenKindVariable                      // {{a.b}}, the braces are included
+	TokenKindFunction                      // the name of the func, like len in len(s)
+	TokenKindError                         // the text can not be a token, like @ or an unterminated string
+)
+
+var tokenKindNameDict = map[TokenKind]string{
+	TokenKindKeyword:  "keyword",
+	TokenKindOperator: "operator",
+	TokenKindNumber:   "number",
+	TokenKindString:   "string",
+	TokenKindVariable: "variable",
+	TokenKindFunction: "function",
+	TokenKindError:    "error",
+}
+
+func (k TokenKind) String() string {
+	return tokenKindNameDict[k]
+}
+
+// Token is a token in the source, Pos and End are the byte offset of the token
+type Token struct {
+	Kind TokenKind
+	Pos  int
+	End  int
+	Text string
+}
+
+// Tokenize split the source to tokens by the same rules as the lexer, the white spaces are skipped.
+// it never fails, the text can not be a token becomes TokenKindError, so the half-typed input can be tokenized.
+// the unclosed var like "{{user." is a TokenKindVariable.
+func Tokenize(str string) []Token {
+	var res []Token
+	lex := &RuleEngineLex{}
+	for pos := 0; pos < len(str); {
+		r, size := utf8.DecodeRuneInString(str[pos:])
+		if unicode.IsSpace(r) {
+			pos += size
+			continue
+		}
+
+		kind, end := TokenKindError, pos+size
+		switch {
+		case strings.HasPrefix(str[pos:], "{{"):
+			kind, end = TokenKindVariable, scanVarToken(str, pos)
+		case r == '"' || r == '\'':
+			if token, matchStr := lex.matchRule(str[pos:]); token == STRING {
+				kind, end = TokenKindString, pos+len(matchStr)
+			} else {
+				// unterminated string, skip to the end of the line
+				if i := strings.IndexByte(str[pos:], '\n'); i >= 0 {
+					end = pos + i
+				} else {
+					end = len(str)
+				}
+			}
+		default:
+			if token, matchStr := lex.matchRule(str[pos:]); matchStr != "" {
+				kind, end = matchTokenKind(str, token, matchStr, pos+len(matchStr)), pos+len(matchStr)
+			} else if _, ok := VALID_CHAR_SET[r]; ok {
+				kind = TokenKindOperator
+			}
+		}
+		res = append(res, Token{Kind: kind, Pos: pos, End: end, Text: str[pos:end]})
+		pos = end
+	}
+	return res
+}
+
+// the end of the var starts at pos, the trailing white spaces are not included if the var is not closed
+func scanVarToken(str string, pos int) int {
+	end := pos + 2
+	for end < len(str) {
+		if strings.HasPrefix(str[end:], "}}") {
+			return end + 2
+		}
+		c := str[end]
+		if !(isVarPathChar(c) || c == ' ' || c == '\t' || c == '\r' || c == '\n') {
+			break
+		}
+		end++
+	}
+	return pos + len(strings.TrimRightFunc(str[pos:end], unicode.IsSpace))
+}
+
+func isVarPathChar(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func matchTokenKind(str string, token int, matchStr string, end int) TokenKind {
+	switch token {
+	case INTEGER, FLOAT:
+		return TokenKindNumber
+	case IDENTIFIER:
+		// the func call, or the built-in func name without the parenthesis yet
+		if _, ok := funcMap[matchStr]; ok || strings.HasPrefix(strings.TrimLeftFunc(str[end:], unicode.IsSpace), "(") {
+			return TokenKindFunction
+		}
+		return TokenKindError
+	case IDRIGHT:
+		return TokenKindError
+	case AND, OR, NOT:
+		// && || ! are operators, and or not are keywords
+		if !isVarPathChar(matchStr[0]) {
+			return TokenKindOperator
+		}
+		return TokenKindKeyword
+	case TRUE, FALSE, IF, ELSE:
+		return TokenKindKeyword
+	}
+	return TokenKindOperator
+}
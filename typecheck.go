@@ -0,0 +1,347 @@
+package rule_engine
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// the type names used in the signature of the built-in functions
+var typeSetDict = map[string][]ValueType{
+	"any":            nil,
+	"bool":           {ValueTypeBool},
+	"string":         {ValueTypeString},
+	"integer":        {ValueTypeInteger, ValueTypeBigInt},
+	"number":         operValidType[operTypeMath],
+	"number|string":  operValidType[operTypeChangeTo],
+	"integer|string": {ValueTypeInteger, ValueTypeBigInt, ValueTypeString},
+}
+
+// funcType is the static type of the built-in function
+type funcType struct {
+	params   []string // like "s string", the type is the key of typeSetDict
+	minArgs  int      // the least count of args if variadic
+	variadic bool     // the last param can be repeated
+	result   string   // the result type, "number" means the promoted type of the args
+	doc      string
+}
+
+var funcTypeDict = map[string]funcType{
+	"len":          {params: []string{"s string"}, result: "integer", doc: "length of the string"},
+	"min":          {params: []string{"x number", "y number"}, minArgs: 2, variadic: true, result: "number", doc: "min of the args"},
+	"max":          {params: []string{"x number", "y number"}, minArgs: 2, variadic: true, result: "number", doc: "max of the args"},
+	"abs":          {params: []string{"x number"}, result: "number", doc: "absolute value of the number"},
+	"upper":        {params: []string{"s string"}, result: "string", doc: "upper of the string"},
+	"lower":        {params: []string{"s string"}, result: "string", doc: "lower of the string"},
+	"startWith":    {params: []string{"s string", "prefix string"}, result: "bool", doc: "check string start with some prefix"},
+	"endWith":      {params: []string{"s string", "suffix string"}, result: "bool", doc: "check string end with some suffix"},
+	"regexMatch":   {params: []string{"pattern string", "s string"}, result: "bool", doc: "check s contains a match of the regex"},
+	"regexFind":    {params: []string{"pattern string", "s string"}, result: "string", doc: "first match of the regex"},
+	"regexFindAll": {params: []string{"pattern string", "s string", "n integer"}, result: "string", doc: "n-th match of the regex"},
+	"regexReplace": {params: []string{"pattern string", "s string", "repl string"}, result: "string", doc: "replace the matches of the regex"},
+	"regexGroups":  {params: []string{"pattern string", "s string", "group integer|string"}, result: "string", doc: "capture group of the regex"},
+	"int":          {params: []string{"x number|string"}, result: "integer", doc: "change arg to int type"},
+	"float":        {params: []string{"x number|string"}, result: "float", doc: "change arg to float type"},
+	"decimal":      {params: []string{"x number|string"}, result: "decimal", doc: "change arg to decimal type"},
+	"string":       {params: []string{"x number|string"}, result: "string", doc: "change arg to string type"},
+	"isNaN":        {params: []string{"x number"}, result: "bool", doc: "check float is NaN"},
+	"isInf":        {params: []string{"x number"}, result: "bool", doc: "check float is +Inf or -Inf"},
+	"concat":       {params: []string{"s string"}, variadic: true, result: "string", doc: "join the strings"},
+}
+
+// FuncSignature is the signature of the built-in function, like len(s string) integer
+type FuncSignature struct {
+	Name   string
+	Label  string   // the whole signature
+	Params []string // the label of the params, like "s string", the variadic param is like "y ...number"
+	Result string
+	Doc    string
+}
+
+// BuiltinFuncs return the signature of all the built-in functions, sorted by name
+func BuiltinFuncs() []FuncSignature {
+	res := make([]FuncSignature, 0, len(funcMap))
+	for name := range funcMap {
+		res = append(res, funcSignature(name))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// GetFuncSignature return the signature of the built-in function
+func GetFuncSignature(name string) (FuncSignature, bool) {
+	if _, ok := funcMap[name]; !ok {
+		return FuncSignature{}, false
+	}
+	return funcSignature(name), true
+}
+
+func funcSignature(name string) FuncSignature {
+	ft := funcTypeDict[name]
+	params := make([]string, len(ft.params))
+	copy(params, ft.params)
+	if ft.variadic {
+		last := strings.SplitN(params[len(params)-1], " ", 2)
+		params[len(params)-1] = last[0] + " ..." + last[1]
+	}
+	return FuncSignature{
+		Name:   name,
+		Label:  fmt.Sprintf("%v(%v) %v", name, strings.Join(params, ", "), ft.result),
+		Params: params,
+		Result: ft.result,
+		Doc:    ft.doc,
+	}
+}
+
+// the type of the param at index i, the variadic param is repeated
+func (ft funcType) paramType(i int) string {
+	if i >= len(ft.params) {
+		i = len(ft.params) - 1
+	}
+	return strings.SplitN(ft.params[i], " ", 2)[1]
+}
+
+// Diagnostic is a problem found in the source, Pos and End are the byte offset of the problem.
+type Diagnostic struct {
+	Pos     int
+	End     int
+	Code    int // the error code, like ErrRuleEngineSyntaxError
+	Message string
+}
+
+// TypeInfo is the static type of a sub expression in the source
+type TypeInfo struct {
+	Pos  int
+	End  int
+	Type ValueType // ValueTypeNone if the type is unknown
+	Var  string    // the var name if the expression is a var
+	Func string    // the func name if the expression is a func call
+}
+
+// TypeCheckResult is the result of TypeCheck
+type TypeCheckResult struct {
+	Type        ValueType  // the type of the whole expression
+	Types       []TypeInfo // the type of all the sub expressions, the children are before the parent
+	Diagnostics []Diagnostic
+}
+
+// TypeAt return the type of the innermost sub expression contains the byte offset pos
+func (r *TypeCheckResult) TypeAt(pos int) (TypeInfo, bool) {
+	var res TypeInfo
+	found := false
+	for _, info := range r.Types {
+		if info.Pos <= pos && pos < info.End && (!found || info.End-info.Pos < res.End-res.Pos) {
+			res, found = info, true
+		}
+	}
+	return res, found
+}
+
+// TypeCheck parse the source and infer the type of the expression without evaluation.
+// the type of the var is got from the schema, then the params of the praser, ValueTypeNone in schema means any type.
+// the syntax errors and the type errors are reported in the diagnostics.
+func (p *Praser) TypeCheck(str string, schema map[string]ValueType) *TypeCheckResult {
+	res := &TypeCheckResult{}
+	prog, errs, err := p.compile(str)
+	if err != nil {
+		for _, e := range errs {
+			res.Diagnostics = append(res.Diagnostics, Diagnostic{Pos: e.pos, End: e.end, Code: e.err.ErrCode,
+				Message: diagnosticMessage(e.err.ErrMsg)})
+		}
+		return res
+	}
+
+	c := &typeChecker{oper: p.operator, schema: schema, res: res}
+	res.Type = c.check(prog.root)
+	return res
+}
+
+var errPosRegexp = regexp.MustCompile(`, pos: [0-9]+$`)
+
+// the position is in the diagnostic, remove it and the source printed in the syntax error
+func diagnosticMessage(msg string) string {
+	msg = strings.SplitN(msg, "\n", 2)[0]
+	return errPosRegexp.ReplaceAllString(msg, "")
+}
+
+type typeChecker struct {
+	oper   *TokenOperator
+	schema map[string]ValueType
+	res    *TypeCheckResult
+}
+
+func (c *typeChecker) report(n *astNode, code int, format string, args ...interface{}) {
+	c.res.Diagnostics = append(c.res.Diagnostics, Diagnostic{Pos: n.pos, End: n.end, Code: code,
+		Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *typeChecker) check(n *astNode) ValueType {
+	t := c.checkNode(n)
+	info := TypeInfo{Pos: n.pos, End: n.end, Type: t}
+	switch n.nodeType {
+	case astNodeVar:
+		info.Var = n.varName()
+	case astNodeFunc:
+		info.Func = n.funcName()
+	}
+	c.res.Types = append(c.res.Types, info)
+	return t
+}
+
+// check the operand is one of the types, the unknown type is always valid
+func (c *typeChecker) expect(n *astNode, t ValueType, typeName string, operName string) bool {
+	types := typeSetDict[typeName]
+	if t == ValueTypeNone || types == nil {
+		return true
+	}
+	for _, valid := range types {
+		if t == valid {
+			return true
+		}
+	}
+	c.report(n, ErrRuleEngineInvalidOperation, "%v needs %v, but get %v", operName, typeName, t)
+	return false
+}
+
+func (c *typeChecker) checkNode(n *astNode) ValueType {
+	switch n.nodeType {
+	case astNodeValue:
+		return n.value.ValueType
+	case astNodeVar:
+		return c.varType(n)
+	case astNodeUnary:
+		t := c.check(n.children[0])
+		if n.oper == NOT {
+			c.expect(n.children[0], t, "bool", "not")
+			return ValueTypeBool
+		}
+		if !c.expect(n.children[0], t, "number", "-") {
+			return ValueTypeNone
+		}
+		return t
+	case astNodeBinary:
+		return c.checkBinary(n, c.check(n.children[0]), c.check(n.children[1]))
+	case astNodeThirdOper:
+		x, cond, y := c.check(n.children[0]), c.check(n.children[1]), c.check(n.children[2])
+		c.expect(n.children[1], cond, "bool", "if")
+		if x == y {
+			return x
+		}
+		return ValueTypeNone
+	case astNodeFunc:
+		return c.checkFunc(n)
+	}
+	return ValueTypeNone
+}
+
+func (c *typeChecker) varType(n *astNode) ValueType {
+	if t, ok := c.schema[n.varName()]; ok {
+		return t
+	}
+	if v, ok := c.oper.varMap[n.varName()]; ok {
+		return v.ValueType
+	}
+	if c.oper.resolver == nil {
+		c.report(n, ErrRuleEngineUnknownVarName, "unknown var %v", n.varName())
+	}
+	return ValueTypeNone
+}
+
+func (c *typeChecker) checkBinary(n *astNode, x ValueType, y ValueType) ValueType {
+	operName := operNameDict[n.oper]
+	switch n.oper {
+	case AND, OR:
+		c.expect(n.children[0], x, "bool", operName)
+		c.expect(n.children[1], y, "bool", operName)
+		return ValueTypeBool
+	case '<', '>', LE, GE:
+		c.expect(n.children[0], x, "number", operName)
+		c.expect(n.children[1], y, "number", operName)
+		return ValueTypeBool
+	case EQ, NE:
+		if x != ValueTypeNone && y != ValueTypeNone && x != y &&
+			(x == ValueTypeBool || y == ValueTypeBool || x == ValueTypeString || y == ValueTypeString) {
+			c.report(n, ErrRuleEngineInvalidOperation, "can not compare %v with %v", x, y)
+		}
+		return ValueTypeBool
+	case '%':
+		okX := c.expect(n.children[0], x, "integer", operName)
+		okY := c.expect(n.children[1], y, "integer", operName)
+		if !okX || !okY {
+			return ValueTypeNone
+		}
+		return c.mathType(x, y)
+	}
+	okX := c.expect(n.children[0], x, "number", operName)
+	okY := c.expect(n.children[1], y, "number", operName)
+	if !okX || !okY {
+		return ValueTypeNone
+	}
+	return c.mathType(x, y)
+}
+
+// the result type of the math operation, the same as the rules in node_operation.go
+func (c *typeChecker) mathType(x ValueType, y ValueType) ValueType {
+	isInteger := func(t ValueType) bool { return t == ValueTypeInteger || t == ValueTypeBigInt }
+	switch {
+	case x == ValueTypeNone || y == ValueTypeNone:
+		return ValueTypeNone
+	case isInteger(x) && isInteger(y):
+		if x == ValueTypeBigInt || y == ValueTypeBigInt {
+			return ValueTypeBigInt
+		}
+		return ValueTypeInteger
+	case c.oper.decimalMode || x == ValueTypeDecimal || y == ValueTypeDecimal || x == ValueTypeBigInt || y == ValueTypeBigInt:
+		return ValueTypeDecimal
+	}
+	return ValueTypeFloat
+}
+
+func (c *typeChecker) checkFunc(n *astNode) ValueType {
+	args := make([]ValueType, len(n.children))
+	for i, child := range n.children {
+		args[i] = c.check(child)
+	}
+
+	name := n.funcName()
+	ft := funcTypeDict[name]
+	if ft.variadic && len(args) < ft.minArgs {
+		c.report(n, ErrRuleEngineFuncArgument, "%v takes at least %v args, but give %v", name, ft.minArgs, len(args))
+		return ValueTypeNone
+	}
+	if !ft.variadic && len(args) != len(ft.params) {
+		c.report(n, ErrRuleEngineFuncArgument, "%v takes %v args, but give %v", name, len(ft.params), len(args))
+		return ValueTypeNone
+	}
+
+	valid := true
+	for i, t := range args {
+		if !c.expect(n.children[i], t, ft.paramType(i), fmt.Sprintf("arg %v of %v", i+1, name)) {
+			valid = false
+		}
+	}
+
+	switch ft.result {
+	case "number":
+		if !valid || len(args) == 0 {
+			return ValueTypeNone
+		}
+		res := args[0]
+		for _, t := range args[1:] {
+			res = c.mathType(res, t)
+		}
+		return res
+	case "bool":
+		return ValueTypeBool
+	case "string":
+		return ValueTypeString
+	case "integer":
+		return ValueTypeInteger
+	case "float":
+		return ValueTypeFloat
+	case "decimal":
+		return ValueTypeDecimal
+	}
+	return ValueTypeNone
+}
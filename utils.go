@@ -3,13 +3,87 @@ package rule_engine
 import (
 	"fmt"
 	"math"
+	"math/big"
 	"reflect"
 
 	"github.com/shopspring/decimal"
 )
 
-func isFloatEqual(x, y float64) bool {
-	return math.Abs(x-y) < 0.00000000001
+// the strategy to check whether two float are equal
+type floatEqual struct {
+	mode    FloatEqualMode
+	epsilon float64
+	ulps    uint64
+}
+
+var defaultFloatEqual = floatEqual{mode: FloatEqualAbsolute, epsilon: defaultFloatEpsilon}
+
+// NaN is not equal to anything, include itself. +Inf and -Inf only equal to themselves.
+func (f floatEqual) equal(x, y float64) bool {
+	if x == y {
+		return true
+	}
+	if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return false
+	}
+
+	switch f.mode {
+	case FloatEqualExact:
+		return false
+	case FloatEqualRelative:
+		return math.Abs(x-y) <= f.epsilon*math.Max(math.Abs(x), math.Abs(y))
+	case FloatEqualULP:
+		return floatULPDistance(x, y) <= f.ulps
+	}
+	return math.Abs(x-y) < f.epsilon
+}
+
+// map the float bits to int64 which keeps the order of the float, -0 and +0 are both 0
+func orderedFloatBits(f float64) int64 {
+	b := int64(math.Float64bits(f))
+	if b < 0 {
+		b = math.MinInt64 - b
+	}
+	return b
+}
+
+// the float of the bits from orderedFloatBits
+func orderedFloatFromBits(b int64) float64 {
+	if b < 0 {
+		b = math.MinInt64 - b
+	}
+	return math.Float64frombits(uint64(b))
+}
+
+func floatULPDistance(x, y float64) uint64 {
+	ix, iy := orderedFloatBits(x), orderedFloatBits(y)
+	if ix < iy {
+		ix, iy = iy, ix
+	}
+	return uint64(ix) - uint64(iy)
+}
+
+// NaN and Inf can not be changed to decimal
+func isNonFiniteFloat(t *TokenNode) bool {
+	if t.ValueType != ValueTypeFloat {
+		return false
+	}
+	f := t.GetFloat()
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
+
+func isZeroValue(t *TokenNode) bool {
+	switch t.ValueType {
+	case ValueTypeInteger:
+		return t.GetInt() == 0
+	case ValueTypeFloat:
+		return t.GetFloat() == 0
+	case ValueTypeDecimal:
+		return t.GetDecimal().IsZero()
+	case ValueTypeBigInt:
+		return t.GetBigInt().Sign() == 0
+	}
+	return false
 }
 
 func checkOperType(t *TokenNode, oper_type operType, oper_name string) error {
@@ -78,11 +152,96 @@ func intAbs(x int64) int64 {
 	return -x
 }
 
+// the int64 operations below return false if the result overflow
+
+func intAdd(x int64, y int64) (int64, bool) {
+	res := x + y
+	return res, (res > x) == (y > 0)
+}
+
+func intSub(x int64, y int64) (int64, bool) {
+	res := x - y
+	return res, (res < x) == (y > 0)
+}
+
+func intMul(x int64, y int64) (int64, bool) {
+	if x == 0 || y == 0 {
+		return 0, true
+	}
+	res := x * y
+	if (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+		return res, false
+	}
+	return res, res/y == x
+}
+
+func intDiv(x int64, y int64) (int64, bool) {
+	if x == math.MinInt64 && y == -1 {
+		return x, false
+	}
+	return x / y, true
+}
+
+func intMod(x int64, y int64) (int64, bool) {
+	return x % y, true
+}
+
+func isIntegerType(t *TokenNode) bool {
+	return t.ValueType == ValueTypeInteger || t.ValueType == ValueTypeBigInt
+}
+
+// divide x by y, keep precision digits after the decimal point and round by mode.
+// the result is exact before rounding, so there is no double rounding.
+func divDecimal(x, y decimal.Decimal, precision int32, mode RoundingMode) decimal.Decimal {
+	q, r := x.QuoRem(y, precision)
+	if r.IsZero() {
+		return q
+	}
+
+	sign := x.Sign() * y.Sign()
+	// compare 2 * |r| * 10^precision with |y| to know the position of the remainder
+	half := r.Abs().Mul(decimal.NewFromInt(2)).Shift(precision).Cmp(y.Abs())
+
+	awayFromZero := false
+	switch mode {
+	case RoundHalfUp:
+		awayFromZero = half >= 0
+	case RoundHalfEven:
+		awayFromZero = half > 0 || (half == 0 && q.Shift(precision).BigInt().Bit(0) == 1)
+	case RoundDown:
+		awayFromZero = false
+	case RoundUp:
+		awayFromZero = true
+	case RoundCeiling:
+		awayFromZero = sign > 0
+	case RoundFloor:
+		awayFromZero = sign < 0
+	}
+
+	if !awayFromZero {
+		return q
+	}
+	if sign < 0 {
+		return q.Sub(decimal.New(1, -precision))
+	}
+	return q.Add(decimal.New(1, -precision))
+}
+
+// round d to scale digits after the decimal point by mode.
+func roundDecimal(d decimal.Decimal, scale int32, mode RoundingMode) decimal.Decimal {
+	return divDecimal(d, decimal.NewFromInt(1), scale, mode)
+}
+
 func getArgNumberError(needArg int, giveArg int) error {
 	return GetError(ErrRuleEngineFuncArgument, fmt.Sprintf("func can only handle %v arg, but give %v", needArg, giveArg))
 }
 
-func parseParam(useDecimal bool, param *Param) (*TokenNode, error) {
+func (o *TokenOperator) parseParam(param *Param) (*TokenNode, error) {
+	useDecimal := o.decimalMode
+	if bigValue, ok := param.Value.(*big.Int); ok && bigValue != nil {
+		return o.parseBigIntParam(param, bigValue)
+	}
+
 	rt := reflect.ValueOf(param.Value)
 	if !rt.IsValid() {
 		return nil, GetError(ErrRuleEngineInvalidParam, "not valid")
@@ -113,17 +272,20 @@ func parseParam(useDecimal bool, param *Param) (*TokenNode, error) {
 			resType, resValue = ValueTypeDecimal, decimal.NewFromInt(rt.Int())
 		case ValueTypeFloat:
 			resType, resValue = ValueTypeFloat, float64(rt.Int())
+		case ValueTypeBigInt:
+			resType, resValue = ValueTypeBigInt, big.NewInt(rt.Int())
 		default:
 			return nil, notMatchErr
 		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bigValue := new(big.Int).SetUint64(rt.Uint())
 		switch param.Type {
-		case ValueTypeNone, ValueTypeInteger:
-			resType, resValue = ValueTypeInteger, int64(rt.Uint())
+		case ValueTypeNone, ValueTypeInteger, ValueTypeBigInt:
+			return o.parseBigIntParam(param, bigValue)
 		case ValueTypeFloat:
 			resType, resValue = ValueTypeFloat, float64(rt.Uint())
 		case ValueTypeDecimal:
-			resType, resValue = ValueTypeDecimal, decimal.NewFromInt(int64(rt.Uint()))
+			resType, resValue = ValueTypeDecimal, decimal.NewFromBigInt(bigValue, 0)
 		default:
 			return nil, notMatchErr
 		}
@@ -142,18 +304,29 @@ func parseParam(useDecimal bool, param *Param) (*TokenNode, error) {
 				return nil, GetError(ErrRuleEngineDecimalError, fmt.Sprintf("msg: %v", err))
 			}
 			resType, resValue = ValueTypeDecimal, decimalValue
+		case ValueTypeBigInt:
+			bigValue, ok := new(big.Int).SetString(rt.String(), 0)
+			if !ok {
+				return nil, notMatchErr
+			}
+			resType, resValue = ValueTypeBigInt, bigValue
 		default:
 			return nil, notMatchErr
 		}
 	case reflect.Float32, reflect.Float64:
+		// decimal can not keep NaN and Inf
+		nonFinite := math.IsNaN(rt.Float()) || math.IsInf(rt.Float(), 0)
 		switch param.Type {
 		case ValueTypeNone, ValueTypeFloat:
-			if useDecimal {
+			if useDecimal && !nonFinite {
 				resType, resValue = ValueTypeDecimal, decimal.NewFromFloat(rt.Float())
 			} else {
 				resType, resValue = ValueTypeFloat, rt.Float()
 			}
 		case ValueTypeDecimal:
+			if nonFinite {
+				return nil, GetError(ErrRuleEngineDecimalError, fmt.Sprintf("can not change %v to decimal", rt.Float()))
+			}
 			resType, resValue = ValueTypeDecimal, decimal.NewFromFloat(rt.Float())
 		default:
 			return nil, notMatchErr
@@ -170,3 +343,19 @@ func parseParam(useDecimal bool, param *Param) (*TokenNode, error) {
 	}
 	return GetTokenNode(resType, resValue), nil
 }
+
+func (o *TokenOperator) parseBigIntParam(param *Param, value *big.Int) (*TokenNode, error) {
+	switch param.Type {
+	case ValueTypeNone, ValueTypeInteger:
+		return o.bigIntResult(value, fmt.Sprintf("param %v", param.Name))
+	case ValueTypeBigInt:
+		return GetTokenNode(ValueTypeBigInt, new(big.Int).Set(value)), nil
+	case ValueTypeFloat:
+		f, _ := new(big.Float).SetInt(value).Float64()
+		return GetTokenNode(ValueTypeFloat, f), nil
+	case ValueTypeDecimal:
+		return GetTokenNode(ValueTypeDecimal, decimal.NewFromBigInt(value, 0)), nil
+	}
+	return nil, GetError(ErrRuleEngineParamValueTypeNotMatch,
+		fmt.Sprintf("value: %v, type: %v", param.Value, valueTypeNameDict[param.Type]))
+}
@@ -0,0 +1,367 @@
+package rule_engine
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+type opcode uint8
+
+const (
+	opConst     opcode = iota // push consts[arg]
+	opVar                     // push the var vars[arg]
+	opUnary                   // the operator is arg
+	opBinary                  // the operator is arg
+	opThirdOper               // pop x, c, y, push x if c else y
+	opCall                    // call funcNames[arg] with argc args
+)
+
+type instruction struct {
+	op   opcode
+	argc uint32
+	arg  int32
+}
+
+// vmValue is the value on the stack of the VM, int, float, bool and string are kept without allocation
+type vmValue struct {
+	typ ValueType
+	b   bool
+	i   int64
+	f   float64
+	s   string
+	ref interface{} // decimal.Decimal, *big.Int
+}
+
+// VMProgram is the program lowered to bytecode, which is evaluated by a stack VM.
+// it has the same result as Program.Eval, but is faster and allocates less.
+type VMProgram struct {
+	code      []instruction
+	consts    []vmValue
+	vars      []*TokenNode // the var names
+	funcNames []string
+	maxStack  int
+	oper      *TokenOperator
+}
+
+// lower the program to bytecode
+func (prog *Program) Bytecode() *VMProgram {
+	vp := &VMProgram{oper: prog.oper}
+	vp.emit(prog.root, 0)
+	return vp
+}
+
+// compile the str to bytecode, see Program.Bytecode
+func (p *Praser) CompileBytecode(str string) (*VMProgram, error) {
+	prog, err := p.Compile(str)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Bytecode(), nil
+}
+
+// emit the node in post order, depth is the stack size before the node
+func (vp *VMProgram) emit(n *astNode, depth int) {
+	for i, child := range n.children {
+		vp.emit(child, depth+i)
+	}
+
+	ins := instruction{}
+	switch n.nodeType {
+	case astNodeValue:
+		ins.op, ins.arg = opConst, int32(len(vp.consts))
+		vp.consts = append(vp.consts, newVMValue(n.value))
+	case astNodeVar:
+		ins.op, ins.arg = opVar, int32(len(vp.vars))
+		vp.vars = append(vp.vars, n.value)
+	case astNodeUnary:
+		ins.op, ins.arg = opUnary, int32(n.oper)
+	case astNodeBinary:
+		ins.op, ins.arg = opBinary, int32(n.oper)
+	case astNodeThirdOper:
+		ins.op = opThirdOper
+	case astNodeFunc:
+		ins.op, ins.arg, ins.argc = opCall, int32(len(vp.funcNames)), uint32(len(n.children))
+		vp.funcNames = append(vp.funcNames, n.funcName())
+	}
+	vp.code = append(vp.code, ins)
+
+	if size := depth + len(n.children) + 1; size > vp.maxStack {
+		vp.maxStack = size
+	}
+}
+
+func (vp *VMProgram) Eval() (*TokenNode, error) {
+	return vp.EvalContext(context.Background())
+}
+
+// the same as Program.EvalContext
+func (vp *VMProgram) EvalContext(ctx context.Context) (*TokenNode, error) {
+	return vp.run(newEvaluator(ctx, vp.oper), make([]vmValue, 0, vp.maxStack))
+}
+
+// run the bytecode with the stack, the stack can be reused after run
+func (vp *VMProgram) run(e *evaluator, stack []vmValue) (*TokenNode, error) {
+	for _, ins := range vp.code {
+		if ins.op == opConst {
+			stack = append(stack, vp.consts[ins.arg])
+			continue
+		}
+		if err := e.step(); err != nil {
+			return nil, err
+		}
+
+		top := len(stack) - 1
+		switch ins.op {
+		case opVar:
+			v, err := vp.loadVar(e, vp.vars[ins.arg])
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, v)
+		case opUnary:
+			v, err := vp.unary(int(ins.arg), &stack[top])
+			if err != nil {
+				return nil, err
+			}
+			stack[top] = v
+		case opBinary:
+			v, err := vp.binary(int(ins.arg), &stack[top-1], &stack[top])
+			if err != nil {
+				return nil, err
+			}
+			stack[top-1] = v
+			stack = stack[:top]
+		case opThirdOper:
+			v, err := vp.thirdOper(&stack[top-2], &stack[top-1], &stack[top])
+			if err != nil {
+				return nil, err
+			}
+			stack[top-2] = v
+			stack = stack[:top-1]
+		case opCall:
+			argc := int(ins.argc)
+			args := make([]*TokenNode, argc)
+			for i := range args {
+				args[i] = stack[len(stack)-argc+i].tokenNode()
+			}
+			res, err := e.callFunc(vp.funcNames[ins.arg], args)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack[:len(stack)-argc], newVMValue(res))
+		default:
+			return nil, GetError(ErrRuleEngineUnknownOperator, fmt.Sprintf("unknown opcode: %v", ins.op))
+		}
+	}
+
+	return vp.oper.roundResult(stack[0].tokenNode()), nil
+}
+
+func (vp *VMProgram) loadVar(e *evaluator, name *TokenNode) (vmValue, error) {
+	if variable, ok := vp.oper.varMap[name.GetString()]; ok && e.record == nil {
+		return newVMValue(variable), nil
+	}
+	variable, err := e.loadVar(name)
+	if err != nil {
+		return vmValue{}, err
+	}
+	return newVMValue(variable), nil
+}
+
+func (vp *VMProgram) unary(oper int, x *vmValue) (vmValue, error) {
+	if res, ok := vp.oper.fastUnary(oper, x); ok {
+		return res, nil
+	}
+	res, err := unaryOperFuncs[oper](vp.oper, x.tokenNode())
+	if err != nil {
+		return vmValue{}, err
+	}
+	return newVMValue(res), nil
+}
+
+func (vp *VMProgram) binary(oper int, x *vmValue, y *vmValue) (vmValue, error) {
+	if res, ok := vp.oper.fastBinary(oper, x, y); ok {
+		return res, nil
+	}
+	res, err := binaryOperFuncs[oper](vp.oper, x.tokenNode(), y.tokenNode())
+	if err != nil {
+		return vmValue{}, err
+	}
+	return newVMValue(res), nil
+}
+
+func (vp *VMProgram) thirdOper(x *vmValue, c *vmValue, y *vmValue) (vmValue, error) {
+	if c.typ == ValueTypeBool {
+		if c.b {
+			return *x, nil
+		}
+		return *y, nil
+	}
+	// get the error
+	_, err := vp.oper.tokenNodeThirdOper(x.tokenNode(), c.tokenNode(), y.tokenNode())
+	return vmValue{}, err
+}
+
+func newVMValue(t *TokenNode) vmValue {
+	v := vmValue{typ: t.ValueType}
+	switch t.ValueType {
+	case ValueTypeInteger:
+		v.i = t.GetInt()
+	case ValueTypeFloat:
+		v.f = t.GetFloat()
+	case ValueTypeBool:
+		v.b = t.GetBool()
+	case ValueTypeString:
+		v.s = t.GetString()
+	default:
+		v.ref = t.Value
+	}
+	return v
+}
+
+func (v *vmValue) tokenNode() *TokenNode {
+	switch v.typ {
+	case ValueTypeInteger:
+		return GetTokenNode(v.typ, v.i)
+	case ValueTypeFloat:
+		return GetTokenNode(v.typ, v.f)
+	case ValueTypeBool:
+		return GetTokenNode(v.typ, v.b)
+	case ValueTypeString:
+		return GetTokenNode(v.typ, v.s)
+	}
+	return GetTokenNode(v.typ, v.ref)
+}
+
+func vmInt(i int64) vmValue {
+	return vmValue{typ: ValueTypeInteger, i: i}
+}
+
+func vmFloat(f float64) vmValue {
+	return vmValue{typ: ValueTypeFloat, f: f}
+}
+
+func vmBool(b bool) vmValue {
+	return vmValue{typ: ValueTypeBool, b: b}
+}
+
+func (v *vmValue) isNumber() bool {
+	return v.typ == ValueTypeInteger || v.typ == ValueTypeFloat
+}
+
+func (v *vmValue) float() float64 {
+	if v.typ == ValueTypeInteger {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// the fast path of the tokenNode operators for int, float and bool.
+// return false if it is not the common case, then the tokenNode operator is used,
+// so the result and the error are always the same as the tokenNode operators.
+func (o *TokenOperator) fastUnary(oper int, x *vmValue) (vmValue, bool) {
+	switch {
+	case oper == NOT && x.typ == ValueTypeBool:
+		return vmBool(!x.b), true
+	case oper == '-' && x.typ == ValueTypeInteger && x.i != math.MinInt64:
+		return vmInt(-x.i), true
+	case oper == '-' && x.typ == ValueTypeFloat && !o.decimalMode:
+		return vmFloat(-x.f), true
+	}
+	return vmValue{}, false
+}
+
+func (o *TokenOperator) fastBinary(oper int, x *vmValue, y *vmValue) (vmValue, bool) {
+	switch {
+	case x.typ == ValueTypeInteger && y.typ == ValueTypeInteger:
+		return fastIntBinary(oper, x.i, y.i)
+	case x.typ == ValueTypeBool && y.typ == ValueTypeBool:
+		switch oper {
+		case AND:
+			return vmBool(x.b && y.b), true
+		case OR:
+			return vmBool(x.b || y.b), true
+		case EQ:
+			return vmBool(x.b == y.b), true
+		case NE:
+			return vmBool(x.b != y.b), true
+		}
+	case x.typ == ValueTypeString && y.typ == ValueTypeString:
+		switch oper {
+		case EQ:
+			return vmBool(x.s == y.s), true
+		case NE:
+			return vmBool(x.s != y.s), true
+		}
+	case x.isNumber() && y.isNumber() && !o.decimalMode:
+		// one of them is float, calculate in float
+		return o.fastFloatBinary(oper, x.float(), y.float())
+	}
+	return vmValue{}, false
+}
+
+func fastIntBinary(oper int, x int64, y int64) (vmValue, bool) {
+	var res int64
+	ok := false
+	switch oper {
+	case '+':
+		res, ok = intAdd(x, y)
+	case '-':
+		res, ok = intSub(x, y)
+	case '*':
+		res, ok = intMul(x, y)
+	case '/':
+		if y != 0 {
+			res, ok = intDiv(x, y)
+		}
+	case '%':
+		if y != 0 {
+			res, ok = intMod(x, y)
+		}
+	case '<':
+		return vmBool(x < y), true
+	case '>':
+		return vmBool(x > y), true
+	case LE:
+		return vmBool(x <= y), true
+	case GE:
+		return vmBool(x >= y), true
+	case EQ:
+		return vmBool(x == y), true
+	case NE:
+		return vmBool(x != y), true
+	}
+	if !ok {
+		return vmValue{}, false
+	}
+	return vmInt(res), true
+}
+
+func (o *TokenOperator) fastFloatBinary(oper int, x float64, y float64) (vmValue, bool) {
+	switch oper {
+	case '+':
+		return vmFloat(x + y), true
+	case '-':
+		return vmFloat(x - y), true
+	case '*':
+		return vmFloat(x * y), true
+	case '/':
+		if y != 0 {
+			return vmFloat(x / y), true
+		}
+	case '<':
+		return vmBool(x < y), true
+	case '>':
+		return vmBool(x > y), true
+	case LE:
+		return vmBool(x <= y), true
+	case GE:
+		return vmBool(x >= y), true
+	case EQ:
+		return vmBool(o.floatEqual.equal(x, y)), true
+	case NE:
+		return vmBool(!o.floatEqual.equal(x, y)), true
+	}
+	return vmValue{}, false
+}